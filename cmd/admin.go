@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+)
+
+func addAdminFlags(cmd *cobra.Command) {
+	cmd.Flags().String("admin-listen", "", "serve /metrics (Prometheus), /healthz and /readyz on this address, e.g. 127.0.0.1:9090")
+}
+
+// serveAdmin starts the admin listener if --admin-listen is set, returning the readiness reported by /readyz
+func serveAdmin(cmd *cobra.Command, logger *slog.Logger) *metrics.Readiness {
+	addr, err := cmd.Flags().GetString("admin-listen")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	ready := &metrics.Readiness{}
+	if addr == "" {
+		return ready
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		die("could not listen on admin address %s: %v\n", addr, err)
+	}
+	logger.Info("admin server listening", "addr", listener.Addr().String())
+	go func() {
+		if err := http.Serve(listener, metrics.AdminHandler(ready)); err != nil {
+			logger.Error("error running admin server", "error", err)
+		}
+	}()
+	return ready
+}
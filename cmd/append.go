@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var appendCmd = &cobra.Command{
+	Use:   "append",
+	Short: "Add local files to an existing (remote) zip archive, without rewriting it",
+	Long: `Add local files to an existing (remote) zip archive, without rewriting it.
+Only the new files and a new central directory are uploaded: on S3, the existing members are copied
+server-side (using UploadPartCopy) and never downloaded. The archive is replaced atomically,
+and left unchanged if it was modified while appending.`,
+	Example: "cz append s3://example-bucket/path/to/archive.zip logs/2024-06-01/",
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dest := destinationURI(args[0])
+		directory, err := cmd.Flags().GetString("directory")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		order, err := cmd.Flags().GetString("order")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		reverse, err := cmd.Flags().GetBool("reverse")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		skipExisting, err := cmd.Flags().GetBool("skip-existing")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSizeStr, err := cmd.Flags().GetString("part-size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSize, err := parseByteSize(partSizeStr)
+		if err != nil {
+			die("%v\n", err)
+		}
+		selectMethod := methodSelectorFromFlags(cmd, false)
+
+		entries, err := cloudzip.CollectEntries(directory, args[1:])
+		if err != nil {
+			die("could not read local files: %v\n", err)
+		}
+		if err := cloudzip.SortEntries(entries, order, reverse); err != nil {
+			die("%v\n", err)
+		}
+		summary, err := newClient().Append(cmd.Context(), dest, entries, &cloudzip.AppendOptions{
+			CreateOptions: cloudzip.CreateOptions{
+				Method:      selectMethod,
+				Parallelism: parallelism,
+				OnProgress: func(entry *cloudzip.CreateEntry, method uint16, compressedSize int64) {
+					if verbose {
+						_, _ = fmt.Fprintf(os.Stderr, "added: %s (%s, %d -> %d bytes)\n",
+							entry.Name, zipfile.CompressionMethodName(method), entry.Size, compressedSize)
+					}
+				},
+			},
+			SkipExisting: skipExisting,
+		}, remote.WithPartSize(int64(partSize)))
+		if err != nil {
+			die("could not append to zip file: %v\n", err)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, summary)
+	},
+}
+
+func init() {
+	appendCmd.Flags().StringP("directory", "C", "", "resolve paths relative to this directory (and name members accordingly)")
+	appendCmd.Flags().StringP("method", "m", "deflate", "default compression method (store | deflate | zstd)")
+	appendCmd.Flags().StringSlice("method-for", nil, "compression method for an extension, e.g. '.csv=zstd' (may be repeated)")
+	appendCmd.Flags().String("store-smaller-than", "", "store (don't compress) files smaller than this size, e.g. 4KiB")
+	appendCmd.Flags().Bool("compress-all", false, "also compress files whose extension denotes an already compressed format (.jpg, .gz, ...)")
+	appendCmd.Flags().IntP("parallelism", "p", cloudzip.DefaultCreateParallelism, "number of files to compress concurrently")
+	appendCmd.Flags().String("order", "name", "order of the added files (name | size | mtime | ext | none)")
+	appendCmd.Flags().BoolP("reverse", "r", false, "reverse the order of the added files")
+	appendCmd.Flags().Bool("skip-existing", false, "skip files that already exist in the archive, instead of failing")
+	appendCmd.Flags().String("part-size", "64MiB", "size of multipart upload parts")
+	appendCmd.Flags().BoolP("verbose", "v", false, "print every added file")
+	rootCmd.AddCommand(appendCmd)
+}
@@ -4,46 +4,133 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
-	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
 	"github.com/ozkatz/cloudzip/pkg/zipfile"
 )
 
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// catRecords resolves member names and glob patterns to records, in the order they were given.
+// A single literal name only scans the central directory until the member is found.
+func catRecords(cmd *cobra.Command, uri string, names []string) (*cloudzip.Archive, []*zipfile.CDR) {
+	client := newClient()
+	if len(names) == 1 && !isGlob(names[0]) {
+		archive, record, err := client.Member(cmd.Context(), uri, names[0])
+		if err != nil {
+			die("could not open zip file stream: %v\n", err)
+		}
+		return archive, []*zipfile.CDR{record}
+	}
+	archive, err := client.Open(cmd.Context(), uri)
+	if err != nil {
+		die("could not open zip file stream: %v\n", err)
+	}
+	records := make([]*zipfile.CDR, 0, len(names))
+	for _, name := range names {
+		if !isGlob(name) {
+			record, err := archive.Stat(name)
+			if err != nil {
+				die("could not open zip file stream: %s: %v\n", name, err)
+			}
+			records = append(records, record)
+			continue
+		}
+		matches, err := archive.Glob(name)
+		if err != nil {
+			die("invalid pattern: %v\n", err)
+		}
+		found := false
+		for _, record := range matches {
+			if !record.Mode.IsDir() {
+				records = append(records, record)
+				found = true
+			}
+		}
+		if !found {
+			die("no files matching '%s'\n", name)
+		}
+	}
+	return archive, records
+}
+
 var catCmd = &cobra.Command{
-	Use:     "cat",
-	Short:   "Extract a specific file from the remote archive to stdout",
-	Example: "cz cat s3://example-bucket/path/to/archive.zip images/file.png > image.png",
-	Args:    cobra.ExactArgs(2),
+	Use:   "cat",
+	Short: "Extract specific files from the remote archive to stdout",
+	Long: `Extract specific files from the remote archive to stdout.
+Multiple names (or glob patterns) are concatenated in the order given.
+For stored (uncompressed) files, --offset/--length/--tail translate into a direct range request.`,
+	Example: `cz cat s3://example-bucket/path/to/archive.zip images/file.png > image.png
+cz cat s3://example-bucket/path/to/archive.zip 'logs/*.log' --tail 1024`,
+	Args: cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		remoteFile := args[0]
-		internalPath := args[1]
-		uri, err := expandStdin(remoteFile)
+		offset, err := cmd.Flags().GetInt64("offset")
 		if err != nil {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("could not read stdin: %v\n", err))
-			os.Exit(1)
+			die("could not parse command flags: %v\n", err)
 		}
-		ctx := cmd.Context()
-		obj, err := remote.Object(uri)
+		length, err := cmd.Flags().GetInt64("length")
 		if err != nil {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("could not open zip file: %v\n", err))
-			os.Exit(1)
+			die("could not parse command flags: %v\n", err)
 		}
-		zip := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, obj))
-		reader, err := zip.Read(internalPath)
+		tail, err := cmd.Flags().GetInt64("tail")
 		if err != nil {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("could not open zip file stream: %v\n", err))
-			os.Exit(1)
+			die("could not parse command flags: %v\n", err)
+		}
+		raw, err := cmd.Flags().GetBool("raw")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		ranged := cmd.Flags().Changed("offset") || cmd.Flags().Changed("length") || cmd.Flags().Changed("tail")
+		if offset < 0 || tail < 0 {
+			die("--offset and --tail must not be negative\n")
 		}
-		_, err = io.Copy(os.Stdout, reader)
+		if cmd.Flags().Changed("tail") && (cmd.Flags().Changed("offset") || cmd.Flags().Changed("length")) {
+			die("--tail cannot be combined with --offset or --length\n")
+		}
+		if raw && ranged {
+			die("--raw cannot be combined with --offset, --length or --tail\n")
+		}
+		uri, err := expandStdin(remoteFile)
 		if err != nil {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("could not download file: %v\n", err))
-			os.Exit(1)
+			die("could not read stdin: %v\n", err)
+		}
+
+		archive, records := catRecords(cmd, uri, args[1:])
+		for _, record := range records {
+			var reader io.ReadCloser
+			switch {
+			case raw:
+				reader, err = archive.OpenRaw(record)
+			case cmd.Flags().Changed("tail"):
+				reader, err = archive.OpenRange(record, max(0, int64(record.UncompressedSizeBytes)-tail), -1)
+			case ranged:
+				reader, err = archive.OpenRange(record, offset, length)
+			default:
+				reader, err = archive.OpenRecord(record)
+			}
+			if err != nil {
+				die("could not open zip file stream: %s: %v\n", record.FileName, err)
+			}
+			_, err = io.Copy(os.Stdout, reader)
+			_ = reader.Close()
+			if err != nil {
+				_, _ = os.Stderr.WriteString(fmt.Sprintf("could not download file: %v\n", err))
+				exit(1)
+			}
 		}
 	},
 }
 
 func init() {
+	catCmd.Flags().Int64("offset", 0, "start reading each file at this (uncompressed) byte offset")
+	catCmd.Flags().Int64("length", -1, "read at most this many bytes of each file (-1 to read until the end)")
+	catCmd.Flags().Int64("tail", 0, "only read the last N bytes of each file")
+	catCmd.Flags().Bool("raw", false, "write the compressed bytes of each file, exactly as stored in the archive")
 	rootCmd.AddCommand(catCmd)
 }
@@ -2,16 +2,30 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
 	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 	"github.com/ozkatz/cloudzip/pkg/zipfile"
 )
 
+// serverAnnotation marks long-running commands, not traced as a single span
+const serverAnnotation = "server"
+
+// tracingFlushTimeout bounds how long exiting commands wait for pending spans to be exported
+const tracingFlushTimeout = 5 * time.Second
+
 func expandStdin(arg string) (string, error) {
 	if arg != "-" {
 		return arg, nil
@@ -29,7 +43,13 @@ func die(fstring string, args ...interface{}) {
 		fstring += "\n"
 	}
 	_, _ = os.Stderr.WriteString(fmt.Sprintf(fstring, args...))
-	os.Exit(1)
+	exit(1)
+}
+
+// exit exits with the given status code, once the command's spans are exported (see flushTracing)
+func exit(code int) {
+	flushTracing()
+	os.Exit(code)
 }
 
 func setupLogging() {
@@ -45,6 +65,43 @@ func setupLogging() {
 	}
 }
 
+var (
+	// shutdownTracing flushes the spans recorded by the command, see setupTracing
+	shutdownTracing = func(context.Context) error { return nil }
+	// commandSpan traces the entire run of a command, unless it is a server
+	commandSpan trace.Span
+)
+
+// setupTracing exports spans as configured by the OTEL_* environment variables, tracing the run
+// of cmd as a single span. Servers trace each request they serve instead: see serverAnnotation.
+func setupTracing(cmd *cobra.Command) {
+	shutdown, err := tracing.Setup(cmd.Context())
+	if err != nil {
+		die("could not set up tracing: %v\n", err)
+	}
+	shutdownTracing = shutdown
+	if _, isServer := cmd.Annotations[serverAnnotation]; !isServer {
+		var ctx context.Context
+		ctx, commandSpan = tracing.Start(cmd.Context(), cmd.CommandPath())
+		cmd.SetContext(ctx)
+	}
+}
+
+// flushTracing ends the command's span and exports any spans not exported yet,
+// giving up after tracingFlushTimeout
+func flushTracing() {
+	if commandSpan != nil {
+		commandSpan.End()
+		commandSpan = nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("could not export spans", "error", err)
+	}
+	shutdownTracing = func(context.Context) error { return nil }
+}
+
 func isDir(path string) (bool, error) {
 	stat, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -55,26 +112,27 @@ func isDir(path string) (bool, error) {
 	return stat.IsDir(), nil
 }
 
-func getCdr(remoteFile string) []*zipfile.CDR {
+// newClient returns the library client used by all commands
+func newClient() *cloudzip.Client {
+	return cloudzip.NewClient()
+}
+
+// iterCdr streams the central directory records of remoteFile to fn, in archive order,
+// without materializing the entire directory in memory.
+func iterCdr(ctx context.Context, remoteFile string, fn func(f *zipfile.CDR)) {
 	zipfilePath, err := expandStdin(remoteFile)
 	if err != nil {
-		_, _ = os.Stderr.WriteString(fmt.Sprintf("could not read stdin: %v\n", err))
-		os.Exit(1)
+		die("could not read stdin: %v\n", err)
 	}
-	ctx := context.Background()
-	obj, err := remote.Object(zipfilePath)
-	if err != nil {
-		_, _ = os.Stderr.WriteString(fmt.Sprintf("could not open remote zip file: %v\n", err))
-		os.Exit(1)
-	}
-	zip := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, obj))
-
-	files, err := zip.GetCentralDirectory()
-	if err != nil {
-		_, _ = os.Stderr.WriteString(fmt.Sprintf("could not read zip file contents: %v\n", err))
-		os.Exit(1)
+	err = newClient().List(ctx, zipfilePath, func(f *zipfile.CDR) error {
+		fn(f)
+		return nil
+	})
+	if errors.Is(err, remote.ErrDoesNotExist) || errors.Is(err, remote.ErrInvalidURI) {
+		die("could not open remote zip file: %v\n", err)
+	} else if err != nil {
+		die("could not read zip file contents: %v\n", err)
 	}
-	return files
 }
 
 func byteCountIEC(b uint64) string {
@@ -90,3 +148,21 @@ func byteCountIEC(b uint64) string {
 	return fmt.Sprintf("%.1f %ciB",
 		float64(b)/float64(div), "KMGTPE"[exp])
 }
+
+// parseByteSize parses sizes such as "512", "10K", "1.5MiB" or "2GB" (all units are powers of 1024)
+func parseByteSize(s string) (uint64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	multiplier := uint64(1)
+	if str != "" {
+		if i := strings.IndexByte("KMGTPE", str[len(str)-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			str = str[:len(str)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+// destinationURI allows plain local paths as destinations
+func destinationURI(dest string) string {
+	if strings.Contains(dest, "://") {
+		return dest
+	}
+	if abs, err := filepath.Abs(dest); err == nil {
+		dest = abs
+	}
+	return "file://" + filepath.ToSlash(dest)
+}
+
+// methodSelectorFromFlags builds a cloudzip.MethodSelector from --method, --method-for and --store-smaller-than.
+// If allowKeep is set, --method may also be "keep", keeping the method of existing members.
+func methodSelectorFromFlags(cmd *cobra.Command, allowKeep bool) cloudzip.MethodSelector {
+	methodName, err := cmd.Flags().GetString("method")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	methodFor, err := cmd.Flags().GetStringSlice("method-for")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	storeSmallerThan, err := cmd.Flags().GetString("store-smaller-than")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	compressAll, err := cmd.Flags().GetBool("compress-all")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	keep := allowKeep && methodName == "keep"
+	defaultMethod := cloudzip.KeepMethod
+	if !keep {
+		defaultMethod, err = cloudzip.ParseCompressionMethod(methodName)
+		if err != nil {
+			die("%v\n", err)
+		}
+	}
+	byExtension := make(map[string]uint16)
+	if !compressAll && !keep {
+		for _, ext := range cloudzip.DefaultStoredExtensions {
+			byExtension[ext] = zip.Store
+		}
+	}
+	for _, rule := range methodFor {
+		ext, name, found := strings.Cut(rule, "=")
+		if !found {
+			die("invalid --method-for value: '%s', expected '.ext=method'\n", rule)
+		}
+		method, err := cloudzip.ParseCompressionMethod(name)
+		if err != nil {
+			die("%v\n", err)
+		}
+		byExtension["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = method
+	}
+	var storeBelow uint64
+	if storeSmallerThan != "" {
+		storeBelow, err = parseByteSize(storeSmallerThan)
+		if err != nil {
+			die("%v\n", err)
+		}
+	}
+	return cloudzip.NewMethodSelector(defaultMethod, byExtension, int64(storeBelow))
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a zip archive of local files, writing it directly to its (remote) destination",
+	Long: `Create a zip archive of local files, writing it directly to its (remote) destination.
+The archive is streamed (as a multipart upload, for S3) while files are compressed in parallel,
+so it is never buffered locally. Archives larger than 4GiB or with more than 65535 files use ZIP64.`,
+	Example: `cz create s3://example-bucket/path/to/archive.zip data/ images/
+cz create s3://example-bucket/path/to/archive.zip . -C some_dir/ --method zstd --method-for .csv=deflate --order size`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dest := destinationURI(args[0])
+		directory, err := cmd.Flags().GetString("directory")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		order, err := cmd.Flags().GetString("order")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		reverse, err := cmd.Flags().GetBool("reverse")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSizeStr, err := cmd.Flags().GetString("part-size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSize, err := parseByteSize(partSizeStr)
+		if err != nil {
+			die("%v\n", err)
+		}
+		selectMethod := methodSelectorFromFlags(cmd, false)
+
+		entries, err := cloudzip.CollectEntries(directory, args[1:])
+		if err != nil {
+			die("could not read local files: %v\n", err)
+		}
+		if err := cloudzip.SortEntries(entries, order, reverse); err != nil {
+			die("%v\n", err)
+		}
+		summary, err := newClient().Create(cmd.Context(), dest, entries, &cloudzip.CreateOptions{
+			Method:      selectMethod,
+			Parallelism: parallelism,
+			OnProgress: func(entry *cloudzip.CreateEntry, method uint16, compressedSize int64) {
+				if verbose {
+					_, _ = fmt.Fprintf(os.Stderr, "added: %s (%s, %d -> %d bytes)\n",
+						entry.Name, zipfile.CompressionMethodName(method), entry.Size, compressedSize)
+				}
+			},
+		}, remote.WithPartSize(int64(partSize)))
+		if err != nil {
+			die("could not create zip file: %v\n", err)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, summary)
+	},
+}
+
+func init() {
+	createCmd.Flags().StringP("directory", "C", "", "resolve paths relative to this directory (and name members accordingly)")
+	createCmd.Flags().StringP("method", "m", "deflate", "default compression method (store | deflate | zstd)")
+	createCmd.Flags().StringSlice("method-for", nil, "compression method for an extension, e.g. '.csv=zstd' (may be repeated)")
+	createCmd.Flags().String("store-smaller-than", "", "store (don't compress) files smaller than this size, e.g. 4KiB")
+	createCmd.Flags().Bool("compress-all", false, "also compress files whose extension denotes an already compressed format (.jpg, .gz, ...)")
+	createCmd.Flags().IntP("parallelism", "p", cloudzip.DefaultCreateParallelism, "number of files to compress concurrently")
+	createCmd.Flags().String("order", "name", "order of files in the archive (name | dir | size | mtime | ext | none)")
+	createCmd.Flags().BoolP("reverse", "r", false, "reverse the order of files in the archive")
+	createCmd.Flags().String("part-size", "64MiB", "size of multipart upload parts")
+	createCmd.Flags().BoolP("verbose", "v", false, "print every added file")
+	rootCmd.AddCommand(createCmd)
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var diffKindCodes = map[cloudzip.DiffKind]string{
+	cloudzip.DiffAdded:    "A",
+	cloudzip.DiffRemoved:  "D",
+	cloudzip.DiffModified: "M",
+	cloudzip.DiffRenamed:  "R",
+}
+
+// diffOutput is the machine-readable representation of a single difference
+type diffOutput struct {
+	Kind    cloudzip.DiffKind `json:"kind"`
+	Name    string            `json:"name"`
+	OldName string            `json:"old_name,omitempty"`
+	OldSize *uint64           `json:"old_size,omitempty"`
+	NewSize *uint64           `json:"new_size,omitempty"`
+	OldCRC  string            `json:"old_crc32,omitempty"`
+	NewCRC  string            `json:"new_crc32,omitempty"`
+	Diff    string            `json:"diff,omitempty"`
+}
+
+func newDiffOutput(entry *cloudzip.DiffEntry, diff string) *diffOutput {
+	out := &diffOutput{Kind: entry.Kind, Name: entry.Name(), Diff: diff}
+	if entry.Old != nil {
+		out.OldSize = &entry.Old.UncompressedSizeBytes
+		out.OldCRC = fmt.Sprintf("%08x", entry.Old.CRC32Uncompressed)
+		if entry.Kind == cloudzip.DiffRenamed {
+			out.OldName = entry.Old.FileName
+		}
+	}
+	if entry.New != nil {
+		out.NewSize = &entry.New.UncompressedSizeBytes
+		out.NewCRC = fmt.Sprintf("%08x", entry.New.CRC32Uncompressed)
+	}
+	return out
+}
+
+func formatOptionalSize(n *uint64) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatUint(*n, 10)
+}
+
+// diffSide opens a remote archive, or a local directory
+func diffSide(ctx context.Context, arg string, filter cloudzip.Matcher) *cloudzip.DiffSide {
+	var side *cloudzip.DiffSide
+	dir, err := isDir(arg)
+	if err != nil {
+		die("could not stat '%s': %v\n", arg, err)
+	}
+	if dir {
+		side, err = cloudzip.LocalDiffSide(arg)
+		if err != nil {
+			die("could not read local directory '%s': %v\n", arg, err)
+		}
+	} else {
+		archive, err := newClient().Open(ctx, arg)
+		if err != nil {
+			die("could not open zip file '%s': %v\n", arg, err)
+		}
+		side = cloudzip.ArchiveDiffSide(archive)
+	}
+	records := make([]*zipfile.CDR, 0, len(side.Records))
+	for _, record := range side.Records {
+		if filter(record) {
+			records = append(records, record)
+		}
+	}
+	side.Records = records
+	return side
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the files of two remote archives, or of an archive and a local directory",
+	Long: `Compare the files of two remote archives, or of an archive and a local directory.
+Files are compared by name, size and CRC from the central directory only, no file data is downloaded.
+With --content, small text files that were modified are fetched and shown as a unified diff.
+Exits with status 1 if there are differences.`,
+	Example: "cz diff s3://example-bucket/before.zip s3://example-bucket/after.zip",
+	Args:    cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := getOutputFormat(cmd)
+		filter := getFilter(cmd)
+		human, err := cmd.Flags().GetBool("human")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		showContent, err := cmd.Flags().GetBool("content")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		maxContentSizeStr, err := cmd.Flags().GetString("max-content-size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		maxContentSize, err := parseByteSize(maxContentSizeStr)
+		if err != nil {
+			die("%v\n", err)
+		}
+		oldURI, err := expandStdin(args[0])
+		if err != nil {
+			die("could not read stdin: %v\n", err)
+		}
+
+		oldSide := diffSide(cmd.Context(), oldURI, filter)
+		newSide := diffSide(cmd.Context(), args[1], filter)
+		entries := cloudzip.Diff(oldSide, newSide)
+
+		outputs := make([]*diffOutput, 0, len(entries))
+		counts := make(map[cloudzip.DiffKind]int)
+		for _, entry := range entries {
+			counts[entry.Kind]++
+			diff := ""
+			if showContent && entry.Kind == cloudzip.DiffModified {
+				diff, err = cloudzip.UnifiedDiff(oldSide, newSide, entry, maxContentSize)
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "%s: content not compared: %v\n", entry.Name(), err)
+				}
+			}
+			outputs = append(outputs, newDiffOutput(entry, diff))
+		}
+
+		w := bufio.NewWriter(os.Stdout)
+		switch format {
+		case outputJSON:
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(outputs)
+		case outputJSONL:
+			enc := json.NewEncoder(w)
+			for _, out := range outputs {
+				if err = enc.Encode(out); err != nil {
+					break
+				}
+			}
+		case outputCSV:
+			c := csv.NewWriter(w)
+			_ = c.Write([]string{"kind", "name", "old_name", "old_size", "new_size", "old_crc32", "new_crc32"})
+			for _, out := range outputs {
+				_ = c.Write([]string{string(out.Kind), out.Name, out.OldName,
+					formatOptionalSize(out.OldSize), formatOptionalSize(out.NewSize), out.OldCRC, out.NewCRC})
+			}
+			c.Flush()
+			err = c.Error()
+		default:
+			for _, out := range outputs {
+				code := diffKindCodes[out.Kind]
+				switch out.Kind {
+				case cloudzip.DiffRenamed:
+					_, err = fmt.Fprintf(w, "%s\t%s -> %s\n", code, out.OldName, out.Name)
+				case cloudzip.DiffModified:
+					_, err = fmt.Fprintf(w, "%s\t%s\t(%s -> %s)\n", code, out.Name,
+						formatSize(*out.OldSize, human), formatSize(*out.NewSize, human))
+				default:
+					_, err = fmt.Fprintf(w, "%s\t%s\n", code, out.Name)
+				}
+				if err == nil && out.Diff != "" {
+					_, err = w.WriteString(out.Diff)
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		checkOutput(err)
+		_, _ = fmt.Fprintf(os.Stderr, "%d added, %d removed, %d modified, %d renamed\n",
+			counts[cloudzip.DiffAdded], counts[cloudzip.DiffRemoved], counts[cloudzip.DiffModified], counts[cloudzip.DiffRenamed])
+		if len(entries) > 0 {
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	addOutputFlags(diffCmd)
+	addHumanFlag(diffCmd)
+	addFilterFlags(diffCmd)
+	diffCmd.Flags().Bool("content", false, "show a unified diff of modified text files")
+	diffCmd.Flags().String("max-content-size", "1MiB", "only compare the content of files up to this size")
+	rootCmd.AddCommand(diffCmd)
+}
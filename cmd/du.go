@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+)
+
+// duEntry is the machine-readable representation of a single `du` line
+type duEntry struct {
+	Path              string  `json:"path"`
+	IsDir             bool    `json:"is_dir"`
+	Files             uint64  `json:"files"`
+	CompressedBytes   uint64  `json:"compressed_bytes"`
+	UncompressedBytes uint64  `json:"uncompressed_bytes"`
+	CompressionRatio  float64 `json:"compression_ratio"`
+}
+
+func newDuEntry(t *archiveTree, info *commonfs.FileInfo, name string) *duEntry {
+	u := t.du(info.FullPath(), info.IsDir())
+	ratio := 1.0
+	if u.CompressedBytes > 0 {
+		ratio = float64(u.UncompressedBytes) / float64(u.CompressedBytes)
+	}
+	return &duEntry{
+		Path:              name,
+		IsDir:             info.IsDir(),
+		Files:             u.Files,
+		CompressedBytes:   u.CompressedBytes,
+		UncompressedBytes: u.UncompressedBytes,
+		CompressionRatio:  ratio,
+	}
+}
+
+// collectDu returns entries for info and its descendants, up to maxDepth levels below it (-1 for unlimited)
+func collectDu(t *archiveTree, info *commonfs.FileInfo, name string, depth, maxDepth int, all bool) []*duEntry {
+	entries := make([]*duEntry, 0)
+	if info.IsDir() && (maxDepth < 0 || depth < maxDepth) {
+		for _, child := range t.children(info.FullPath()) {
+			if !child.IsDir() && !all {
+				continue
+			}
+			entries = append(entries, collectDu(t, child, path.Join(name, child.Name()), depth+1, maxDepth, all)...)
+		}
+	}
+	return append(entries, newDuEntry(t, info, name))
+}
+
+// duOrder returns the ordering --sort selects, so an unsupported one is rejected before
+// anything is fetched
+func duOrder(by string) func(a, b *duEntry) bool {
+	var less func(a, b *duEntry) bool
+	switch by {
+	case "name":
+		less = func(a, b *duEntry) bool { return a.Path < b.Path }
+	case "size":
+		// largest first, like `du | sort -rh`
+		less = func(a, b *duEntry) bool { return a.UncompressedBytes > b.UncompressedBytes }
+	case "compressed":
+		less = func(a, b *duEntry) bool { return a.CompressedBytes > b.CompressedBytes }
+	case "files":
+		less = func(a, b *duEntry) bool { return a.Files > b.Files }
+	default:
+		die("unsupported sort order: '%s', select 'name', 'size', 'compressed' or 'files'", by)
+	}
+	return less
+}
+
+func sortDuEntries(entries []*duEntry, less func(a, b *duEntry) bool, reverse bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if reverse {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+}
+
+func writeDuEntries(entries []*duEntry, format outputFormat, human bool) error {
+	w := bufio.NewWriter(os.Stdout)
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+	case outputJSONL:
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+	case outputCSV:
+		c := csv.NewWriter(w)
+		_ = c.Write([]string{"path", "is_dir", "files", "compressed_bytes", "uncompressed_bytes", "compression_ratio"})
+		for _, e := range entries {
+			_ = c.Write([]string{
+				e.Path, strconv.FormatBool(e.IsDir), strconv.FormatUint(e.Files, 10),
+				strconv.FormatUint(e.CompressedBytes, 10), strconv.FormatUint(e.UncompressedBytes, 10),
+				strconv.FormatFloat(e.CompressionRatio, 'f', 2, 64),
+			})
+		}
+		c.Flush()
+		if err := c.Error(); err != nil {
+			return err
+		}
+	default:
+		for _, e := range entries {
+			_, err := fmt.Fprintf(w, "%-12s\t%-12s\t%8d\t%s\n",
+				formatSize(e.CompressedBytes, human), formatSize(e.UncompressedBytes, human), e.Files, e.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+var duCmd = &cobra.Command{
+	Use:     "du",
+	Short:   "Summarize compressed and uncompressed sizes per directory of the remote archive",
+	Example: "cz du s3://example-bucket/path/to/archive.zip images/ --max-depth 1 --human",
+	Args:    cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		root := ""
+		if len(args) > 1 {
+			root = args[1]
+		}
+		format := getOutputFormat(cmd)
+		human, err := cmd.Flags().GetBool("human")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		maxDepth, err := cmd.Flags().GetInt("max-depth")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		summarize, err := cmd.Flags().GetBool("summarize")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		sortBy, err := cmd.Flags().GetString("sort")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		reverse, err := cmd.Flags().GetBool("reverse")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		var less func(a, b *duEntry) bool
+		if sortBy != "" {
+			less = duOrder(sortBy)
+		}
+		if summarize {
+			maxDepth = 0
+		}
+
+		t := loadTree(cmd.Context(), args[0])
+		rootInfo := t.stat(root)
+		rootName := treeKey(root)
+		if rootName == "" {
+			rootName = "."
+		}
+		entries := collectDu(t, rootInfo, rootName, 0, maxDepth, all)
+		if less != nil {
+			sortDuEntries(entries, less, reverse)
+		}
+		checkOutput(writeDuEntries(entries, format, human))
+	},
+}
+
+func init() {
+	addOutputFlags(duCmd)
+	addHumanFlag(duCmd)
+	duCmd.Flags().IntP("max-depth", "d", -1, "report directories at most this many levels below the given path (-1 for unlimited)")
+	duCmd.Flags().BoolP("summarize", "s", false, "only report the total for the given path (same as --max-depth 0)")
+	duCmd.Flags().BoolP("all", "a", false, "report files, not just directories")
+	duCmd.Flags().String("sort", "", "sort entries by 'name', 'size', 'compressed' or 'files' (default: depth-first, like du)")
+	duCmd.Flags().BoolP("reverse", "r", false, "reverse the sort order")
+	rootCmd.AddCommand(duCmd)
+}
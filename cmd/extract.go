@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var extractCmd = &cobra.Command{
+	Use:     "extract",
+	Short:   "Extract files from the remote archive into a local directory",
+	Example: "cz extract s3://example-bucket/path/to/archive.zip 'images/*.png' -d data_dir/",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uri, err := expandStdin(args[0])
+		if err != nil {
+			die("could not read stdin: %v\n", err)
+		}
+		patterns := args[1:]
+		directory, err := cmd.Flags().GetString("directory")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		useRegex, err := cmd.Flags().GetBool("regex")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		prefixes, err := cmd.Flags().GetStringSlice("prefix")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		overwrite, err := cmd.Flags().GetBool("overwrite")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+
+		matchers := make([]cloudzip.Matcher, 0)
+		if len(patterns) > 0 {
+			var m cloudzip.Matcher
+			if useRegex {
+				m, err = cloudzip.RegexMatcher(patterns...)
+			} else {
+				m, err = cloudzip.GlobMatcher(patterns...)
+			}
+			if err != nil {
+				die("invalid pattern: %v\n", err)
+			}
+			matchers = append(matchers, m)
+		}
+		if len(prefixes) > 0 {
+			matchers = append(matchers, cloudzip.PrefixMatcher(prefixes...))
+		}
+
+		archive, err := newClient().Open(cmd.Context(), uri)
+		if err != nil {
+			die("could not open zip file: %v\n", err)
+		}
+		summary, err := archive.ExtractAll(cmd.Context(), directory, &cloudzip.ExtractOptions{
+			Match:       anyMatcher(matchers),
+			Parallelism: parallelism,
+			Overwrite:   overwrite,
+			OnProgress: func(event cloudzip.ExtractEvent, record *zipfile.CDR, err error) {
+				if err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "%s: %s: %v\n", event, record.FileName, err)
+				} else if verbose {
+					_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", event, record.FileName)
+				}
+			},
+		})
+		_, _ = fmt.Fprintln(os.Stderr, summary)
+		if err != nil {
+			exit(1)
+		}
+	},
+}
+
+// anyMatcher selects members matched by any of the given matchers, or all members if there are none
+func anyMatcher(matchers []cloudzip.Matcher) cloudzip.Matcher {
+	if len(matchers) == 0 {
+		return cloudzip.MatchAll
+	}
+	return func(record *zipfile.CDR) bool {
+		for _, m := range matchers {
+			if m(record) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func init() {
+	extractCmd.Flags().StringP("directory", "d", ".", "directory to extract files into")
+	extractCmd.Flags().IntP("parallelism", "p", cloudzip.DefaultExtractParallelism, "number of files to download concurrently")
+	extractCmd.Flags().Bool("regex", false, "treat patterns as regular expressions rather than globs")
+	extractCmd.Flags().StringSlice("prefix", nil, "extract files whose path starts with the given prefix (may be repeated)")
+	extractCmd.Flags().Bool("overwrite", false, "overwrite local files even if they have matching size and CRC")
+	extractCmd.Flags().BoolP("verbose", "v", false, "print every extracted file")
+	rootCmd.AddCommand(extractCmd)
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+)
+
+var errStdoutClosed = errors.New("stdout closed")
+
+var grepCmd = &cobra.Command{
+	Use:     "grep",
+	Short:   "Search the content of files in the remote archive for lines matching a pattern",
+	Example: "cz grep -n 'request-id: 1234' s3://example-bucket/path/to/logs.zip 'logs/2024-*/*.log'",
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := args[0]
+		uri, err := expandStdin(args[1])
+		if err != nil {
+			die("could not read stdin: %v\n", err)
+		}
+		globs := args[2:]
+		fixed, err := cmd.Flags().GetBool("fixed-strings")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		ignoreCase, err := cmd.Flags().GetBool("ignore-case")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		filesOnly, err := cmd.Flags().GetBool("files-with-matches")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		countOnly, err := cmd.Flags().GetBool("count")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		lineNumbers, err := cmd.Flags().GetBool("line-number")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		maxFileSizeStr, err := cmd.Flags().GetString("max-file-size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		var maxFileSize uint64
+		if maxFileSizeStr != "" {
+			maxFileSize, err = parseByteSize(maxFileSizeStr)
+			if err != nil {
+				die("%v\n", err)
+			}
+		}
+		match := cloudzip.MatchAll
+		if len(globs) > 0 {
+			match, err = cloudzip.GlobMatcher(globs...)
+			if err != nil {
+				die("invalid pattern: %v\n", err)
+			}
+		}
+		if _, err := cloudzip.CompileGrepPattern(pattern, fixed, ignoreCase); err != nil {
+			die("invalid pattern: %v\n", err)
+		}
+
+		archive, err := newClient().Open(cmd.Context(), uri)
+		if err != nil {
+			die("could not open zip file: %v\n", err)
+		}
+		out := bufio.NewWriter(os.Stdout)
+		matched, failed := false, false
+		err = archive.Grep(cmd.Context(), pattern, &cloudzip.GrepOptions{
+			Match:          match,
+			Fixed:          fixed,
+			IgnoreCase:     ignoreCase,
+			MaxFileSize:    maxFileSize,
+			FirstMatchOnly: filesOnly,
+			Parallelism:    parallelism,
+		}, func(result *cloudzip.GrepResult) error {
+			name := result.Record.FileName
+			if result.Err != nil {
+				failed = true
+				_, _ = fmt.Fprintf(os.Stderr, "cz grep: %s: %v\n", name, result.Err)
+				return nil
+			}
+			if result.Count > 0 {
+				matched = true
+			}
+			var err error
+			switch {
+			case filesOnly:
+				if result.Count > 0 {
+					_, err = fmt.Fprintln(out, name)
+				}
+			case countOnly:
+				_, err = fmt.Fprintf(out, "%s:%d\n", name, result.Count)
+			case result.Binary:
+				if result.Count > 0 {
+					_, err = fmt.Fprintf(out, "Binary file %s matches\n", name)
+				}
+			default:
+				for _, m := range result.Matches {
+					if m.Truncated {
+						_, _ = fmt.Fprintf(os.Stderr, "cz grep: %s:%d: line truncated to %d bytes\n",
+							name, m.LineNumber, cloudzip.MaxGrepLineSize)
+					}
+					if lineNumbers {
+						_, err = fmt.Fprintf(out, "%s:%d:%s\n", name, m.LineNumber, m.Line)
+					} else {
+						_, err = fmt.Fprintf(out, "%s:%s\n", name, m.Line)
+					}
+					if err != nil {
+						break
+					}
+				}
+			}
+			if err == nil {
+				// flush per member so results show up as they are found
+				err = out.Flush()
+			}
+			if errors.Is(err, syscall.EPIPE) {
+				return errStdoutClosed
+			} else if err != nil {
+				return fmt.Errorf("could not write output: %w", err)
+			}
+			return nil
+		})
+		// exit codes follow grep: 0 if a line matched, 1 if none did, 2 on errors
+		if errors.Is(err, errStdoutClosed) {
+			exit(0)
+		} else if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "cz grep: %v\n", err)
+			exit(2)
+		}
+		if failed {
+			exit(2)
+		}
+		if !matched {
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	grepCmd.Flags().BoolP("fixed-strings", "F", false, "interpret the pattern as a fixed string rather than a regular expression")
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "ignore case distinctions in the pattern and the data")
+	grepCmd.Flags().BoolP("files-with-matches", "l", false, "only print the names of files with matches")
+	grepCmd.Flags().BoolP("count", "c", false, "only print the number of matching lines per file")
+	grepCmd.Flags().BoolP("line-number", "n", false, "prefix each matching line with its line number")
+	grepCmd.Flags().String("max-file-size", "", "skip files larger than this uncompressed size (e.g. 10MiB)")
+	grepCmd.Flags().IntP("parallelism", "p", cloudzip.DefaultGrepParallelism, "number of files to search concurrently")
+	rootCmd.AddCommand(grepCmd)
+}
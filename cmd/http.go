@@ -1,83 +1,380 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/ozkatz/cloudzip/pkg/remote"
-	"github.com/ozkatz/cloudzip/pkg/zipfile"
 	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
+)
+
+const (
+	httpSigningKeyEnvVar = "CLOUDZIP_HTTP_SIGNING_KEY"
+	httpAuthTokenEnvVar  = "CLOUDZIP_HTTP_AUTH_TOKEN"
+	httpBasicAuthEnvVar  = "CLOUDZIP_HTTP_BASIC_AUTH"
+)
+
+const (
+	// readHeaderTimeout bounds the time clients may take to send request headers
+	readHeaderTimeout = 10 * time.Second
+	// upstreamFetchWait is how long a request waits for a slot when --max-upstream-fetches are in progress
+	upstreamFetchWait = time.Second
 )
 
+// httpSigningKey returns the key for signed URLs, from --signing-key or the environment
+func httpSigningKey(cmd *cobra.Command) []byte {
+	key, err := cmd.Flags().GetString("signing-key")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	if key == "" {
+		key = os.Getenv(httpSigningKeyEnvVar)
+	}
+	return []byte(key)
+}
+
+// readCredentialsFile reads one secret per line (a token, "user:password" or "access-key-id:secret-access-key"),
+// skipping empty lines and comments
+func readCredentialsFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var credentials []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		credentials = append(credentials, line)
+	}
+	return credentials, scanner.Err()
+}
+
+// httpAccessOptions returns handler options for the authentication and access control flags
+func httpAccessOptions(cmd *cobra.Command) []proxy.Option {
+	tokens, err := cmd.Flags().GetStringArray("auth-token")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	tokensFile, err := cmd.Flags().GetString("auth-token-file")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	basicAuth, err := cmd.Flags().GetStringArray("basic-auth")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	basicAuthFile, err := cmd.Flags().GetString("basic-auth-file")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+
+	if tokensFile != "" {
+		fileTokens, err := readCredentialsFile(tokensFile)
+		if err != nil {
+			die("could not read auth token file: %v\n", err)
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	if token := os.Getenv(httpAuthTokenEnvVar); token != "" {
+		tokens = append(tokens, token)
+	}
+	var opts []proxy.Option
+	if len(tokens) > 0 {
+		opts = append(opts, proxy.WithBearerTokens(tokens...))
+	}
+	if basicAuthFile != "" {
+		credentials, err := readCredentialsFile(basicAuthFile)
+		if err != nil {
+			die("could not read basic auth file: %v\n", err)
+		}
+		basicAuth = append(basicAuth, credentials...)
+	}
+	if credentials := os.Getenv(httpBasicAuthEnvVar); credentials != "" {
+		basicAuth = append(basicAuth, credentials)
+	}
+	for _, credentials := range basicAuth {
+		user, password, found := strings.Cut(credentials, ":")
+		if !found || user == "" {
+			die("invalid basic auth credentials for user '%s', expected 'user:password'\n", user)
+		}
+		opts = append(opts, proxy.WithBasicAuth(user, password))
+	}
+	if key := httpSigningKey(cmd); len(key) > 0 {
+		opts = append(opts, proxy.WithSigningKey(key))
+	}
+	return append(opts, accessListOptions(cmd)...)
+}
+
+// accessListOptions returns handler options for the --allow and --deny flags
+func accessListOptions(cmd *cobra.Command) []proxy.Option {
+	allow, err := cmd.Flags().GetStringSlice("allow")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	deny, err := cmd.Flags().GetStringSlice("deny")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	var opts []proxy.Option
+	if len(allow) > 0 || len(deny) > 0 {
+		list := &proxy.AccessList{}
+		for _, rules := range []struct {
+			values []string
+			dest   *[]*proxy.AccessRule
+		}{{allow, &list.Allow}, {deny, &list.Deny}} {
+			for _, value := range rules.values {
+				rule, err := proxy.ParseAccessRule(value)
+				if err != nil {
+					die("%v\n", err)
+				}
+				*rules.dest = append(*rules.dest, rule)
+			}
+		}
+		opts = append(opts, proxy.WithAccessList(list))
+	}
+	return opts
+}
+
 var httpCmd = &cobra.Command{
-	Use:     "http",
-	Short:   "Run HTTP proxy server mode",
-	Example: "http s3://example-bucket/path",
-	Args:    cobra.ExactArgs(1),
+	Use:         "http",
+	Short:       "Run HTTP proxy server mode",
+	Annotations: map[string]string{serverAnnotation: ""},
+	Long: `Run HTTP proxy server mode, serving the members of the archives under the given prefix.
+Anyone who can reach the server may read them, unless credentials are required using --auth-token(-file),
+--basic-auth(-file) or --signing-key (any of which then grants access), or access is limited using
+--allow and --deny rules of the form 'archive-glob' or 'archive-glob:member-glob'.
+Secrets passed as flags are visible to other local users (e.g. in ps): prefer the file flags, or set
+$` + httpAuthTokenEnvVar + `, $` + httpBasicAuthEnvVar + ` ('user:password') and $` + httpSigningKeyEnvVar + `.
+With --browse, the directories above the archives are listed too, showing each archive as a directory
+(supported for s3:// and file:// prefixes).`,
+	Example: `cz http s3://example-bucket/path
+cz http s3://example-bucket/path --listen 0.0.0.0:443 --tls-cert cert.pem --tls-key key.pem --basic-auth-file users.txt
+cz http s3://example-bucket/path --allow 'public/*.zip' --deny '*.zip:secrets'
+cz http s3://example-bucket/datasets/ --browse`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		remotePath := strings.TrimSuffix(args[0], "/")
-		bindAddress, err := cmd.Flags().GetString("listen")
+		opts := append(cacheOptions(cmd), httpAccessOptions(cmd)...)
+		browse, err := cmd.Flags().GetBool("browse")
 		if err != nil {
-			die("Could not parse command flag listen: %v\n", err)
+			die("could not parse command flags: %v\n", err)
+		}
+		if browse {
+			opts = append(opts, proxy.WithBrowsing())
 		}
+		serveHTTP(cmd, "http", "HTTP server", proxy.NewHandler(serverClient(cmd), remotePath, opts...))
+	},
+}
 
-		http.HandleFunc("/",
-			func(w http.ResponseWriter, r *http.Request) {
-				internalPath := r.URL.Query().Get("filename")
-				slog.Debug("HTTP Handler", "objectPath", r.URL.Path, "internalPath", internalPath)
-				obj, err := remote.Object(remotePath + r.URL.Path)
-				if err != nil {
-					slog.Warn("could not open zip file", "error", err,
-						"objectPath", r.URL.Path, "internalPath", internalPath)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				zip := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(r.Context(), obj))
-				reader, err := zip.Read(internalPath)
-				if errors.Is(err, remote.ErrDoesNotExist) || errors.Is(err, zipfile.ErrFileNotFound) {
-					slog.DebugContext(r.Context(), "not found",
-						"error", err,
-						"objectPath", r.URL.Path,
-						"internalPath", internalPath)
-					w.WriteHeader(http.StatusNotFound)
-					return
-				} else if err != nil {
-					w.WriteHeader(http.StatusBadGateway)
-					slog.Warn("Error reading zip file from upstream", "error", err)
-					return
-				}
-				n, err := io.Copy(w, reader)
-				if err != nil {
-					slog.ErrorContext(r.Context(), "error writing response",
-						"error", err,
-						"objectPath", r.URL.Path,
-						"internalPath", internalPath)
-				} else {
-					slog.DebugContext(r.Context(), "wrote response",
-						"objectPath", r.URL.Path,
-						"internalPath", internalPath,
-						"response_bytes", n)
-				}
-			})
+// cacheOptions returns handler options for the --cache-ttl and --cache-size flags
+func cacheOptions(cmd *cobra.Command) []proxy.Option {
+	cacheTTL, err := cmd.Flags().GetDuration("cache-ttl")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	cacheSizeStr, err := cmd.Flags().GetString("cache-size")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	cacheSize, err := parseByteSize(cacheSizeStr)
+	if err != nil {
+		die("%v\n", err)
+	}
+	return []proxy.Option{proxy.WithCacheTTL(cacheTTL), proxy.WithCacheSize(int64(cacheSize))}
+}
+
+// serverClient returns a client limiting concurrent requests to remote storage to --max-upstream-fetches
+func serverClient(cmd *cobra.Command) *cloudzip.Client {
+	maxFetches, err := cmd.Flags().GetInt("max-upstream-fetches")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	if maxFetches <= 0 {
+		return newClient()
+	}
+	limiter := remote.NewFetchLimiter(maxFetches, upstreamFetchWait)
+	return cloudzip.NewClient(cloudzip.WithFetcherMiddleware(limiter.Wrap))
+}
+
+// addServerFlags adds the flags used by serveHTTP and serverClient, along with the cache and access list flags
+func addServerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("listen", "l", "127.0.0.1:0", "address to listen on")
+	cmd.Flags().String("tls-cert", "", "serve HTTPS using this certificate (PEM file, along with --tls-key)")
+	cmd.Flags().String("tls-key", "", "private key for --tls-cert (PEM file)")
+	cmd.Flags().Duration("request-timeout", 0, "abort requests (including streaming the response) taking longer than this (0 for no limit)")
+	cmd.Flags().Duration("idle-timeout", 2*time.Minute, "close keep-alive connections idle for this long")
+	cmd.Flags().Duration("shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, wait this long for in-flight requests to complete")
+	cmd.Flags().Int("max-upstream-fetches", 0, "limit concurrent requests to remote storage, responding with 503 when exceeded (0 for no limit)")
+	cmd.Flags().Float64("rate-limit", 0, "limit each client (by IP address) to this many requests per second, responding with 429 when exceeded (0 for no limit)")
+	cmd.Flags().Int("rate-limit-burst", 20, "number of requests a client may make at once, above --rate-limit")
+	cmd.Flags().String("access-log", "", "write an access log line per request to stdout, as 'json' or 'clf' (Common Log Format)")
+	cmd.Flags().StringSlice("allow", nil, "only serve archives (and members) matching 'archive-glob[:member-glob]' (may be repeated)")
+	cmd.Flags().StringSlice("deny", nil, "don't serve archives (and members) matching 'archive-glob[:member-glob]' (may be repeated)")
+	cmd.Flags().Duration("cache-ttl", proxy.DefaultCacheTTL, "serve opened archives for this long before checking whether they were replaced")
+	cmd.Flags().String("cache-size", "256MiB", "approximate memory to use for opened archives (0 disables caching)")
+	addAdminFlags(cmd)
+}
+
+// wrapHandler adds the request timeout, rate limiting and access log set by the command's flags to handler
+func wrapHandler(cmd *cobra.Command, name string, handler http.Handler) http.Handler {
+	requestTimeout, err := cmd.Flags().GetDuration("request-timeout")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	rateLimit, err := cmd.Flags().GetFloat64("rate-limit")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	rateLimitBurst, err := cmd.Flags().GetInt("rate-limit-burst")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	accessLog, err := cmd.Flags().GetString("access-log")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	if requestTimeout > 0 {
+		handler = proxy.RequestTimeout(handler, requestTimeout)
+	}
+	if rateLimit > 0 {
+		handler = proxy.RateLimit(handler, rateLimit, rateLimitBurst)
+	}
+	handler = metrics.InstrumentHandler(name, tracing.Handler(name, handler))
+	if accessLog != "" {
+		handler, err = proxy.AccessLog(handler, os.Stdout, accessLog)
+		if err != nil {
+			die("%v\n", err)
+		}
+	}
+	return handler
+}
+
+// serveHTTP serves handler (instrumented as name) on --listen, using TLS if --tls-cert and --tls-key are set.
+// It returns once the server was shut down by SIGINT or SIGTERM, after in-flight requests completed.
+func serveHTTP(cmd *cobra.Command, name, description string, handler http.Handler) {
+	bindAddress, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		die("Could not parse command flag listen: %v\n", err)
+	}
+	tlsCert, err := cmd.Flags().GetString("tls-cert")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	tlsKey, err := cmd.Flags().GetString("tls-key")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		die("--tls-cert and --tls-key must be used together\n")
+	}
+	idleTimeout, err := cmd.Flags().GetDuration("idle-timeout")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	shutdownTimeout, err := cmd.Flags().GetDuration("shutdown-timeout")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+
+	server := &http.Server{
+		Handler:           wrapHandler(cmd, name, handler),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	ready := serveAdmin(cmd, slog.Default())
+
+	listener, err := net.Listen("tcp", bindAddress)
+	if err != nil {
+		die("Failed to bind port: %v\n", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		if tlsCert != "" {
+			fmt.Printf("%s listening on https://%s\n", description, listener.Addr().String())
+			errs <- server.ServeTLS(listener, tlsCert, tlsKey)
+		} else {
+			fmt.Printf("%s listening on http://%s\n", description, listener.Addr().String())
+			errs <- server.Serve(listener)
+		}
+	}()
+	ready.SetReady(true)
 
-		listener, err := net.Listen("tcp", bindAddress)
+	select {
+	case err = <-errs:
+		slog.Error("Error running "+description, "error", err)
+		return
+	case <-ctx.Done():
+	}
+	// a second signal terminates immediately
+	stop()
+	ready.SetReady(false)
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("in-flight requests did not complete in time, closing their connections", "timeout", shutdownTimeout)
+		_ = server.Close()
+	} else if err != nil {
+		slog.Error("Error shutting down "+description, "error", err)
+	}
+}
+
+var httpSignCmd = &cobra.Command{
+	Use:   "sign <path>",
+	Short: "Print a signed URL for a path served by cz http, valid without other credentials until it expires",
+	Long: `Print a signed URL for a path served by cz http, valid without other credentials until it expires.
+The path is relative to the prefix served, e.g. 'a/b/c.zip/foobar.png'. The server must use the same signing key.`,
+	Example: `CLOUDZIP_HTTP_SIGNING_KEY=... cz http sign a/b/c.zip/foobar.png --expires 24h --base-url https://example.com`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		expires, err := cmd.Flags().GetDuration("expires")
 		if err != nil {
-			die("Failed to bind port: %v\n", err)
+			die("could not parse command flags: %v\n", err)
 		}
-		fmt.Printf("HTTP server listening on http://%s\n", listener.Addr().String())
-		err = http.Serve(listener, nil)
+		baseURL, err := cmd.Flags().GetString("base-url")
 		if err != nil {
-			slog.Error("Error running HTTP server", "error", err)
+			die("could not parse command flags: %v\n", err)
+		}
+		key := httpSigningKey(cmd)
+		if len(key) == 0 {
+			die("a signing key is required: use --signing-key or set %s\n", httpSigningKeyEnvVar)
 		}
+		fmt.Println(strings.TrimSuffix(baseURL, "/") + proxy.SignPath(key, args[0], time.Now().Add(expires)))
 	},
 }
 
 func init() {
-	httpCmd.Flags().StringP("listen", "l", "127.0.0.1:0", "address to listen on")
+	httpCmd.PersistentFlags().String("signing-key", "", "key for signed URLs, visible to other local users: prefer $"+httpSigningKeyEnvVar)
+	httpSignCmd.Flags().Duration("expires", time.Hour, "how long the URL is valid for")
+	httpSignCmd.Flags().String("base-url", "", "URL of the server, e.g. https://example.com:8443")
+	httpCmd.AddCommand(httpSignCmd)
+
+	addServerFlags(httpCmd)
+	httpCmd.Flags().StringArray("auth-token", nil, "require this bearer token (may be repeated; visible to other local users, prefer --auth-token-file)")
+	httpCmd.Flags().String("auth-token-file", "", "require bearer tokens listed in this file, one per line")
+	httpCmd.Flags().StringArray("basic-auth", nil, "require basic auth credentials, as 'user:password' (may be repeated; visible to other local users, prefer --basic-auth-file)")
+	httpCmd.Flags().String("basic-auth-file", "", "require basic auth credentials listed in this file, one 'user:password' per line")
+	httpCmd.Flags().Bool("browse", false, "list the archives and directories under the prefix, showing archives as directories")
 	rootCmd.AddCommand(httpCmd)
 }
@@ -1,11 +1,131 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
 )
 
+type infoOutput struct {
+	ZipFile string `json:"zip_file"`
+	*cloudzip.Stats
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeInfoTable writes info as text, with all sizes in human readable form if human is set
+func writeInfoTable(w io.Writer, info *infoOutput, human bool) error {
+	buf := bufio.NewWriter(w)
+	s := info.Stats
+	_, _ = fmt.Fprintf(buf, "zip file: %s\n", info.ZipFile)
+	_, _ = fmt.Fprintf(buf, "files: %d\n", s.Files)
+	_, _ = fmt.Fprintf(buf, "total bytes (compressed): %s\n", formatSize(s.CompressedBytes, human))
+	_, _ = fmt.Fprintf(buf, "total bytes (uncompressed): %s\n", formatSize(s.UncompressedBytes, human))
+	if !human {
+		_, _ = fmt.Fprintf(buf, "total bytes (compressed, human readable): %s\n", byteCountIEC(s.CompressedBytes))
+		_, _ = fmt.Fprintf(buf, "total bytes (uncompressed, human readable): %s\n", byteCountIEC(s.UncompressedBytes))
+	}
+	_, _ = fmt.Fprintf(buf, "compression ratio: %.2f\n", s.CompressionRatio)
+	_, _ = fmt.Fprintf(buf, "directories: %d (%d explicit)\n", s.Directories, s.ExplicitDirectories)
+	_, _ = fmt.Fprintf(buf, "max depth: %d\n", s.MaxDepth)
+	_, _ = fmt.Fprintf(buf, "zip64: %t (%d entries)\n", s.Zip64, s.Zip64Entries)
+	_, _ = fmt.Fprintf(buf, "encrypted entries: %d\n", s.EncryptedEntries)
+	if human {
+		_, _ = fmt.Fprintf(buf, "central directory: offset %d, size %s\n",
+			s.CentralDirectoryOffset, byteCountIEC(s.CentralDirectorySize))
+	} else {
+		_, _ = fmt.Fprintf(buf, "central directory: offset %d, size %d (%s)\n",
+			s.CentralDirectoryOffset, s.CentralDirectorySize, byteCountIEC(s.CentralDirectorySize))
+	}
+	_, _ = fmt.Fprintf(buf, "estimated range requests to extract all files: %d\n", s.EstimatedRangeRequests)
+	if s.Comment != "" {
+		_, _ = fmt.Fprintf(buf, "comment: %s\n", s.Comment)
+	}
+
+	_, _ = fmt.Fprintf(buf, "\ncompression methods:\n")
+	for _, method := range sortedKeys(s.CompressionMethods) {
+		b := s.CompressionMethods[method]
+		_, _ = fmt.Fprintf(buf, "  %-12s %8d files\t%12s -> %-12s\t(ratio %.2f)\n",
+			method, b.Files, formatSize(b.UncompressedBytes, human), formatSize(b.CompressedBytes, human), b.CompressionRatio)
+	}
+	_, _ = fmt.Fprintf(buf, "\nextensions:\n")
+	for _, ext := range sortedKeys(s.Extensions) {
+		b := s.Extensions[ext]
+		_, _ = fmt.Fprintf(buf, "  %-12s %8d files\t%12s -> %-12s\t(ratio %.2f)\n",
+			ext, b.Files, formatSize(b.UncompressedBytes, human), formatSize(b.CompressedBytes, human), b.CompressionRatio)
+	}
+	_, _ = fmt.Fprintf(buf, "\ncreators:\n")
+	for _, creator := range sortedKeys(s.Creators) {
+		_, _ = fmt.Fprintf(buf, "  %-12s %8d entries\n", creator, s.Creators[creator])
+	}
+	if len(s.Largest) > 0 {
+		_, _ = fmt.Fprintf(buf, "\nlargest files:\n")
+		for _, f := range s.Largest {
+			_, _ = fmt.Fprintf(buf, "  %12s\t%s\n", formatSize(f.UncompressedBytes, human), f.Name)
+		}
+	}
+	if len(s.DuplicateNames) > 0 {
+		_, _ = fmt.Fprintf(buf, "\nduplicate names:\n")
+		for _, name := range s.DuplicateNames {
+			_, _ = fmt.Fprintf(buf, "  %s\n", name)
+		}
+	}
+	return buf.Flush()
+}
+
+// flatten turns nested json values into dotted key/value pairs
+func flatten(prefix string, value any, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, child := range v {
+			flatten(strings.TrimPrefix(prefix+"."+k, "."), child, out)
+		}
+	case []any:
+		for i, child := range v {
+			flatten(fmt.Sprintf("%s.%d", prefix, i), child, out)
+		}
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+func writeInfoCSV(w io.Writer, info *infoOutput) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	flat := make(map[string]string)
+	flatten("", decoded, flat)
+	c := csv.NewWriter(w)
+	_ = c.Write([]string{"key", "value"})
+	for _, k := range sortedKeys(flat) {
+		_ = c.Write([]string{k, flat[k]})
+	}
+	c.Flush()
+	return c.Error()
+}
+
 var infoCmd = &cobra.Command{
 	Use:     "info",
 	Short:   "Display aggregate information about the remote archive (number of files, total size, etc)",
@@ -13,24 +133,60 @@ var infoCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		remoteFile := args[0]
-		var totalCompressed, totalUncompressed, totalFiles uint64
-		for _, f := range getCdr(remoteFile) {
-			if f.Mode.IsDir() {
-				continue
+		format := getOutputFormat(cmd)
+		filter := getFilter(cmd)
+		human, err := cmd.Flags().GetBool("human")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		topN, err := cmd.Flags().GetInt("top")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		uri, err := expandStdin(remoteFile)
+		if err != nil {
+			die("could not read stdin: %v\n", err)
+		}
+		it, err := newClient().Iterator(cmd.Context(), uri)
+		if err != nil {
+			die("could not open remote zip file: %v\n", err)
+		}
+		collector := cloudzip.NewStatsCollector(topN)
+		for {
+			f, err := it.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				die("could not read zip file contents: %v\n", err)
 			}
-			totalCompressed += f.CompressedSizeBytes
-			totalUncompressed += f.UncompressedSizeBytes
-			totalFiles += 1
-		}
-		fmt.Printf("zip file: %s\n", remoteFile)
-		fmt.Printf("files: %d\n", totalFiles)
-		fmt.Printf("total bytes (compressed): %d\n", totalCompressed)
-		fmt.Printf("total bytes (uncompressed): %d\n", totalUncompressed)
-		fmt.Printf("total bytes (compressed, human readable): %s\n", byteCountIEC(totalCompressed))
-		fmt.Printf("total bytes (uncompressed, human readable): %s\n", byteCountIEC(totalUncompressed))
+			if filter(f) {
+				collector.Add(f)
+			}
+		}
+		info := &infoOutput{ZipFile: remoteFile, Stats: collector.Stats(it.Location())}
+
+		switch format {
+		case outputJSON, outputJSONL:
+			enc := json.NewEncoder(os.Stdout)
+			if format == outputJSON {
+				enc.SetIndent("", "  ")
+			}
+			err = enc.Encode(info)
+		case outputCSV:
+			err = writeInfoCSV(os.Stdout, info)
+		default:
+			err = writeInfoTable(os.Stdout, info, human)
+		}
+		if err != nil {
+			die("could not write output: %v\n", err)
+		}
 	},
 }
 
 func init() {
+	addOutputFlags(infoCmd)
+	addHumanFlag(infoCmd)
+	addFilterFlags(infoCmd)
+	infoCmd.Flags().Int("top", cloudzip.DefaultTopFiles, "number of largest files to report")
 	rootCmd.AddCommand(infoCmd)
 }
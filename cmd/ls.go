@@ -1,12 +1,42 @@
 package cmd
 
 import (
-	"fmt"
-	"time"
+	"os"
+	"sort"
 
 	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
 )
 
+// recordOrder returns the ordering --sort selects, so an unsupported one is rejected before
+// anything is fetched
+func recordOrder(by string) func(a, b *zipfile.CDR) bool {
+	var less func(a, b *zipfile.CDR) bool
+	switch by {
+	case "name":
+		less = func(a, b *zipfile.CDR) bool { return a.FileName < b.FileName }
+	case "size":
+		// largest first, like `ls -S`
+		less = func(a, b *zipfile.CDR) bool { return a.UncompressedSizeBytes > b.UncompressedSizeBytes }
+	case "mtime":
+		// newest first, like `ls -t`
+		less = func(a, b *zipfile.CDR) bool { return a.Modified.After(b.Modified) }
+	default:
+		die("unsupported sort order: '%s', select 'name', 'size' or 'mtime'", by)
+	}
+	return less
+}
+
+func sortRecords(records []*zipfile.CDR, less func(a, b *zipfile.CDR) bool, reverse bool) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if reverse {
+			return less(records[j], records[i])
+		}
+		return less(records[i], records[j])
+	})
+}
+
 var lsCmd = &cobra.Command{
 	Use:     "ls",
 	Short:   "List the files that exist in the remote zip archive",
@@ -14,13 +44,62 @@ var lsCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		remoteFile := args[0]
-		for _, f := range getCdr(remoteFile) {
-			fmt.Printf("%s\t%-12d\t%-12d\t%s\t%s\n",
-				f.Mode, f.CompressedSizeBytes, f.UncompressedSizeBytes, f.Modified.Format(time.RFC822Z), f.FileName)
+		format := getOutputFormat(cmd)
+		filter := getFilter(cmd)
+		long, err := cmd.Flags().GetBool("long")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		human, err := cmd.Flags().GetBool("human")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		sortBy, err := cmd.Flags().GetString("sort")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		reverse, err := cmd.Flags().GetBool("reverse")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		var less func(a, b *zipfile.CDR) bool
+		if sortBy != "" {
+			less = recordOrder(sortBy)
+		}
+
+		out := newRecordWriter(os.Stdout, format, long, human)
+		write := func(f *zipfile.CDR) {
+			checkOutput(out.Write(f))
+		}
+		if sortBy == "" {
+			// stream records as they are parsed
+			iterCdr(cmd.Context(), remoteFile, func(f *zipfile.CDR) {
+				if filter(f) {
+					write(f)
+				}
+			})
+		} else {
+			records := make([]*zipfile.CDR, 0)
+			iterCdr(cmd.Context(), remoteFile, func(f *zipfile.CDR) {
+				if filter(f) {
+					records = append(records, f)
+				}
+			})
+			sortRecords(records, less, reverse)
+			for _, f := range records {
+				write(f)
+			}
 		}
+		checkOutput(out.Close())
 	},
 }
 
 func init() {
+	addOutputFlags(lsCmd)
+	addHumanFlag(lsCmd)
+	addFilterFlags(lsCmd)
+	lsCmd.Flags().BoolP("long", "l", false, "include compression method, CRC32 and offset columns (table output)")
+	lsCmd.Flags().String("sort", "", "sort by (name | size | mtime), rather than archive order")
+	lsCmd.Flags().BoolP("reverse", "r", false, "reverse the sort order")
 	rootCmd.AddCommand(lsCmd)
 }
@@ -69,7 +69,7 @@ var mountCmd = &cobra.Command{
 		uri, err := expandStdin(remoteFile)
 		if err != nil {
 			_, _ = os.Stderr.WriteString(fmt.Sprintf("could not read stdin: %v\n", err))
-			os.Exit(1)
+			exit(1)
 		}
 		cacheDir, err := cmd.Flags().GetString("cache-dir")
 		if err != nil {
@@ -91,6 +91,10 @@ var mountCmd = &cobra.Command{
 		if err != nil {
 			die("could not parse command flags: %v\n", err)
 		}
+		adminAddr, err := cmd.Flags().GetString("admin-listen")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
 
 		serverCmd := []string{"mount-server", uri}
 		if cacheDir != "" {
@@ -99,6 +103,9 @@ var mountCmd = &cobra.Command{
 		if listenAddr != "" {
 			serverCmd = append(serverCmd, "--listen", listenAddr)
 		}
+		if adminAddr != "" {
+			serverCmd = append(serverCmd, "--admin-listen", adminAddr)
+		}
 
 		var serverAddr string
 		if !noSpawn {
@@ -186,6 +193,7 @@ func init() {
 	mountCmd.Flags().String("log", "", "log file for the server to write to")
 	mountCmd.Flags().Bool("no-spawn", false, "will not spawn a new server, assume one is already running")
 	mountCmd.Flags().String("protocol", defaultProtocol, "protocol to use (nfs | webdav)")
+	addAdminFlags(mountCmd)
 	_ = mountCmd.Flags().MarkHidden("no-spawn")
 	rootCmd.AddCommand(mountCmd)
 }
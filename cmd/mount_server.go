@@ -66,9 +66,10 @@ func serverLogging(logFile string) (*slog.Logger, error) {
 }
 
 var mountServerCmd = &cobra.Command{
-	Use:    "mount-server",
-	Hidden: true,
-	Args:   cobra.ExactArgs(1),
+	Use:         "mount-server",
+	Hidden:      true,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{serverAnnotation: ""},
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := cmd.Context()
 		remoteFile := args[0]
@@ -136,6 +137,8 @@ var mountServerCmd = &cobra.Command{
 			}
 		}
 
+		ready := serveAdmin(cmd, logger)
+
 		// bind to listen address
 		listener, err := net.Listen("tcp4", listenAddr)
 		if err != nil {
@@ -192,6 +195,7 @@ var mountServerCmd = &cobra.Command{
 			}
 		}
 
+		ready.SetReady(true)
 		logger.InfoContext(cmd.Context(),
 			"mount server started successfully",
 			"bound_addr", boundAddr.String(), "protocol", protocol)
@@ -205,5 +209,6 @@ func init() {
 	mountServerCmd.Flags().String("protocol", "nfs", "protocol to use (nfs | webdav)")
 	mountServerCmd.Flags().String("log", "", "optional log file to write to")
 	mountServerCmd.Flags().String("callback-addr", "", "callback address to report back to")
+	addAdminFlags(mountServerCmd)
 	rootCmd.AddCommand(mountServerCmd)
 }
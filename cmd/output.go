@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+	outputJSONL outputFormat = "jsonl"
+	outputCSV   outputFormat = "csv"
+)
+
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", string(outputTable), "output format (table | json | jsonl | csv)")
+}
+
+// checkOutput handles an error writing to stdout: once stdout is closed (e.g. piped into head) the command
+// exits quietly, while other errors (e.g. a full disk) fail it rather than leave its output truncated
+func checkOutput(err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, syscall.EPIPE) {
+		exit(0)
+	}
+	die("could not write output: %v\n", err)
+}
+
+func addHumanFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("human", false, "print sizes in human readable form (e.g. 1.5 MiB)")
+}
+
+func getOutputFormat(cmd *cobra.Command) outputFormat {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	switch f := outputFormat(strings.ToLower(output)); f {
+	case outputTable, outputJSON, outputJSONL, outputCSV:
+		return f
+	}
+	die("unsupported output format: '%s', select 'table', 'json', 'jsonl' or 'csv'", output)
+	return ""
+}
+
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().String("prefix", "", "only include files whose path starts with the given prefix")
+	cmd.Flags().String("glob", "", "only include files matching the given glob pattern (or residing under a matching directory)")
+}
+
+// getFilter returns a matcher for the --prefix and --glob flags
+func getFilter(cmd *cobra.Command) cloudzip.Matcher {
+	prefix, err := cmd.Flags().GetString("prefix")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	glob, err := cmd.Flags().GetString("glob")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	matchers := make([]cloudzip.Matcher, 0)
+	if prefix != "" {
+		matchers = append(matchers, cloudzip.PrefixMatcher(prefix))
+	}
+	if glob != "" {
+		m, err := cloudzip.GlobMatcher(glob)
+		if err != nil {
+			die("invalid glob pattern: %v\n", err)
+		}
+		matchers = append(matchers, m)
+	}
+	return func(record *zipfile.CDR) bool {
+		for _, m := range matchers {
+			if !m(record) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// recordOutput is the machine-readable representation of a central directory record
+type recordOutput struct {
+	Name                  string                 `json:"name"`
+	IsDir                 bool                   `json:"is_dir"`
+	Mode                  string                 `json:"mode"`
+	CompressedSize        uint64                 `json:"compressed_size"`
+	UncompressedSize      uint64                 `json:"uncompressed_size"`
+	CompressionMethod     uint16                 `json:"compression_method"`
+	CompressionMethodName string                 `json:"compression_method_name"`
+	CRC32                 string                 `json:"crc32"`
+	Modified              time.Time              `json:"modified"`
+	LocalHeaderOffset     uint64                 `json:"local_header_offset"`
+	CreatorOS             string                 `json:"creator_os"`
+	CreatorVersion        string                 `json:"creator_version"`
+	VersionNeeded         string                 `json:"version_needed"`
+	Flags                 uint16                 `json:"flags"`
+	Encrypted             bool                   `json:"encrypted"`
+	Zip64                 bool                   `json:"zip64"`
+	InternalAttributes    uint16                 `json:"internal_attributes"`
+	ExternalAttributes    uint32                 `json:"external_attributes"`
+	Comment               string                 `json:"comment,omitempty"`
+	Extra                 *zipfile.ExtraMetadata `json:"extra,omitempty"`
+}
+
+func newRecordOutput(f *zipfile.CDR) *recordOutput {
+	out := &recordOutput{
+		Name:                  f.FileName,
+		IsDir:                 f.Mode.IsDir(),
+		Mode:                  f.Mode.String(),
+		CompressedSize:        f.CompressedSizeBytes,
+		UncompressedSize:      f.UncompressedSizeBytes,
+		CompressionMethod:     f.CompressionMethod,
+		CompressionMethodName: zipfile.CompressionMethodName(f.CompressionMethod),
+		CRC32:                 fmt.Sprintf("%08x", f.CRC32Uncompressed),
+		Modified:              f.Modified,
+		LocalHeaderOffset:     f.LocalFileHeaderOffset,
+		CreatorOS:             zipfile.CreatorOSName(f.CreatorVersion),
+		CreatorVersion:        zipfile.CreatorSpecVersion(f.CreatorVersion),
+		VersionNeeded:         zipfile.CreatorSpecVersion(f.VersionNeededToExtract),
+		Flags:                 f.Flags,
+		Encrypted:             f.Encrypted(),
+		Zip64:                 f.Zip64,
+		InternalAttributes:    f.InternalAttributes,
+		ExternalAttributes:    f.ExternalAttributes,
+		Comment:               string(f.FileComment),
+	}
+	if len(f.ExtraFields) > 0 {
+		out.Extra = zipfile.ParseExtraMetadata(f.ExtraFields)
+	}
+	return out
+}
+
+var recordCSVHeader = []string{
+	"name", "is_dir", "mode", "compressed_size", "uncompressed_size",
+	"compression_method", "compression_method_name", "crc32", "modified", "local_header_offset",
+	"creator_os", "creator_version", "version_needed", "flags", "encrypted", "zip64",
+	"internal_attributes", "external_attributes", "comment",
+	"extra_modified", "extra_accessed", "extra_created", "extra_uid", "extra_gid", "extra_unicode_path",
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatOptionalUint(n *uint32) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*n), 10)
+}
+
+func (r *recordOutput) csvRow() []string {
+	extra := r.Extra
+	if extra == nil {
+		extra = &zipfile.ExtraMetadata{}
+	}
+	return []string{
+		r.Name, strconv.FormatBool(r.IsDir), r.Mode,
+		strconv.FormatUint(r.CompressedSize, 10), strconv.FormatUint(r.UncompressedSize, 10),
+		strconv.Itoa(int(r.CompressionMethod)), r.CompressionMethodName, r.CRC32,
+		r.Modified.Format(time.RFC3339), strconv.FormatUint(r.LocalHeaderOffset, 10),
+		r.CreatorOS, r.CreatorVersion, r.VersionNeeded, strconv.Itoa(int(r.Flags)),
+		strconv.FormatBool(r.Encrypted), strconv.FormatBool(r.Zip64),
+		strconv.Itoa(int(r.InternalAttributes)), strconv.FormatUint(uint64(r.ExternalAttributes), 10),
+		r.Comment,
+		formatOptionalTime(extra.Modified), formatOptionalTime(extra.Accessed), formatOptionalTime(extra.Created),
+		formatOptionalUint(extra.UID), formatOptionalUint(extra.GID), extra.UnicodePath,
+	}
+}
+
+// recordWriter writes records in a specific output format
+type recordWriter interface {
+	Write(f *zipfile.CDR) error
+	Close() error
+}
+
+func newRecordWriter(w io.Writer, format outputFormat, long, human bool) recordWriter {
+	buf := bufio.NewWriter(w)
+	switch format {
+	case outputJSON:
+		return &jsonRecordWriter{w: buf}
+	case outputJSONL:
+		return &jsonlRecordWriter{w: buf, enc: json.NewEncoder(buf)}
+	case outputCSV:
+		return &csvRecordWriter{w: buf, csv: csv.NewWriter(buf)}
+	}
+	return &tableRecordWriter{w: buf, long: long, human: human}
+}
+
+func formatSize(n uint64, human bool) string {
+	if human {
+		return byteCountIEC(n)
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+type tableRecordWriter struct {
+	w     *bufio.Writer
+	long  bool
+	human bool
+}
+
+func (t *tableRecordWriter) Write(f *zipfile.CDR) error {
+	var err error
+	if t.long {
+		_, err = fmt.Fprintf(t.w, "%s\t%-12s\t%-12s\t%-8s\t%08x\t%-12d\t%s\t%s\n",
+			f.Mode, formatSize(f.CompressedSizeBytes, t.human), formatSize(f.UncompressedSizeBytes, t.human),
+			zipfile.CompressionMethodName(f.CompressionMethod), f.CRC32Uncompressed, f.LocalFileHeaderOffset,
+			f.Modified.Format(time.RFC822Z), f.FileName)
+	} else {
+		_, err = fmt.Fprintf(t.w, "%s\t%-12s\t%-12s\t%s\t%s\n",
+			f.Mode, formatSize(f.CompressedSizeBytes, t.human), formatSize(f.UncompressedSizeBytes, t.human),
+			f.Modified.Format(time.RFC822Z), f.FileName)
+	}
+	return err
+}
+
+func (t *tableRecordWriter) Close() error {
+	return t.w.Flush()
+}
+
+type jsonRecordWriter struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func (j *jsonRecordWriter) Write(f *zipfile.CDR) error {
+	data, err := json.Marshal(newRecordOutput(f))
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if !j.started {
+		sep = "[\n  "
+		j.started = true
+	}
+	if _, err := j.w.WriteString(sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonRecordWriter) Close() error {
+	end := "\n]\n"
+	if !j.started {
+		end = "[]\n"
+	}
+	if _, err := j.w.WriteString(end); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+type jsonlRecordWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlRecordWriter) Write(f *zipfile.CDR) error {
+	return j.enc.Encode(newRecordOutput(f))
+}
+
+func (j *jsonlRecordWriter) Close() error {
+	return j.w.Flush()
+}
+
+type csvRecordWriter struct {
+	w             *bufio.Writer
+	csv           *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvRecordWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	return c.csv.Write(recordCSVHeader)
+}
+
+func (c *csvRecordWriter) Write(f *zipfile.CDR) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.csv.Write(newRecordOutput(f).csvRow())
+}
+
+func (c *csvRecordWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var repackCmd = &cobra.Command{
+	Use:   "repack <src> <dst>",
+	Short: "Rewrite a zip archive into a layout optimized for remote access",
+	Long: `Rewrite a zip archive into a layout optimized for remote access.
+Members are streamed from the source archive with range requests and written directly to the destination,
+ordered by directory (so listing or extracting a directory reads a contiguous range), with data descriptors
+and redundant extra fields removed. Names, modes, timestamps and comments are preserved.
+Members keep their compression unless --method (or --method-for) selects a different one.
+Stored members can be aligned (e.g. --align 4096) so they can be memory mapped or read directly,
+and --index writes a compact index of member data offsets next to the archive (<dst>.idx).
+The source and destination may be the same: the destination is only replaced once the copy is complete.`,
+	Example: `cz repack s3://example-bucket/path/to/archive.zip s3://example-bucket/path/to/repacked.zip --align 4096 --index
+cz repack s3://example-bucket/path/to/archive.zip s3://example-bucket/path/to/archive.zip --method zstd --method-for .jpg=store`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := expandStdin(args[0])
+		if err != nil {
+			die("could not read stdin: %v\n", err)
+		}
+		dest := destinationURI(args[1])
+		parallelism, err := cmd.Flags().GetInt("parallelism")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		order, err := cmd.Flags().GetString("order")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		align, err := cmd.Flags().GetInt64("align")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		index, err := cmd.Flags().GetBool("index")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSizeStr, err := cmd.Flags().GetString("part-size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		partSize, err := parseByteSize(partSizeStr)
+		if err != nil {
+			die("%v\n", err)
+		}
+		if align < 0 || align > cloudzip.MaxAlign {
+			die("invalid --align value: %d, expected 0 (no alignment) to %d\n", align, cloudzip.MaxAlign)
+		}
+		selectMethod := methodSelectorFromFlags(cmd, true)
+
+		summary, err := newClient().Repack(cmd.Context(), src, dest, &cloudzip.RepackOptions{
+			Method:      selectMethod,
+			Order:       order,
+			Align:       align,
+			Index:       index,
+			Parallelism: parallelism,
+			OnProgress: func(entry *cloudzip.CreateEntry, method uint16, compressedSize int64) {
+				if verbose {
+					_, _ = fmt.Fprintf(os.Stderr, "repacked: %s (%s, %d -> %d bytes)\n",
+						entry.Name, zipfile.CompressionMethodName(method), entry.Size, compressedSize)
+				}
+			},
+		}, remote.WithPartSize(int64(partSize)))
+		if err != nil {
+			die("could not repack zip file: %v\n", err)
+		}
+		_, _ = fmt.Fprintln(os.Stderr, summary)
+	},
+}
+
+func init() {
+	repackCmd.Flags().StringP("method", "m", "keep", "compression method for members (keep | store | deflate | zstd)")
+	repackCmd.Flags().StringSlice("method-for", nil, "compression method for an extension, e.g. '.csv=zstd' (may be repeated)")
+	repackCmd.Flags().String("store-smaller-than", "", "store (don't compress) members smaller than this size, e.g. 4KiB")
+	repackCmd.Flags().Bool("compress-all", false, "with --method, also compress members whose extension denotes an already compressed format")
+	repackCmd.Flags().String("order", "dir", "order of members in the new archive (dir | name | size | mtime | ext | none)")
+	repackCmd.Flags().Int64("align", 0, "align the data of stored members to a multiple of this many bytes, e.g. 4096")
+	repackCmd.Flags().Bool("index", false, "also write an index of member data offsets to <dst>.idx")
+	repackCmd.Flags().IntP("parallelism", "p", cloudzip.DefaultCreateParallelism, "number of members to read ahead")
+	repackCmd.Flags().String("part-size", "64MiB", "size of multipart upload parts")
+	repackCmd.Flags().BoolP("verbose", "v", false, "print every repacked member")
+	rootCmd.AddCommand(repackCmd)
+}
@@ -18,11 +18,14 @@ var rootCmd = &cobra.Command{
 	CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		setupLogging()
+		setupTracing(cmd)
 	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	flushTracing()
+	if err != nil {
 		_, err = fmt.Fprintln(os.Stderr, err)
 		if err != nil {
 			return
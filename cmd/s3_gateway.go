@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+)
+
+const (
+	s3AccessKeyIDEnvVar     = "CLOUDZIP_S3_ACCESS_KEY_ID"
+	s3SecretAccessKeyEnvVar = "CLOUDZIP_S3_SECRET_ACCESS_KEY"
+)
+
+// bucketNameRegex matches valid S3 bucket names, as clients may refuse to address others
+var bucketNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// parseBuckets parses "bucket=prefix" arguments
+func parseBuckets(args []string) map[string]string {
+	buckets := make(map[string]string, len(args))
+	for _, arg := range args {
+		name, prefix, found := strings.Cut(arg, "=")
+		if !found || prefix == "" {
+			die("invalid bucket '%s', expected 'bucket=remote-prefix'\n", arg)
+		}
+		if !bucketNameRegex.MatchString(name) {
+			die("invalid bucket name '%s': use 3-63 lower case letters, digits, dots and hyphens\n", name)
+		}
+		if _, exists := buckets[name]; exists {
+			die("bucket '%s' is defined more than once\n", name)
+		}
+		buckets[name] = prefix
+	}
+	return buckets
+}
+
+// s3CredentialsOptions returns handler options for the credentials in --credentials-file and the environment
+func s3CredentialsOptions(cmd *cobra.Command) []proxy.Option {
+	credentialsFile, err := cmd.Flags().GetString("credentials-file")
+	if err != nil {
+		die("could not parse command flags: %v\n", err)
+	}
+	var credentials []string
+	if credentialsFile != "" {
+		credentials, err = readCredentialsFile(credentialsFile)
+		if err != nil {
+			die("could not read credentials file: %v\n", err)
+		}
+	}
+	if accessKeyID := os.Getenv(s3AccessKeyIDEnvVar); accessKeyID != "" {
+		credentials = append(credentials, accessKeyID+":"+os.Getenv(s3SecretAccessKeyEnvVar))
+	}
+	var opts []proxy.Option
+	for _, pair := range credentials {
+		accessKeyID, secretAccessKey, found := strings.Cut(pair, ":")
+		if !found || accessKeyID == "" || secretAccessKey == "" {
+			die("invalid credentials for access key '%s', expected 'access-key-id:secret-access-key'\n", accessKeyID)
+		}
+		opts = append(opts, proxy.WithCredentials(accessKeyID, secretAccessKey))
+	}
+	return opts
+}
+
+var s3GatewayCmd = &cobra.Command{
+	Use:         "s3-gateway <bucket>=<remote-prefix>...",
+	Short:       "Serve the members of remote zip archives through a read-only S3-compatible API",
+	Annotations: map[string]string{serverAnnotation: ""},
+	Long: `Serve the members of remote zip archives through a read-only S3-compatible API.
+Each bucket maps to a prefix: the member 'b.csv' of the archive at <remote-prefix>/a.zip is the object with key 'a.zip/b.csv'.
+Supported operations are ListBuckets, HeadBucket, GetBucketLocation, ListObjectsV2 (with a prefix starting with the path
+of an archive), HeadObject and GetObject (including ranges).
+Anyone who can reach the server may read the buckets, unless requests must be signed (using AWS Signature Version 4)
+with credentials listed in --credentials-file or set in $` + s3AccessKeyIDEnvVar + ` and $` + s3SecretAccessKeyEnvVar + `.`,
+	Example: `cz s3-gateway datasets=s3://example-bucket/datasets --listen 127.0.0.1:9000 --credentials-file keys.txt
+aws s3 --endpoint-url http://127.0.0.1:9000 cp s3://datasets/path/to/archive.zip/data.csv .`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		buckets := parseBuckets(args)
+		opts := append(cacheOptions(cmd), accessListOptions(cmd)...)
+		opts = append(opts, s3CredentialsOptions(cmd)...)
+		serveHTTP(cmd, "s3", "S3 gateway", proxy.NewS3Handler(serverClient(cmd), buckets, opts...))
+	},
+}
+
+func init() {
+	addServerFlags(s3GatewayCmd)
+	s3GatewayCmd.Flags().String("credentials-file", "", "require requests to be signed with credentials listed in this file, one 'access-key-id:secret-access-key' per line")
+	rootCmd.AddCommand(s3GatewayCmd)
+}
@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+// usage is the accumulated size of a directory (or a single file)
+type usage struct {
+	Files             uint64
+	CompressedBytes   uint64
+	UncompressedBytes uint64
+}
+
+func (u *usage) add(other *usage) {
+	u.Files += other.Files
+	u.CompressedBytes += other.CompressedBytes
+	u.UncompressedBytes += other.UncompressedBytes
+}
+
+// archiveTree is the directory hierarchy of an archive, built the same way the mount builds it:
+// implicit directories are synthesized by commonfs.InMemoryTreeBuilder.
+type archiveTree struct {
+	tree    commonfs.Tree
+	records map[string]*zipfile.CDR
+	usage   map[string]*usage
+}
+
+func treeKey(name string) string {
+	return strings.Trim(name, commonfs.Delimiter)
+}
+
+// loadTree reads the central directory of remoteFile and indexes it into an archiveTree
+func loadTree(ctx context.Context, remoteFile string) *archiveTree {
+	records := make(map[string]*zipfile.CDR)
+	infos := make(commonfs.FileInfoList, 0)
+	iterCdr(ctx, remoteFile, func(f *zipfile.CDR) {
+		key := treeKey(f.FileName)
+		if key == "" || !fs.ValidPath(key) {
+			return // can't be placed in the tree (absolute paths, "..", etc.)
+		}
+		if _, exists := records[key]; exists {
+			return // first occurrence wins, like zipfile.Archive
+		}
+		records[key] = f
+		infos = append(infos, commonfs.ImmutableInfo(
+			f.FileName, f.Modified, f.Mode, int64(f.UncompressedSizeBytes), nil))
+	})
+	sort.Sort(infos)
+	var zero time.Time
+	tree := commonfs.NewInMemoryTreeBuilder(func(entry string) *commonfs.FileInfo {
+		return commonfs.ImmutableDir(entry, zero)
+	})
+	if err := tree.Index(infos); err != nil {
+		die("could not index zip file contents: %v\n", err)
+	}
+	return &archiveTree{
+		tree:    tree,
+		records: records,
+		usage:   make(map[string]*usage),
+	}
+}
+
+// stat returns the entry at entryPath, exiting if it doesn't exist
+func (t *archiveTree) stat(entryPath string) *commonfs.FileInfo {
+	info, err := t.tree.Stat(treeKey(entryPath))
+	if err != nil {
+		die("no such file or directory in archive: '%s'\n", entryPath)
+	}
+	return info
+}
+
+// children returns the direct descendants of dirPath, sorted by name
+func (t *archiveTree) children(dirPath string) commonfs.FileInfoList {
+	entries, err := t.tree.Readdir(dirPath)
+	if err != nil {
+		return nil
+	}
+	sort.Sort(entries)
+	return entries
+}
+
+// du returns the total size of the files at or under entryPath
+func (t *archiveTree) du(entryPath string, isDir bool) *usage {
+	key := treeKey(entryPath)
+	if u, ok := t.usage[key]; ok {
+		return u
+	}
+	u := &usage{}
+	if !isDir {
+		if f, ok := t.records[key]; ok {
+			u.Files = 1
+			u.CompressedBytes = f.CompressedSizeBytes
+			u.UncompressedBytes = f.UncompressedSizeBytes
+		}
+	} else {
+		for _, child := range t.children(key) {
+			u.add(t.du(child.FullPath(), child.IsDir()))
+		}
+	}
+	t.usage[key] = u
+	return u
+}
+
+type treePrinter struct {
+	t         *archiveTree
+	w         *bufio.Writer
+	maxDepth  int
+	showSizes bool
+	human     bool
+	dirsOnly  bool
+	dirs      int
+	files     int
+}
+
+func (p *treePrinter) label(info *commonfs.FileInfo, name string) string {
+	if !p.showSizes {
+		return name
+	}
+	u := p.t.du(info.FullPath(), info.IsDir())
+	return fmt.Sprintf("[%s] %s", formatSize(u.UncompressedBytes, p.human), name)
+}
+
+func (p *treePrinter) print(dirPath string, prefix string, depth int) {
+	if p.maxDepth > 0 && depth > p.maxDepth {
+		return
+	}
+	entries := p.t.children(dirPath)
+	if p.dirsOnly {
+		dirs := make(commonfs.FileInfoList, 0, len(entries))
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, entry)
+			}
+		}
+		entries = dirs
+	}
+	for i, entry := range entries {
+		connector, childPrefix := "├── ", "│   "
+		if i == len(entries)-1 {
+			connector, childPrefix = "└── ", "    "
+		}
+		_, _ = fmt.Fprintf(p.w, "%s%s%s\n", prefix, connector, p.label(entry, entry.Name()))
+		if entry.IsDir() {
+			p.dirs++
+			p.print(entry.FullPath(), prefix+childPrefix, depth+1)
+		} else {
+			p.files++
+		}
+	}
+}
+
+var treeCmd = &cobra.Command{
+	Use:     "tree",
+	Short:   "Print the directory hierarchy of the remote archive",
+	Example: "cz tree s3://example-bucket/path/to/archive.zip images/ -L 2 --size",
+	Args:    cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		root := ""
+		if len(args) > 1 {
+			root = args[1]
+		}
+		maxDepth, err := cmd.Flags().GetInt("level")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		showSizes, err := cmd.Flags().GetBool("size")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		human, err := cmd.Flags().GetBool("human")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+		dirsOnly, err := cmd.Flags().GetBool("dirs-only")
+		if err != nil {
+			die("could not parse command flags: %v\n", err)
+		}
+
+		t := loadTree(cmd.Context(), args[0])
+		rootInfo := t.stat(root)
+		p := &treePrinter{
+			t:         t,
+			w:         bufio.NewWriter(os.Stdout),
+			maxDepth:  maxDepth,
+			showSizes: showSizes || human,
+			human:     human,
+			dirsOnly:  dirsOnly,
+		}
+		rootName := treeKey(root)
+		if rootName == "" {
+			rootName = "."
+		}
+		_, _ = fmt.Fprintln(p.w, p.label(rootInfo, rootName))
+		if rootInfo.IsDir() {
+			p.print(rootInfo.FullPath(), "", 1)
+		} else {
+			p.files++
+		}
+		if dirsOnly {
+			_, _ = fmt.Fprintf(p.w, "\n%d directories\n", p.dirs)
+		} else {
+			_, _ = fmt.Fprintf(p.w, "\n%d directories, %d files\n", p.dirs, p.files)
+		}
+		checkOutput(p.w.Flush())
+	},
+}
+
+func init() {
+	treeCmd.Flags().IntP("level", "L", 0, "descend at most this many directory levels (0 for unlimited)")
+	treeCmd.Flags().BoolP("size", "s", false, "print the uncompressed size of each file (and total size of each directory)")
+	treeCmd.Flags().Bool("human", false, "print sizes in human readable form (e.g. 1.5 MiB), implies --size")
+	treeCmd.Flags().BoolP("dirs-only", "d", false, "list directories only")
+	rootCmd.AddCommand(treeCmd)
+}
@@ -0,0 +1,165 @@
+package cloudzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var ErrExists = errors.New("already exists in archive")
+
+type AppendOptions struct {
+	CreateOptions
+	// SkipExisting skips files whose name already exists in the archive, instead of failing with ErrExists.
+	// Existing directories are always skipped.
+	SkipExisting bool
+}
+
+// Appender returns a remote.Writer replacing the object at uri with its first keep bytes,
+// followed by everything written to it
+func (c *Client) Appender(ctx context.Context, uri string, keep int64, opts ...remote.WriterOpt) (remote.Writer, error) {
+	if uploader, ok := c.s3Client.(manager.UploadAPIClient); ok {
+		opts = append([]remote.WriterOpt{remote.WithS3UploadClient(uploader)}, opts...)
+	}
+	return remote.NewAppender(ctx, uri, keep, opts...)
+}
+
+// Append adds entries to the existing archive at uri without rewriting it: the existing members are kept
+// in place (copied server-side on S3, never downloaded), followed by the new members, the existing
+// central directory records, the records of the new members and a new end of central directory.
+// The archive is replaced atomically, and left unchanged if appending fails, including with
+// remote.ErrConcurrentModification if it was modified since its central directory was read.
+func (c *Client) Append(ctx context.Context, uri string, entries []*CreateEntry, opts *AppendOptions, writerOpts ...remote.WriterOpt) (*CreateSummary, error) {
+	if opts == nil {
+		opts = &AppendOptions{}
+	}
+	start := time.Now()
+	summary := &CreateSummary{}
+	f, err := c.Fetcher(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	// the ETag is taken before the central directory is read: if the archive is modified after it, the
+	// kept bytes and the central directory written no longer match, and the (conditional) append fails
+	info, err := remote.Stat(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if info.ETag != "" {
+		writerOpts = append(writerOpts[:len(writerOpts):len(writerOpts)], remote.WithIfMatch(info.ETag))
+	}
+	it, err := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, f)).Iterator()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		existing[strings.TrimSuffix(record.FileName, "/")] = true
+	}
+	loc := it.Location()
+
+	added := make([]*CreateEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !existing[strings.TrimSuffix(entry.Name, "/")] {
+			added = append(added, entry)
+		} else if !entry.IsDir() && !opts.SkipExisting {
+			return nil, fmt.Errorf("%w: %s", ErrExists, entry.Name)
+		}
+	}
+	if len(added) == 0 {
+		summary.Took = time.Since(start)
+		return summary, nil
+	}
+
+	// the existing central directory is kept as is, so all fields (including unknown ones) are preserved
+	cdStart := int64(loc.Offset)
+	cdEnd := cdStart + int64(loc.SizeBytes) - 1
+	var existingDirectory []byte
+	if loc.SizeBytes > 0 {
+		r, err := f.Fetch(ctx, &cdStart, &cdEnd)
+		if err != nil {
+			return nil, err
+		}
+		existingDirectory, err = io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(existingDirectory)) != loc.SizeBytes {
+			return nil, zipfile.ErrInvalidZip
+		}
+	}
+
+	w, err := c.Appender(ctx, uri, cdStart, writerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	summary, err = appendEntries(ctx, w, loc, existingDirectory, added, &opts.CreateOptions)
+	summary.Took = time.Since(start)
+	if err != nil {
+		_ = w.Abort()
+		return summary, err
+	}
+	return summary, w.Close()
+}
+
+// appendEntries writes the new members, followed by the merged central directory, to w,
+// which already holds everything up to the existing central directory
+func appendEntries(ctx context.Context, w io.Writer, loc *zipfile.CDLocation, existingDirectory []byte, entries []*CreateEntry, opts *CreateOptions) (*CreateSummary, error) {
+	summary := &CreateSummary{}
+	counter := &countingWriter{w: w}
+	zw := zip.NewWriter(counter)
+	zw.SetOffset(int64(loc.Offset))
+	if err := writeEntries(ctx, zw, entries, opts, summary); err != nil {
+		return summary, err
+	}
+	// zip.Writer writes a central directory of the new members only; capture it to merge it with the existing one
+	if err := zw.Flush(); err != nil {
+		return summary, err
+	}
+	newDirectoryOffset := loc.Offset + uint64(counter.n)
+	captured := &bytes.Buffer{}
+	counter.w = captured
+	if err := zw.Close(); err != nil {
+		return summary, err
+	}
+	reader := bytes.NewReader(captured.Bytes())
+	var newDirectorySize int64
+	for range entries {
+		size, err := zipfile.CentralDirectoryRecordSize(reader)
+		if err != nil {
+			return summary, err
+		}
+		newDirectorySize += size
+	}
+
+	tail := &countingWriter{w: w}
+	for _, data := range [][]byte{existingDirectory, captured.Bytes()[:newDirectorySize]} {
+		if _, err := tail.Write(data); err != nil {
+			return summary, err
+		}
+	}
+	directorySize := uint64(len(existingDirectory)) + uint64(newDirectorySize)
+	records := loc.Records + uint64(len(entries))
+	if err := zipfile.WriteEOCD(tail, records, directorySize, newDirectoryOffset, loc.Comment); err != nil {
+		return summary, err
+	}
+	summary.ArchiveBytes = int64(newDirectoryOffset) + tail.n
+	return summary, nil
+}
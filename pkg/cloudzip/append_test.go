@@ -0,0 +1,177 @@
+package cloudzip_test
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClient_Append(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"logs/1.txt": strings.Repeat("first day\n", 100),
+		"logs/2.txt": strings.Repeat("second day\n", 100),
+	})
+	client := cloudzip.NewClient()
+	dest := filepath.Join(t.TempDir(), "out.zip")
+	uri := "file://" + dest
+	entries, err := cloudzip.CollectEntries(src, []string{"logs"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Create(context.Background(), uri, entries, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writeFiles(t, src, map[string]string{
+		"logs/3.txt": strings.Repeat("third day\n", 100),
+	})
+	entries, err = cloudzip.CollectEntries(src, []string{"logs/3.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	summary, err := client.Append(context.Background(), uri, entries, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Files != 1 {
+		t.Errorf("expected 1 appended file, got %s", summary)
+	}
+
+	// the result must be readable by the standard library as well
+	stdlib, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("archive/zip could not read file: %v", err)
+	}
+	defer func() { _ = stdlib.Close() }()
+	expected := map[string]string{
+		"logs/1.txt": "first day\n",
+		"logs/2.txt": "second day\n",
+		"logs/3.txt": "third day\n",
+	}
+	for _, f := range stdlib.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		line, ok := expected[f.Name]
+		if !ok {
+			t.Errorf("unexpected member %s", f.Name)
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", f.Name, err)
+		}
+		if string(data) != strings.Repeat(line, 100) {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+		delete(expected, f.Name)
+	}
+	if len(expected) != 0 {
+		t.Errorf("missing members: %v", expected)
+	}
+
+	// members that already exist are rejected, unless skipped
+	_, err = client.Append(context.Background(), uri, entries, nil)
+	if !errors.Is(err, cloudzip.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	summary, err = client.Append(context.Background(), uri, entries, &cloudzip.AppendOptions{SkipExisting: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Files != 0 {
+		t.Errorf("expected nothing to be appended, got %s", summary)
+	}
+	archive, err := client.Open(context.Background(), uri)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(archive.Records()) != 4 {
+		t.Errorf("expected 4 records, got %d", len(archive.Records()))
+	}
+}
+
+// touchingFetcher modifies the file it fetches from (by changing its modification time) on the first Fetch
+type touchingFetcher struct {
+	next    remote.Fetcher
+	path    string
+	touched bool
+}
+
+func (f *touchingFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	if !f.touched {
+		f.touched = true
+		modified := time.Now().Add(time.Hour)
+		if err := os.Chtimes(f.path, modified, modified); err != nil {
+			return nil, err
+		}
+	}
+	return f.next.Fetch(ctx, startOffset, endOffset)
+}
+
+func (f *touchingFetcher) Stat(ctx context.Context) (*remote.ObjectInfo, error) {
+	return remote.Stat(ctx, f.next)
+}
+
+func TestClient_Append_ConcurrentModification(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"logs/1.txt": "first day\n"})
+	dest := filepath.Join(t.TempDir(), "out.zip")
+	uri := "file://" + dest
+	entries, err := cloudzip.CollectEntries(src, []string{"logs"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cloudzip.NewClient().Create(context.Background(), uri, entries, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive is modified once its central directory starts being read
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &touchingFetcher{next: next, path: dest}
+	}))
+	writeFiles(t, src, map[string]string{"logs/2.txt": "second day\n"})
+	entries, err = cloudzip.CollectEntries(src, []string{"logs/2.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = client.Append(context.Background(), uri, entries, nil)
+	if !errors.Is(err, remote.ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+	if current, err := os.ReadFile(dest); err != nil || string(current) != string(original) {
+		t.Errorf("expected the archive to be left unchanged")
+	}
+}
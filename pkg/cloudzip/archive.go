@@ -0,0 +1,327 @@
+package cloudzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+	"github.com/ozkatz/cloudzip/pkg/zipfs"
+)
+
+// Archive is an opened remote zip file, with its central directory parsed and indexed.
+// An Archive is safe for concurrent use.
+type Archive struct {
+	uri     string
+	client  *Client
+	fetcher remote.Fetcher
+	archive *zipfile.Archive
+}
+
+// WithContext returns a copy of the archive whose members are read using ctx, sharing the parsed
+// central directory. This allows an archive opened once to be reused across requests.
+func (a *Archive) WithContext(ctx context.Context) *Archive {
+	return &Archive{
+		uri:     a.uri,
+		client:  a.client,
+		fetcher: a.fetcher,
+		archive: a.archive.WithFetcher(zipfile.NewStorageAdapter(ctx, a.fetcher)),
+	}
+}
+
+// URI returns the location of the archive
+func (a *Archive) URI() string {
+	return a.uri
+}
+
+// Records returns all records in the order they appear in the central directory
+func (a *Archive) Records() []*zipfile.CDR {
+	return a.archive.Records()
+}
+
+// Stat returns the record of the given member, or ErrNotFound
+func (a *Archive) Stat(name string) (*zipfile.CDR, error) {
+	return a.archive.Stat(name)
+}
+
+// Glob returns the records of all members matching pattern, using the syntax of path.Match
+func (a *Archive) Glob(pattern string) ([]*zipfile.CDR, error) {
+	return a.archive.Glob(pattern)
+}
+
+// Walk calls fn for every record, in central directory order
+func (a *Archive) Walk(fn zipfile.WalkFunc) error {
+	return a.archive.Walk(fn)
+}
+
+// Zip returns the underlying zipfile.Archive
+func (a *Archive) Zip() *zipfile.Archive {
+	return a.archive
+}
+
+// FS returns the archive as an io/fs.FS
+func (a *Archive) FS() (*zipfs.FS, error) {
+	return zipfs.New(a.archive)
+}
+
+func cacheKey(strs ...string) string {
+	h := sha1.New()
+	for _, str := range strs {
+		_, _ = h.Write([]byte(str))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Open returns a reader for the uncompressed content of the given member.
+// If the client has a cache directory, the content is served from (and stored in) it.
+func (a *Archive) Open(name string) (io.ReadCloser, error) {
+	record, err := a.archive.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.OpenRecord(record)
+}
+
+// OpenRecord returns a reader for the uncompressed content of the given record
+func (a *Archive) OpenRecord(record *zipfile.CDR) (io.ReadCloser, error) {
+	if record.Mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: record.FileName, Err: errors.New("is a directory")}
+	}
+	cache := a.client.cache
+	if cache == nil {
+		r, err := zipfile.ReaderForRecord(record, a.archive.Fetcher())
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: r, close: func() error { return closeReader(r) }}, nil
+	}
+	ctx := zipfile.FetcherContext(a.archive.Fetcher())
+	key := cacheKey(a.uri, path.Clean(record.FileName), strconv.Itoa(int(record.CRC32Uncompressed)))
+	f, err := cache.Get(ctx, key)
+	if errors.Is(err, os.ErrNotExist) {
+		r, err := zipfile.ReaderForRecord(record, a.archive.Fetcher())
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = closeReader(r) }()
+		return cache.Set(ctx, key, io.NopCloser(r), int64(record.UncompressedSizeBytes))
+	}
+	return f, err
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+func closeReader(r io.Reader) error {
+	if closer, ok := r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// OpenRange returns a reader for length bytes of the uncompressed content of record, starting at offset.
+// A negative length reads until the end of the member.
+// Stored members are read with a single range request covering only the requested bytes (following one
+// for their local header, unless offset is 0); compressed members are decompressed from their start,
+// discarding the bytes before offset.
+// The cache directory is not used.
+func (a *Archive) OpenRange(record *zipfile.CDR, offset, length int64) (io.ReadCloser, error) {
+	if record.Mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: record.FileName, Err: errors.New("is a directory")}
+	}
+	size := int64(record.UncompressedSizeBytes)
+	if offset < 0 {
+		return nil, &os.PathError{Op: "open", Path: record.FileName, Err: errors.New("negative offset")}
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	if length <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	fetcher := a.archive.Fetcher()
+	if record.CompressionMethod == zip.Store && offset == 0 {
+		// reading from the start, the local header and the first length bytes are fetched together
+		prefix := *record
+		prefix.CompressedSizeBytes = uint64(length)
+		r, err := zipfile.RawReaderForRecord(&prefix, fetcher)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: r, close: func() error { return closeReader(r) }}, nil
+	}
+	if record.CompressionMethod == zip.Store {
+		dataOffset, err := zipfile.DataOffset(record, fetcher)
+		if err != nil {
+			return nil, err
+		}
+		start := dataOffset + offset
+		end := start + length - 1
+		r, err := fetcher.Fetch(&start, &end)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: r, close: func() error { return closeReader(r) }}, nil
+	}
+	r, err := zipfile.ReaderForRecord(record, fetcher)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		_ = closeReader(r)
+		return nil, err
+	}
+	return &readCloser{
+		Reader: io.LimitReader(r, length),
+		close:  func() error { return closeReader(r) },
+	}, nil
+}
+
+// OpenRaw returns a reader for the compressed bytes of record, exactly as stored in the archive
+func (a *Archive) OpenRaw(record *zipfile.CDR) (io.ReadCloser, error) {
+	if record.CompressedSizeBytes == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	r, err := zipfile.RawReaderForRecord(record, a.archive.Fetcher())
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: r, close: func() error { return closeReader(r) }}, nil
+}
+
+// Range is a part of the content of a member: Length bytes, starting at Offset
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// OpenSeeker returns a reader for the uncompressed content of record that supports seeking, e.g. for serving
+// HTTP range requests. Nothing is read until the first call to Read, and every Read following a Seek
+// to a new offset opens a new range (see OpenRange), so seeking compressed members is costly.
+// Reading from the offset of one of ranges (e.g. those of an HTTP range request) only fetches that range,
+// other reads fetch until the end of the member.
+func (a *Archive) OpenSeeker(record *zipfile.CDR, ranges ...Range) io.ReadSeekCloser {
+	return &memberSeeker{archive: a, record: record, size: int64(record.UncompressedSizeBytes), ranges: ranges}
+}
+
+type memberSeeker struct {
+	archive *Archive
+	record  *zipfile.CDR
+	size    int64
+	ranges  []Range
+	offset  int64
+	r       io.ReadCloser
+	// end is the offset at which r ends
+	end int64
+}
+
+// window returns the length of the range to open at the current offset
+func (m *memberSeeker) window() int64 {
+	for _, r := range m.ranges {
+		if r.Offset == m.offset && r.Length > 0 {
+			return r.Length
+		}
+	}
+	return -1
+}
+
+func (m *memberSeeker) Read(p []byte) (int, error) {
+	if m.offset >= m.size {
+		return 0, io.EOF
+	}
+	if m.r == nil {
+		window := m.window()
+		r, err := m.archive.OpenRange(m.record, m.offset, window)
+		if err != nil {
+			return 0, err
+		}
+		m.r = r
+		m.end = m.size
+		if window > 0 {
+			m.end = min(m.offset+window, m.size)
+		}
+	}
+	n, err := m.r.Read(p)
+	m.offset += int64(n)
+	if errors.Is(err, io.EOF) && m.offset == m.end && m.offset < m.size {
+		// read past the end of a range: continue after it
+		_ = m.r.Close()
+		m.r = nil
+		err = nil
+	}
+	return n, err
+}
+
+func (m *memberSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += m.offset
+	case io.SeekEnd:
+		offset += m.size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: m.record.FileName, Err: errors.New("negative offset")}
+	}
+	if offset != m.offset && m.r != nil {
+		_ = m.r.Close()
+		m.r = nil
+	}
+	m.offset = offset
+	return offset, nil
+}
+
+func (m *memberSeeker) Close() error {
+	if m.r == nil {
+		return nil
+	}
+	return m.r.Close()
+}
+
+// Extract writes the uncompressed content of the given member to w
+func (a *Archive) Extract(name string, w io.Writer) (int64, error) {
+	r, err := a.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.Copy(w, r)
+}
+
+// ExtractFile writes the uncompressed content of the given member to a local file at destination,
+// creating parent directories as needed
+func (a *Archive) ExtractFile(name, destination string) error {
+	record, err := a.archive.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	if record.Mode.IsDir() {
+		return os.MkdirAll(destination, 0755)
+	}
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := a.Extract(name, out); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,244 @@
+// Package cloudzip is the Go client library behind the cz command line tool.
+// It reads remote zip archives - listing, inspecting and extracting members -
+// without downloading the entire archive, and reports failures as errors.
+package cloudzip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+
+	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+var (
+	ErrNotFound     = zipfile.ErrFileNotFound
+	ErrInvalidZip   = zipfile.ErrInvalidZip
+	ErrDoesNotExist = remote.ErrDoesNotExist
+	ErrInvalidURI   = remote.ErrInvalidURI
+)
+
+// FetcherMiddleware wraps a remote.Fetcher, e.g. to add instrumentation, retries or rate limiting
+type FetcherMiddleware func(next remote.Fetcher) remote.Fetcher
+
+// FetcherFactory returns a remote.Fetcher for the given URI
+type FetcherFactory func(ctx context.Context, uri string) (remote.Fetcher, error)
+
+type Option func(c *Client)
+
+// WithLogger sets the logger passed to fetchers
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithFetcherMiddleware adds middleware applied to every fetcher the client creates.
+// Middleware is applied in order, so the first one added is the outermost.
+func WithFetcherMiddleware(mw ...FetcherMiddleware) Option {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, mw...)
+	}
+}
+
+// WithCacheDir caches the uncompressed content of opened members in the given directory
+func WithCacheDir(dir string) Option {
+	return func(c *Client) {
+		c.cache = commonfs.NewFileCache(dir)
+	}
+}
+
+// WithS3Client uses the given client for s3:// URIs, instead of one built from the default AWS configuration
+func WithS3Client(client remote.S3Getter) Option {
+	return func(c *Client) {
+		c.s3Client = client
+	}
+}
+
+// WithFetcherFactory replaces the way URIs are resolved to fetchers altogether,
+// e.g. to supply credentials or support additional schemes
+func WithFetcherFactory(factory FetcherFactory) Option {
+	return func(c *Client) {
+		c.factory = factory
+	}
+}
+
+// Client opens remote zip archives. A Client is safe for concurrent use.
+type Client struct {
+	logger     *slog.Logger
+	middleware []FetcherMiddleware
+	cache      *commonfs.FileCache
+	s3Client   remote.S3Getter
+	factory    FetcherFactory
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		logger: remote.DummyLogger(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Fetcher returns the remote.Fetcher used to read the object at uri
+func (c *Client) Fetcher(ctx context.Context, uri string) (remote.Fetcher, error) {
+	var f remote.Fetcher
+	var err error
+	switch {
+	case c.factory != nil:
+		f, err = c.factory(ctx, uri)
+	case c.s3Client != nil && isS3(uri):
+		f, err = remote.NewS3ObjectFetcherWithClient(c.s3Client, uri)
+		if err == nil {
+			remote.WithLogger(c.logger)(f)
+		}
+	default:
+		f, err = remote.Object(uri, remote.WithLogger(c.logger))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// fetchers returned by remote.Object are already instrumented; this covers the other sources
+	f = remote.Instrument(f, remote.Backend(uri))
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		f = c.middleware[i](f)
+	}
+	return f, nil
+}
+
+func isS3(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "s3", "S3", "s3a":
+		return true
+	}
+	return false
+}
+
+// Open reads the central directory of the zip file at uri
+func (c *Client) Open(ctx context.Context, uri string, opts ...zipfile.ArchiveOpt) (*Archive, error) {
+	f, err := c.Fetcher(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zipfile.NewArchive(zipfile.NewStorageAdapter(ctx, f), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{
+		uri:     uri,
+		client:  c,
+		fetcher: f,
+		archive: archive,
+	}, nil
+}
+
+// Iterator returns an iterator over the central directory of the zip file at uri
+func (c *Client) Iterator(ctx context.Context, uri string) (*zipfile.CentralDirectoryIterator, error) {
+	f, err := c.Fetcher(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, f)).Iterator()
+}
+
+// List calls fn for every record in the central directory of the zip file at uri, in archive order.
+// Records are parsed as they are downloaded, so listing starts immediately and uses bounded memory
+// regardless of the size of the archive. Returning fs.SkipAll from fn stops the listing,
+// any other error stops it and is returned by List.
+func (c *Client) List(ctx context.Context, uri string, fn zipfile.WalkFunc) error {
+	it, err := c.Iterator(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = it.Close() }()
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = fn(record)
+		if errors.Is(err, fs.SkipAll) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Stat returns the record of a single member of the zip file at uri.
+// The central directory is scanned until the member is found; use Open when accessing more than one member.
+func (c *Client) Stat(ctx context.Context, uri, name string) (*zipfile.CDR, error) {
+	record, _, err := c.find(ctx, uri, name)
+	return record, err
+}
+
+// OpenMember returns a reader for the uncompressed content of a single member of the zip file at uri.
+// The central directory is scanned until the member is found; use Open when accessing more than one member.
+func (c *Client) OpenMember(ctx context.Context, uri, name string) (io.ReadCloser, error) {
+	archive, record, err := c.Member(ctx, uri, name)
+	if err != nil {
+		return nil, err
+	}
+	return archive.OpenRecord(record)
+}
+
+// Member returns an Archive holding only the record of the named member, along with that record.
+// The central directory is scanned until the member is found; use Open when accessing more than one member.
+func (c *Client) Member(ctx context.Context, uri, name string) (*Archive, *zipfile.CDR, error) {
+	record, f, err := c.find(ctx, uri, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Archive{
+		uri:     uri,
+		client:  c,
+		fetcher: f,
+		archive: zipfile.NewArchiveFromRecords(zipfile.NewStorageAdapter(ctx, f), []*zipfile.CDR{record}),
+	}, record, nil
+}
+
+// Extract writes the uncompressed content of a single member of the zip file at uri to w
+func (c *Client) Extract(ctx context.Context, uri, name string, w io.Writer) (int64, error) {
+	r, err := c.OpenMember(ctx, uri, name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.Copy(w, r)
+}
+
+func (c *Client) find(ctx context.Context, uri, name string) (*zipfile.CDR, remote.Fetcher, error) {
+	f, err := c.Fetcher(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	it, err := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, f)).Iterator()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = it.Close() }()
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, ErrNotFound
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if record.FileName == name {
+			return record, f, nil
+		}
+	}
+}
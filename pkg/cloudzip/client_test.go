@@ -0,0 +1,396 @@
+package cloudzip_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const regularZip = "file://../zipfile/testdata/regular.zip"
+
+type countingFetcher struct {
+	next  remote.Fetcher
+	calls *atomic.Int64
+}
+
+func (c *countingFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	c.calls.Add(1)
+	return c.next.Fetch(ctx, startOffset, endOffset)
+}
+
+// trackingFetcher counts the readers it returned that weren't closed yet
+type trackingFetcher struct {
+	next remote.Fetcher
+	open *atomic.Int64
+}
+
+func (f *trackingFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	r, err := f.next.Fetch(ctx, startOffset, endOffset)
+	if err != nil {
+		return nil, err
+	}
+	f.open.Add(1)
+	return &trackedReader{ReadCloser: r, open: f.open}, nil
+}
+
+type trackedReader struct {
+	io.ReadCloser
+	open   *atomic.Int64
+	closed bool
+}
+
+func (r *trackedReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.open.Add(-1)
+	}
+	return r.ReadCloser.Close()
+}
+
+// TestClient_ClosesReaders checks that every body fetched from remote storage is closed once done with
+func TestClient_ClosesReaders(t *testing.T) {
+	ctx := context.Background()
+	open := &atomic.Int64{}
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &trackingFetcher{next: next, open: open}
+	}))
+	expectClosed := func(what string) {
+		t.Helper()
+		if n := open.Load(); n != 0 {
+			t.Errorf("%s: %d readers left open", what, n)
+			open.Store(0)
+		}
+	}
+
+	it, err := client.Iterator(ctx, regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for {
+		if _, err := it.Next(); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expectClosed("iterating to the end")
+
+	it, err = client.Iterator(ctx, regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = it.Close()
+	expectClosed("closing an iterator early")
+
+	f, err := client.Fetcher(ctx, regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, f)).Read("a/b/c/d.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// only the member's reader remains
+	if n := open.Load(); n != 1 {
+		t.Errorf("reading a member by name: expected only its reader to be open, got %d", n)
+	}
+	open.Store(0)
+
+	archive, err := client.Open(ctx, regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectClosed("opening an archive")
+	record, err := archive.Stat("a/b/c/d.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := archive.OpenRange(record, 5, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = r.Close()
+	expectClosed("reading a range of a stored member")
+
+	deflated := filepath.Join(t.TempDir(), "deflated.zip")
+	writeDeflatedZip(t, deflated, "deflated.txt", strings.Repeat("compress me ", 100))
+	archive, err = client.Open(ctx, "file://"+deflated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record, err = archive.Stat("deflated.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for what, open := range map[string]func() (io.ReadCloser, error){
+		"reading a member's compressed bytes": func() (io.ReadCloser, error) { return archive.OpenRaw(record) },
+		"reading part of a deflated member":   func() (io.ReadCloser, error) { return archive.OpenRange(record, 0, 10) },
+	} {
+		r, err := open()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := io.ReadAll(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = r.Close()
+		expectClosed(what)
+	}
+
+	if err := client.List(ctx, regularZip, func(*zipfile.CDR) error { return fs.SkipAll }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectClosed("stopping a listing early")
+	if _, err := client.Stat(ctx, regularZip, "a/b/c/d.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectClosed("finding a member")
+	for _, c := range []*cloudzip.Client{client, cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &trackingFetcher{next: next, open: open}
+	}), cloudzip.WithCacheDir(t.TempDir()))} {
+		if _, err := c.Extract(ctx, regularZip, "baz.txt", io.Discard); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectClosed("extracting a member")
+		archive, err := c.Open(ctx, regularZip)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := archive.Extract("baz.txt", io.Discard); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectClosed("extracting a member of an opened archive")
+	}
+}
+
+// rangeFetcher records the number of bytes requested by each fetch, -1 for open ended ones
+type rangeFetcher struct {
+	next   remote.Fetcher
+	ranges *[]int64
+}
+
+func (f *rangeFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	n := int64(-1)
+	if startOffset != nil && endOffset != nil {
+		n = *endOffset - *startOffset + 1
+	}
+	*f.ranges = append(*f.ranges, n)
+	return f.next.Fetch(ctx, startOffset, endOffset)
+}
+
+func TestArchive_OpenRange_StoredIsBounded(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "stored.zip")
+	content := strings.Repeat("0123456789", 100*1024)
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "data.bin", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ranges := make([]int64, 0)
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &rangeFetcher{next: next, ranges: &ranges}
+	}))
+	archive, err := client.Open(context.Background(), "file://"+p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record, err := archive.Stat("data.bin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, offset := range []int64{0, 5000} {
+		ranges = ranges[:0]
+		r, err := archive.OpenRange(record, offset, 100)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != content[offset:offset+100] {
+			t.Errorf("offset %d: expected '%s', got '%s'", offset, content[offset:offset+100], data)
+		}
+		for _, n := range ranges {
+			if n < 0 || n > 100+2048 {
+				t.Errorf("offset %d: expected fetches bounded by the requested range, fetched %d bytes", offset, n)
+			}
+		}
+	}
+}
+
+func writeDeflatedZip(t *testing.T, p, name, content string) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Open(t *testing.T) {
+	calls := &atomic.Int64{}
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &countingFetcher{next: next, calls: calls}
+	}), cloudzip.WithCacheDir(t.TempDir()))
+	archive, err := client.Open(context.Background(), regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(archive.Records()) != 7 {
+		t.Errorf("expected 7 records, got %d", len(archive.Records()))
+	}
+	buf := &bytes.Buffer{}
+	if _, err := archive.Extract("foo/bar.txt", buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "file in a directory!\n" {
+		t.Errorf("got wrong string: %s\n", buf.String())
+	}
+	// second read should be served from the cache
+	before := calls.Load()
+	buf.Reset()
+	if _, err := archive.Extract("foo/bar.txt", buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != before {
+		t.Errorf("expected cached read, got %d additional fetches", calls.Load()-before)
+	}
+	if before == 0 {
+		t.Errorf("expected middleware to be called")
+	}
+	if _, err := archive.Stat("does/not/exist"); !errors.Is(err, cloudzip.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	client := cloudzip.NewClient()
+	var names []string
+	err := client.List(context.Background(), regularZip, func(record *zipfile.CDR) error {
+		names = append(names, record.FileName)
+		if len(names) == 2 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("expected listing to stop after 2 records, got %d", len(names))
+	}
+	if err := client.List(context.Background(), "file://does-not-exist.zip", func(*zipfile.CDR) error {
+		return nil
+	}); !errors.Is(err, cloudzip.ErrDoesNotExist) {
+		t.Errorf("expected ErrDoesNotExist, got %v", err)
+	}
+}
+
+func TestClient_OpenMember(t *testing.T) {
+	client := cloudzip.NewClient()
+	buf := &bytes.Buffer{}
+	if _, err := client.Extract(context.Background(), regularZip, "baz.txt", buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() != 16 {
+		t.Errorf("expected 16 bytes, got %d", buf.Len())
+	}
+	if _, err := client.OpenMember(context.Background(), regularZip, "nope.txt"); !errors.Is(err, cloudzip.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestArchive_OpenRange(t *testing.T) {
+	for _, uri := range []string{regularZip, "file://../zipfile/testdata/zip64.zip"} {
+		archive, err := cloudzip.NewClient().Open(context.Background(), uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, record := range archive.Records() {
+			if record.Mode.IsDir() {
+				continue
+			}
+			full, err := archive.Open(record.FileName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			content, err := io.ReadAll(full)
+			_ = full.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			size := int64(len(content))
+			ranges := [][2]int64{{0, -1}, {3, 5}, {size - 4, -1}, {size - 2, 100}, {size, 10}}
+			for _, rng := range ranges {
+				r, err := archive.OpenRange(record, rng[0], rng[1])
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", record.FileName, err)
+				}
+				data, err := io.ReadAll(r)
+				_ = r.Close()
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", record.FileName, err)
+				}
+				end := size
+				if rng[1] >= 0 && rng[0]+rng[1] < size {
+					end = rng[0] + rng[1]
+				}
+				if !bytes.Equal(data, content[rng[0]:end]) {
+					t.Errorf("%s %v: expected '%s', got '%s'", record.FileName, rng, content[rng[0]:end], data)
+				}
+			}
+
+			raw, err := archive.OpenRaw(record)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data, err := io.ReadAll(raw)
+			_ = raw.Close()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if uint64(len(data)) != record.CompressedSizeBytes {
+				t.Errorf("%s: expected %d raw bytes, got %d", record.FileName, record.CompressedSizeBytes, len(data))
+			}
+		}
+	}
+}
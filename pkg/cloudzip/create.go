@@ -0,0 +1,603 @@
+package cloudzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	DefaultCreateParallelism = 4
+	// members up to this size are compressed in memory, larger ones into temporary files
+	inMemoryCompressLimit = 4 * 1024 * 1024
+)
+
+// DefaultStoredExtensions are extensions of formats that are already compressed,
+// so compressing them again wastes time without saving space
+var DefaultStoredExtensions = []string{
+	".7z", ".avif", ".br", ".bz2", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz4", ".mkv", ".mov",
+	".mp3", ".mp4", ".ogg", ".png", ".rar", ".tgz", ".webm", ".webp", ".whl", ".xz", ".zip", ".zst",
+}
+
+// ParseCompressionMethod returns the method ID for "store", "deflate" or "zstd"
+func ParseCompressionMethod(name string) (uint16, error) {
+	switch strings.ToLower(name) {
+	case "store":
+		return zip.Store, nil
+	case "deflate":
+		return zip.Deflate, nil
+	case "zstd":
+		return zipfile.Zstd, nil
+	}
+	return 0, fmt.Errorf("unsupported compression method: '%s', select 'store', 'deflate' or 'zstd'", name)
+}
+
+// MethodSelector chooses the compression method of a file, by its name and size
+type MethodSelector func(name string, size int64) uint16
+
+// NewMethodSelector returns a MethodSelector using the method set for the file's (case-insensitive) extension,
+// storing files smaller than storeBelow, and using defaultMethod for everything else
+func NewMethodSelector(defaultMethod uint16, byExtension map[string]uint16, storeBelow int64) MethodSelector {
+	return func(name string, size int64) uint16 {
+		if method, ok := byExtension[strings.ToLower(path.Ext(name))]; ok {
+			return method
+		}
+		if size < storeBelow {
+			return zip.Store
+		}
+		return defaultMethod
+	}
+}
+
+// DefaultMethodSelector deflates files, except for DefaultStoredExtensions which are stored
+func DefaultMethodSelector() MethodSelector {
+	byExtension := make(map[string]uint16, len(DefaultStoredExtensions))
+	for _, ext := range DefaultStoredExtensions {
+		byExtension[ext] = zip.Store
+	}
+	return NewMethodSelector(zip.Deflate, byExtension, 0)
+}
+
+// CreateEntry is a local file or directory to be added to an archive
+type CreateEntry struct {
+	// Name is the name in the archive; directory names end with "/"
+	Name string
+	// Path is the local path to read content from
+	Path     string
+	Size     int64
+	Mode     fs.FileMode
+	Modified time.Time
+}
+
+func (e *CreateEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// archiveName turns a local path, as given by the user, into a relative, slash separated member name
+func archiveName(p string) (string, error) {
+	name := path.Clean(filepath.ToSlash(p))
+	name = strings.TrimLeft(name, "/")
+	if vol := filepath.VolumeName(p); vol != "" {
+		name = strings.TrimLeft(strings.TrimPrefix(name, filepath.ToSlash(vol)), "/")
+	}
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%w: '%s'", ErrUnsafePath, p)
+	}
+	if name == "." {
+		name = ""
+	}
+	return name, nil
+}
+
+// CollectEntries walks the given paths (relative to baseDir, if set) and returns entries for all
+// directories and regular files found, in walk order. Member names are the paths as given,
+// so "data/x.csv" is stored as "data/x.csv" and "." stores the contents of baseDir.
+// Symbolic links are followed if they point to regular files, other special files are skipped.
+func CollectEntries(baseDir string, paths []string) ([]*CreateEntry, error) {
+	entries := make([]*CreateEntry, 0)
+	seen := make(map[string]bool)
+	add := func(entry *CreateEntry) {
+		if entry.Name == "" || entry.Name == "/" || seen[entry.Name] {
+			return
+		}
+		seen[entry.Name] = true
+		entries = append(entries, entry)
+	}
+	for _, p := range paths {
+		root, err := archiveName(p)
+		if err != nil {
+			return nil, err
+		}
+		local := p
+		if baseDir != "" && !filepath.IsAbs(p) {
+			local = filepath.Join(baseDir, p)
+		}
+		err = filepath.WalkDir(local, func(current string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(local, current)
+			if err != nil {
+				return err
+			}
+			name := path.Join(root, filepath.ToSlash(rel))
+			if name == "." {
+				name = ""
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				info, err = os.Stat(current)
+				if err != nil || !info.Mode().IsRegular() {
+					return nil // dangling links and links to directories are skipped
+				}
+			}
+			switch {
+			case info.IsDir():
+				if name != "" {
+					add(&CreateEntry{Name: name + "/", Path: current, Mode: info.Mode(), Modified: info.ModTime()})
+				}
+			case info.Mode().IsRegular():
+				add(&CreateEntry{Name: name, Path: current, Size: info.Size(), Mode: info.Mode(), Modified: info.ModTime()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// SortEntries orders entries by "name", "dir" (the members of every directory next to each other,
+// directories in name order), "size" (smallest first), "mtime" (oldest first), "ext" or "none"
+func SortEntries(entries []*CreateEntry, by string, reverse bool) error {
+	var less func(a, b *CreateEntry) bool
+	switch by {
+	case "", "none":
+		if reverse {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		return nil
+	case "name":
+		less = func(a, b *CreateEntry) bool { return a.Name < b.Name }
+	case "dir":
+		less = func(a, b *CreateEntry) bool {
+			dirA, dirB := parentDir(a.Name), parentDir(b.Name)
+			if dirA != dirB {
+				return comparePaths(dirA, dirB) < 0
+			}
+			return a.Name < b.Name
+		}
+	case "size":
+		less = func(a, b *CreateEntry) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b *CreateEntry) bool { return a.Modified.Before(b.Modified) }
+	case "ext":
+		less = func(a, b *CreateEntry) bool {
+			extA, extB := path.Ext(a.Name), path.Ext(b.Name)
+			if extA != extB {
+				return extA < extB
+			}
+			return a.Name < b.Name
+		}
+	default:
+		return fmt.Errorf("unsupported order: '%s', select 'name', 'dir', 'size', 'mtime', 'ext' or 'none'", by)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if reverse {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+	return nil
+}
+
+// parentDir returns the directory holding the member name ("" for the root)
+func parentDir(name string) string {
+	dir := path.Dir(strings.TrimSuffix(name, "/"))
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// comparePaths compares slash separated paths component by component,
+// so that "a/b" sorts before "a-b" and "a.txt" (unlike plain string comparison)
+func comparePaths(a, b string) int {
+	partsA, partsB := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if c := strings.Compare(partsA[i], partsB[i]); c != 0 {
+			return c
+		}
+	}
+	return len(partsA) - len(partsB)
+}
+
+type CreateOptions struct {
+	// Method chooses the compression method of each file. Defaults to DefaultMethodSelector.
+	Method MethodSelector
+	// Parallelism is the number of files compressed concurrently. Defaults to DefaultCreateParallelism.
+	Parallelism int
+	// TempDir holds compressed data of large files until it is written. Defaults to os.TempDir.
+	TempDir string
+	// OnProgress, if set, is called after each entry is written to the archive
+	OnProgress func(entry *CreateEntry, method uint16, compressedSize int64)
+}
+
+type CreateSummary struct {
+	Files       int64
+	Directories int64
+	// Bytes is the total uncompressed size of all files
+	Bytes int64
+	// ArchiveBytes is the size of the resulting archive
+	ArchiveBytes int64
+	Took         time.Duration
+}
+
+func (s *CreateSummary) String() string {
+	return fmt.Sprintf("added %d files (%d bytes) and %d directories, archive is %d bytes, in %s",
+		s.Files, s.Bytes, s.Directories, s.ArchiveBytes, s.Took.Round(time.Millisecond))
+}
+
+// compressedEntry is an entry that is ready to be written to the archive
+type compressedEntry struct {
+	entry  *CreateEntry
+	header *zip.FileHeader
+	// data holds the compressed bytes; nil for stored files, which are read from their local path
+	data    io.Reader
+	cleanup func()
+	err     error
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compressor(method uint16, w io.Writer) (io.WriteCloser, error) {
+	switch method {
+	case zip.Store:
+		return nopWriteCloser{w}, nil
+	case zip.Deflate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case zipfile.Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unsupported compression method: %d", method)
+}
+
+func newFileHeader(entry *CreateEntry, method uint16) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   method,
+		Modified: entry.Modified,
+	}
+	header.SetMode(entry.Mode)
+	if !entry.IsDir() {
+		header.ReaderVersion = readerVersion(method)
+		// zip.Writer.CreateRaw, unlike CreateHeader, writes the header as is
+		header.ModifiedDate, header.ModifiedTime = msDosTime(entry.Modified)
+		header.Extra = extendedTimestamp(entry.Modified)
+	}
+	return header
+}
+
+// readerVersion returns the zip specification version needed to extract members compressed with method
+func readerVersion(method uint16) uint16 {
+	if method == zipfile.Zstd {
+		return 63
+	}
+	return 20
+}
+
+// msDosTime converts t to MS-DOS date and time fields, in UTC (like archive/zip)
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// extendedTimestamp returns an extended timestamp extra field holding the modification time of a file
+func extendedTimestamp(t time.Time) []byte {
+	extra := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extra[0:2], zipfile.ExtendedTimestampExtraId)
+	binary.LittleEndian.PutUint16(extra[2:4], 5)
+	extra[4] = 1 // modification time only
+	binary.LittleEndian.PutUint32(extra[5:9], uint32(t.Unix()))
+	return extra
+}
+
+// spool holds data in memory, or in a temporary file if it may exceed inMemoryCompressLimit bytes
+type spool struct {
+	memory *bytes.Buffer
+	file   *os.File
+}
+
+func newSpool(size int64, tempDir string) (*spool, error) {
+	if size <= inMemoryCompressLimit {
+		return &spool{memory: &bytes.Buffer{}}, nil
+	}
+	f, err := os.CreateTemp(tempDir, ".cz-create-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{file: f}, nil
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.memory != nil {
+		return s.memory.Write(p)
+	}
+	return s.file.Write(p)
+}
+
+// Reader returns a reader for everything written to the spool
+func (s *spool) Reader() (io.Reader, error) {
+	if s.memory != nil {
+		return s.memory, nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.file, nil
+}
+
+func (s *spool) Cleanup() {
+	if s.file != nil {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+	}
+}
+
+// compressEntry reads a file, computing its CRC and (unless stored) compressing it into memory or a temporary file.
+// Files that don't get smaller when compressed are stored instead.
+func compressEntry(entry *CreateEntry, method uint16, tempDir string) *compressedEntry {
+	c := &compressedEntry{entry: entry, cleanup: func() {}}
+	if entry.IsDir() {
+		c.header = newFileHeader(entry, zip.Store)
+		return c
+	}
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer func() { _ = f.Close() }()
+
+	var sink io.Writer = io.Discard
+	var sp *spool
+	if method != zip.Store {
+		sp, err = newSpool(entry.Size, tempDir)
+		if err != nil {
+			c.err = err
+			return c
+		}
+		c.cleanup = sp.Cleanup
+		sink = sp
+	}
+	counter := &countingWriter{w: sink}
+	cw, err := compressor(method, counter)
+	if err != nil {
+		c.cleanup()
+		c.err = err
+		return c
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(cw, h), f)
+	if closeErr := cw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.cleanup()
+		c.err = fmt.Errorf("%s: %w", entry.Path, err)
+		return c
+	}
+	if method != zip.Store && counter.n >= n {
+		// incompressible, store instead
+		c.cleanup()
+		c.cleanup = func() {}
+		method = zip.Store
+	}
+	c.header = newFileHeader(entry, method)
+	c.header.CRC32 = h.Sum32()
+	c.header.UncompressedSize64 = uint64(n)
+	c.header.CompressedSize64 = uint64(n)
+	if method != zip.Store {
+		c.header.CompressedSize64 = uint64(counter.n)
+		c.data, err = sp.Reader()
+		if err != nil {
+			c.cleanup()
+			c.err = err
+			return c
+		}
+	}
+	return c
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeEntry writes a compressed entry to zw
+func writeEntry(zw *zip.Writer, c *compressedEntry) error {
+	if c.entry.IsDir() {
+		_, err := zw.CreateHeader(c.header)
+		return err
+	}
+	w, err := zw.CreateRaw(c.header)
+	if err != nil {
+		return err
+	}
+	if c.data != nil {
+		_, err = io.Copy(w, c.data)
+		return err
+	}
+	// stored: stream the file itself
+	f, err := os.Open(c.entry.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	n, err := io.CopyN(w, f, int64(c.header.UncompressedSize64))
+	if err != nil {
+		return fmt.Errorf("%s: file changed while creating archive (%d bytes read): %w", c.entry.Path, n, err)
+	}
+	return nil
+}
+
+// WriteZip writes a ZIP64 capable archive of entries, in order, to w.
+// Files are compressed concurrently, into memory or temporary files, and written to w sequentially
+// as soon as they are ready; the central directory is written last. The archive itself is never buffered,
+// so w can be a streaming upload.
+func WriteZip(ctx context.Context, w io.Writer, entries []*CreateEntry, opts *CreateOptions) (*CreateSummary, error) {
+	start := time.Now()
+	summary := &CreateSummary{}
+	counter := &countingWriter{w: w}
+	zw := zip.NewWriter(counter)
+	err := writeEntries(ctx, zw, entries, opts, summary)
+	if err == nil {
+		err = zw.Close()
+	}
+	summary.ArchiveBytes = counter.n
+	summary.Took = time.Since(start)
+	return summary, err
+}
+
+// writeEntries compresses entries concurrently and writes them to zw, in order
+func writeEntries(ctx context.Context, zw *zip.Writer, entries []*CreateEntry, opts *CreateOptions, summary *CreateSummary) error {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	selectMethod := opts.Method
+	if selectMethod == nil {
+		selectMethod = DefaultMethodSelector()
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultCreateParallelism
+	}
+	progress := opts.OnProgress
+	if progress == nil {
+		progress = func(*CreateEntry, uint16, int64) {}
+	}
+
+	return writeOrdered(ctx, entries, parallelism, func(entry *CreateEntry) *compressedEntry {
+		return compressEntry(entry, selectMethod(entry.Name, entry.Size), opts.TempDir)
+	}, func(c *compressedEntry) error {
+		if err := writeEntry(zw, c); err != nil {
+			return err
+		}
+		if c.entry.IsDir() {
+			summary.Directories++
+		} else {
+			summary.Files++
+			summary.Bytes += int64(c.header.UncompressedSize64)
+		}
+		progress(c.entry, c.header.Method, int64(c.header.CompressedSize64))
+		return nil
+	})
+}
+
+// writeOrdered prepares up to parallelism entries concurrently, ahead of the writer,
+// and calls write for each of them sequentially, in order
+func writeOrdered(ctx context.Context, entries []*CreateEntry, parallelism int, prepare func(entry *CreateEntry) *compressedEntry, write func(c *compressedEntry) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan chan *compressedEntry, parallelism)
+	go func() {
+		defer close(queue)
+		for _, entry := range entries {
+			result := make(chan *compressedEntry, 1)
+			select {
+			case queue <- result:
+			case <-ctx.Done():
+				return
+			}
+			go func(entry *CreateEntry) {
+				result <- prepare(entry)
+			}(entry)
+		}
+	}()
+	drain := func() {
+		cancel()
+		for result := range queue {
+			(<-result).cleanup()
+		}
+	}
+
+	for result := range queue {
+		c := <-result
+		err := c.err
+		if err == nil {
+			err = write(c)
+		}
+		c.cleanup()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			drain()
+			return err
+		}
+	}
+	return nil
+}
+
+// Writer returns a remote.Writer creating the object at uri
+func (c *Client) Writer(ctx context.Context, uri string, opts ...remote.WriterOpt) (remote.Writer, error) {
+	if uploader, ok := c.s3Client.(manager.UploadAPIClient); ok {
+		opts = append([]remote.WriterOpt{remote.WithS3UploadClient(uploader)}, opts...)
+	}
+	return remote.NewWriter(ctx, uri, opts...)
+}
+
+// Create writes an archive of entries directly to uri, e.g. as a streaming multipart upload to S3.
+// Nothing is created at uri if writing fails.
+func (c *Client) Create(ctx context.Context, uri string, entries []*CreateEntry, opts *CreateOptions, writerOpts ...remote.WriterOpt) (*CreateSummary, error) {
+	w, err := c.Writer(ctx, uri, writerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := WriteZip(ctx, w, entries, opts)
+	if err != nil {
+		_ = w.Abort()
+		return summary, err
+	}
+	return summary, w.Close()
+}
@@ -0,0 +1,110 @@
+package cloudzip_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+func TestClient_Create(t *testing.T) {
+	src := t.TempDir()
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"data/numbers.csv":  []byte(strings.Repeat("1,2,3,4,5\n", 10000)),
+		"data/random.bin":   random,
+		"data/photo.jpg":    []byte(strings.Repeat("not really a jpeg", 100)),
+		"data/nested/a.txt": []byte(strings.Repeat("hello\n", 100)),
+		"empty.txt":         {},
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := cloudzip.CollectEntries(src, []string{"data", "empty.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cloudzip.SortEntries(entries, "name", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "out.zip")
+	client := cloudzip.NewClient()
+	summary, err := client.Create(context.Background(), "file://"+dest, entries, &cloudzip.CreateOptions{
+		Method: cloudzip.NewMethodSelector(zip.Deflate, map[string]uint16{".csv": zipfile.Zstd, ".jpg": zip.Store}, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Files != 5 || summary.Directories != 2 {
+		t.Errorf("expected 5 files and 2 directories, got %s", summary)
+	}
+
+	archive, err := client.Open(context.Background(), "file://"+dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedMethods := map[string]uint16{
+		"data/numbers.csv":  zipfile.Zstd,
+		"data/random.bin":   zip.Store, // incompressible
+		"data/photo.jpg":    zip.Store,
+		"data/nested/a.txt": zip.Deflate,
+	}
+	for name, content := range files {
+		record, err := archive.Stat(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if method, ok := expectedMethods[name]; ok && record.CompressionMethod != method {
+			t.Errorf("%s: expected method %d, got %d", name, method, record.CompressionMethod)
+		}
+		r, err := archive.OpenRecord(record)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: content mismatch", name)
+		}
+	}
+	names := make([]string, 0)
+	for _, record := range archive.Records() {
+		names = append(names, record.FileName)
+	}
+	expectedOrder := "data,data/nested,data/nested/a.txt,data/numbers.csv,data/photo.jpg,data/random.bin,empty.txt"
+	if strings.Join(names, ",") != expectedOrder {
+		t.Errorf("unexpected order: %v", names)
+	}
+
+	// failures leave nothing behind
+	entries = append(entries, &cloudzip.CreateEntry{Name: "missing.txt", Path: filepath.Join(src, "missing.txt")})
+	failed := filepath.Join(filepath.Dir(dest), "failed.zip")
+	if _, err := client.Create(context.Background(), "file://"+failed, entries, nil); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	leftovers, _ := os.ReadDir(filepath.Dir(dest))
+	if len(leftovers) != 1 {
+		t.Errorf("expected only out.zip to remain, found %d files", len(leftovers))
+	}
+}
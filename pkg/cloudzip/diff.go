@@ -0,0 +1,237 @@
+package cloudzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const DefaultDiffContentSize = 1024 * 1024
+
+var (
+	ErrBinaryContent   = errors.New("binary content")
+	ErrContentTooLarge = errors.New("content too large")
+)
+
+type DiffKind string
+
+const (
+	DiffAdded    DiffKind = "added"
+	DiffRemoved  DiffKind = "removed"
+	DiffModified DiffKind = "modified"
+	DiffRenamed  DiffKind = "renamed"
+)
+
+// DiffEntry is a single difference between two sides.
+// Old is nil for added files, New is nil for removed files.
+type DiffEntry struct {
+	Kind DiffKind
+	Old  *zipfile.CDR
+	New  *zipfile.CDR
+}
+
+// Name returns the name of the entry on the new side, or the old side for removed files
+func (e *DiffEntry) Name() string {
+	if e.New != nil {
+		return e.New.FileName
+	}
+	return e.Old.FileName
+}
+
+// DiffSide is one side of a comparison: a set of records and a way to read their content
+type DiffSide struct {
+	Records []*zipfile.CDR
+	Open    func(record *zipfile.CDR) (io.ReadCloser, error)
+}
+
+// ArchiveDiffSide compares the members of an archive
+func ArchiveDiffSide(a *Archive) *DiffSide {
+	return &DiffSide{Records: a.Records(), Open: a.OpenRecord}
+}
+
+// LocalDiffSide compares the regular files under a local directory.
+// The CRC of every file is computed, so all files are read once.
+func LocalDiffSide(dir string) (*DiffSide, error) {
+	records := make([]*zipfile.CDR, 0)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		crc, err := localCRC32(p)
+		if err != nil {
+			return err
+		}
+		records = append(records, &zipfile.CDR{
+			FileName:              filepath.ToSlash(rel),
+			CompressedSizeBytes:   uint64(info.Size()),
+			UncompressedSizeBytes: uint64(info.Size()),
+			CRC32Uncompressed:     crc,
+			Modified:              info.ModTime(),
+			Mode:                  info.Mode(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DiffSide{
+		Records: records,
+		Open: func(record *zipfile.CDR) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(dir, filepath.FromSlash(record.FileName)))
+		},
+	}, nil
+}
+
+// filesByName indexes regular files by name; the first occurrence of a name wins
+func filesByName(records []*zipfile.CDR) map[string]*zipfile.CDR {
+	files := make(map[string]*zipfile.CDR, len(records))
+	for _, record := range records {
+		if record.Mode.IsDir() {
+			continue
+		}
+		if _, exists := files[record.FileName]; !exists {
+			files[record.FileName] = record
+		}
+	}
+	return files
+}
+
+type contentKey struct {
+	crc  uint32
+	size uint64
+}
+
+// Diff compares two sides by name, size and CRC only, without reading any content.
+// A removed and an added file with the same (non-empty) content are reported as a rename.
+// Entries are sorted by name.
+func Diff(oldSide, newSide *DiffSide) []*DiffEntry {
+	oldFiles := filesByName(oldSide.Records)
+	newFiles := filesByName(newSide.Records)
+	entries := make([]*DiffEntry, 0)
+	removed := make(map[contentKey][]*zipfile.CDR)
+	for name, old := range oldFiles {
+		current, exists := newFiles[name]
+		if !exists {
+			key := contentKey{old.CRC32Uncompressed, old.UncompressedSizeBytes}
+			removed[key] = append(removed[key], old)
+			continue
+		}
+		if current.UncompressedSizeBytes != old.UncompressedSizeBytes || current.CRC32Uncompressed != old.CRC32Uncompressed {
+			entries = append(entries, &DiffEntry{Kind: DiffModified, Old: old, New: current})
+		}
+	}
+	for _, candidates := range removed {
+		// pair renames deterministically
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].FileName < candidates[j].FileName })
+	}
+	added := make([]*zipfile.CDR, 0)
+	for name, current := range newFiles {
+		if _, exists := oldFiles[name]; !exists {
+			added = append(added, current)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].FileName < added[j].FileName })
+	for _, current := range added {
+		key := contentKey{current.CRC32Uncompressed, current.UncompressedSizeBytes}
+		if candidates := removed[key]; key.size > 0 && len(candidates) > 0 {
+			entries = append(entries, &DiffEntry{Kind: DiffRenamed, Old: candidates[0], New: current})
+			removed[key] = candidates[1:]
+			continue
+		}
+		entries = append(entries, &DiffEntry{Kind: DiffAdded, New: current})
+	}
+	for _, candidates := range removed {
+		for _, old := range candidates {
+			entries = append(entries, &DiffEntry{Kind: DiffRemoved, Old: old})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+func readTextContent(side *DiffSide, record *zipfile.CDR, maxSize uint64) (string, error) {
+	if record == nil {
+		return "", nil
+	}
+	if record.UncompressedSizeBytes > maxSize {
+		return "", fmt.Errorf("%w: %s is %d bytes", ErrContentTooLarge, record.FileName, record.UncompressedSizeBytes)
+	}
+	r, err := side.Open(record)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%w: %s", ErrBinaryContent, record.FileName)
+	}
+	return string(data), nil
+}
+
+// splitLines splits content into lines, keeping their line endings
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	// difflib expects every line to be terminated
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// UnifiedDiff fetches the content of both sides of entry and returns a unified diff.
+// Members larger than maxSize return ErrContentTooLarge, binary ones return ErrBinaryContent.
+func UnifiedDiff(oldSide, newSide *DiffSide, entry *DiffEntry, maxSize uint64) (string, error) {
+	if maxSize == 0 {
+		maxSize = DefaultDiffContentSize
+	}
+	oldContent, err := readTextContent(oldSide, entry.Old, maxSize)
+	if err != nil {
+		return "", err
+	}
+	newContent, err := readTextContent(newSide, entry.New, maxSize)
+	if err != nil {
+		return "", err
+	}
+	fromFile, toFile := "/dev/null", "/dev/null"
+	if entry.Old != nil {
+		fromFile = "a/" + entry.Old.FileName
+	}
+	if entry.New != nil {
+		toFile = "b/" + entry.New.FileName
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(oldContent),
+		B:        splitLines(newContent),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+}
@@ -0,0 +1,84 @@
+package cloudzip_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+)
+
+func TestDiff(t *testing.T) {
+	archive, err := cloudzip.NewClient().Open(context.Background(), regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	if _, err := archive.ExtractAll(context.Background(), dir, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	oldSide := cloudzip.ArchiveDiffSide(archive)
+
+	// identical
+	localSide, err := cloudzip.LocalDiffSide(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries := cloudzip.Diff(oldSide, localSide); len(entries) != 0 {
+		t.Fatalf("expected no differences, got %d", len(entries))
+	}
+
+	// modify, rename, add and remove files
+	if err := os.WriteFile(filepath.Join(dir, "baz.txt"), []byte("baz content\n456\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "foo", "bar.txt"), filepath.Join(dir, "moved.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a", "b", "c", "d.txt")); err != nil {
+		t.Fatal(err)
+	}
+	localSide, err = cloudzip.LocalDiffSide(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := cloudzip.Diff(oldSide, localSide)
+	expected := []struct {
+		Kind cloudzip.DiffKind
+		Name string
+	}{
+		{cloudzip.DiffRemoved, "a/b/c/d.txt"},
+		{cloudzip.DiffModified, "baz.txt"},
+		{cloudzip.DiffRenamed, "moved.txt"},
+		{cloudzip.DiffAdded, "new.txt"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d differences, got %d", len(expected), len(entries))
+	}
+	for i, e := range expected {
+		if entries[i].Kind != e.Kind || entries[i].Name() != e.Name {
+			t.Errorf("expected %s %s, got %s %s", e.Kind, e.Name, entries[i].Kind, entries[i].Name())
+		}
+	}
+	if entries[2].Old.FileName != "foo/bar.txt" {
+		t.Errorf("expected rename from foo/bar.txt, got %s", entries[2].Old.FileName)
+	}
+
+	diff, err := cloudzip.UnifiedDiff(oldSide, localSide, entries[1], 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(diff, "-123\n+456\n") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+	_, err = cloudzip.UnifiedDiff(oldSide, localSide, entries[1], 8)
+	if !errors.Is(err, cloudzip.ErrContentTooLarge) {
+		t.Errorf("expected ErrContentTooLarge, got %v", err)
+	}
+}
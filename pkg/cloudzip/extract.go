@@ -0,0 +1,320 @@
+package cloudzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	DefaultExtractParallelism = 8
+	defaultFileMode           = 0644
+	defaultDirMode            = 0755
+)
+
+var (
+	ErrUnsafePath       = errors.New("unsafe path")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Matcher selects archive members
+type Matcher func(record *zipfile.CDR) bool
+
+// MatchAll selects every member
+func MatchAll(*zipfile.CDR) bool {
+	return true
+}
+
+// GlobMatcher selects members matching any of the given patterns (using path.Match syntax),
+// or residing in a directory that matches one of them
+func GlobMatcher(patterns ...string) (Matcher, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: '%s'", err, pattern)
+		}
+	}
+	return func(record *zipfile.CDR) bool {
+		for _, pattern := range patterns {
+			pattern = strings.TrimSuffix(pattern, "/")
+			for name := record.FileName; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// RegexMatcher selects members whose name matches any of the given regular expressions
+func RegexMatcher(expressions ...string) (Matcher, error) {
+	compiled := make([]*regexp.Regexp, len(expressions))
+	for i, expr := range expressions {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = re
+	}
+	return func(record *zipfile.CDR) bool {
+		for _, re := range compiled {
+			if re.MatchString(record.FileName) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// PrefixMatcher selects members whose name starts with any of the given prefixes
+func PrefixMatcher(prefixes ...string) Matcher {
+	return func(record *zipfile.CDR) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(record.FileName, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SafeJoin returns the local path for the member name under directory,
+// rejecting names that are absolute or would escape directory ("zip slip")
+func SafeJoin(directory, name string) (string, error) {
+	if name == "" || strings.Contains(name, "\\") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: '%s'", ErrUnsafePath, name)
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || filepath.VolumeName(cleaned) != "" ||
+		cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: '%s'", ErrUnsafePath, name)
+	}
+	return filepath.Join(directory, cleaned), nil
+}
+
+type ExtractEvent string
+
+const (
+	ExtractEventExtracted ExtractEvent = "extracted"
+	ExtractEventSkipped   ExtractEvent = "skipped"
+	ExtractEventFailed    ExtractEvent = "failed"
+)
+
+type ExtractOptions struct {
+	// Match selects the members to extract. Defaults to MatchAll.
+	Match Matcher
+	// Parallelism is the number of members downloaded concurrently. Defaults to DefaultExtractParallelism.
+	Parallelism int
+	// Overwrite extracts members even if a local file with the same size and CRC already exists
+	Overwrite bool
+	// OnProgress, if set, is called (possibly concurrently) after each member is processed
+	OnProgress func(event ExtractEvent, record *zipfile.CDR, err error)
+}
+
+type ExtractSummary struct {
+	Extracted int64
+	Skipped   int64
+	Failed    int64
+	Bytes     int64
+	Took      time.Duration
+}
+
+func (s *ExtractSummary) String() string {
+	return fmt.Sprintf("extracted %d files (%d bytes), skipped %d, failed %d in %s",
+		s.Extracted, s.Bytes, s.Skipped, s.Failed, s.Took.Round(time.Millisecond))
+}
+
+// ExtractAll extracts the selected members into directory, recreating their directory structure
+// and restoring modification times and Unix permissions. Members are downloaded concurrently.
+// Failing members don't stop the extraction; their errors are joined and returned alongside the summary.
+func (a *Archive) ExtractAll(ctx context.Context, directory string, opts *ExtractOptions) (*ExtractSummary, error) {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+	match := opts.Match
+	if match == nil {
+		match = MatchAll
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultExtractParallelism
+	}
+	progress := opts.OnProgress
+	if progress == nil {
+		progress = func(ExtractEvent, *zipfile.CDR, error) {}
+	}
+
+	start := time.Now()
+	summary := &ExtractSummary{}
+	errs := make([]error, 0)
+	errsLock := &sync.Mutex{}
+	fail := func(record *zipfile.CDR, err error) {
+		atomic.AddInt64(&summary.Failed, 1)
+		errsLock.Lock()
+		errs = append(errs, fmt.Errorf("%s: %w", record.FileName, err))
+		errsLock.Unlock()
+		progress(ExtractEventFailed, record, err)
+	}
+
+	// directories are created upfront, their times are set once all files are written
+	dirs := make(map[string]*zipfile.CDR)
+	work := make(chan *zipfile.CDR)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range work {
+				n, skipped, err := a.extractRecord(directory, record, opts.Overwrite)
+				switch {
+				case err != nil:
+					fail(record, err)
+				case skipped:
+					atomic.AddInt64(&summary.Skipped, 1)
+					progress(ExtractEventSkipped, record, nil)
+				default:
+					atomic.AddInt64(&summary.Extracted, 1)
+					atomic.AddInt64(&summary.Bytes, n)
+					progress(ExtractEventExtracted, record, nil)
+				}
+			}
+		}()
+	}
+
+	for _, record := range a.Records() {
+		if ctx.Err() != nil {
+			break
+		}
+		if !match(record) {
+			continue
+		}
+		target, err := SafeJoin(directory, record.FileName)
+		if err != nil {
+			fail(record, err)
+			continue
+		}
+		if record.Mode&fs.ModeSymlink != 0 {
+			// never create links from untrusted archives
+			atomic.AddInt64(&summary.Skipped, 1)
+			progress(ExtractEventSkipped, record, nil)
+			continue
+		}
+		if record.Mode.IsDir() {
+			if err := os.MkdirAll(target, defaultDirMode); err != nil {
+				fail(record, err)
+				continue
+			}
+			dirs[target] = record
+			continue
+		}
+		work <- record
+	}
+	close(work)
+	wg.Wait()
+
+	// deepest first, so setting a child's time doesn't touch an already restored parent
+	dirPaths := make([]string, 0, len(dirs))
+	for dirPath := range dirs {
+		dirPaths = append(dirPaths, dirPath)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirPaths)))
+	for _, dirPath := range dirPaths {
+		record := dirs[dirPath]
+		if perm := record.Mode.Perm(); perm != 0 {
+			_ = os.Chmod(dirPath, perm|0700)
+		}
+		_ = os.Chtimes(dirPath, record.Modified, record.Modified)
+	}
+
+	summary.Took = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return summary, errors.Join(errs...)
+}
+
+func localCRC32(filename string) (uint32, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// extractRecord writes a single member to its location under directory.
+// Content is written to a temporary file that is renamed into place once its CRC was verified.
+func (a *Archive) extractRecord(directory string, record *zipfile.CDR, overwrite bool) (int64, bool, error) {
+	target, err := SafeJoin(directory, record.FileName)
+	if err != nil {
+		return 0, false, err
+	}
+	if !overwrite {
+		if stat, err := os.Stat(target); err == nil && stat.Mode().IsRegular() &&
+			stat.Size() == int64(record.UncompressedSizeBytes) {
+			if crc, err := localCRC32(target); err == nil && crc == record.CRC32Uncompressed {
+				return 0, true, nil
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), defaultDirMode); err != nil {
+		return 0, false, err
+	}
+	reader, err := a.OpenRecord(record)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".cz-extract-*")
+	if err != nil {
+		return 0, false, err
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(tmp, h), reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && h.Sum32() != record.CRC32Uncompressed {
+		err = fmt.Errorf("%w: expected %08x, got %08x", ErrChecksumMismatch, record.CRC32Uncompressed, h.Sum32())
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return n, false, err
+	}
+	perm := record.Mode.Perm()
+	if perm == 0 {
+		perm = defaultFileMode
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		_ = os.Remove(tmp.Name())
+		return n, false, err
+	}
+	if err := os.Chtimes(tmp.Name(), record.Modified, record.Modified); err != nil {
+		_ = os.Remove(tmp.Name())
+		return n, false, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		_ = os.Remove(tmp.Name())
+		return n, false, err
+	}
+	return n, false, nil
+}
@@ -0,0 +1,71 @@
+package cloudzip_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+)
+
+func TestArchive_ExtractAll(t *testing.T) {
+	archive, err := cloudzip.NewClient().Open(context.Background(), regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	match, err := cloudzip.GlobMatcher("a", "*.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	summary, err := archive.ExtractAll(context.Background(), dir, &cloudzip.ExtractOptions{Match: match})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Extracted != 2 || summary.Failed != 0 {
+		t.Errorf("expected 2 extracted files, got %s", summary)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a", "b", "c", "d.txt"))
+	if err != nil {
+		t.Fatalf("expected file to be extracted: %v", err)
+	}
+	if len(data) != 30 {
+		t.Errorf("expected 30 bytes, got %d", len(data))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", "bar.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected foo/bar.txt not to be extracted")
+	}
+
+	// files with matching size and CRC are skipped
+	summary, err = archive.ExtractAll(context.Background(), dir, &cloudzip.ExtractOptions{Match: match})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Skipped != 2 || summary.Extracted != 0 {
+		t.Errorf("expected 2 skipped files, got %s", summary)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	cases := map[string]bool{
+		"a/b.txt":        true,
+		"a/../b.txt":     true,
+		"../b.txt":       false,
+		"a/../../b.txt":  false,
+		"/etc/passwd":    false,
+		"..":             false,
+		"a\\..\\..\\b":   false,
+		"":               false,
+		"...hidden/file": true,
+	}
+	for name, safe := range cases {
+		_, err := cloudzip.SafeJoin("/tmp/extract", name)
+		if safe && err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if !safe && !errors.Is(err, cloudzip.ErrUnsafePath) {
+			t.Errorf("%s: expected ErrUnsafePath, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package cloudzip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"regexp"
+	"sync"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	DefaultGrepParallelism = 8
+	// binarySniffSize is how much of a member is inspected for NUL bytes to decide if it is binary
+	binarySniffSize = 8 * 1024
+	// MaxGrepLineSize bounds the memory used for a single line: longer lines are matched as they are read,
+	// and only their first MaxGrepLineSize bytes are returned
+	MaxGrepLineSize = 1024 * 1024
+)
+
+var ErrEncrypted = errors.New("encrypted members are not supported")
+
+type GrepOptions struct {
+	// Match selects the members to search. Defaults to MatchAll.
+	Match Matcher
+	// Fixed treats the pattern as a literal string rather than a regular expression
+	Fixed bool
+	// IgnoreCase matches the pattern case-insensitively
+	IgnoreCase bool
+	// MaxFileSize skips members whose uncompressed size is larger (0 for no limit)
+	MaxFileSize uint64
+	// FirstMatchOnly stops reading a member once it matched (e.g. when only listing matching members)
+	FirstMatchOnly bool
+	// Parallelism is the number of members searched concurrently. Defaults to DefaultGrepParallelism.
+	Parallelism int
+}
+
+// GrepMatch is a single matching line
+type GrepMatch struct {
+	LineNumber int
+	// Line is the content of the line, up to MaxGrepLineSize bytes
+	Line []byte
+	// Truncated is set for lines longer than MaxGrepLineSize
+	Truncated bool
+}
+
+// GrepResult holds the matches found in a single member
+type GrepResult struct {
+	Record *zipfile.CDR
+	// Count is the number of matching lines
+	Count int
+	// Matches are the matching lines, empty for binary members
+	Matches []GrepMatch
+	// Binary is set for members containing NUL bytes: their matching lines are counted, but not returned
+	Binary bool
+	Err    error
+}
+
+// CompileGrepPattern returns the regular expression used to search for pattern
+func CompileGrepPattern(pattern string, fixed, ignoreCase bool) (*regexp.Regexp, error) {
+	if fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Grep searches the content of the selected members for lines matching pattern.
+// Members are streamed and searched concurrently; fn is called (never concurrently) once per searched member,
+// in the order searches complete. Returning an error from fn stops the search and returns that error.
+func (a *Archive) Grep(ctx context.Context, pattern string, opts *GrepOptions, fn func(result *GrepResult) error) error {
+	if opts == nil {
+		opts = &GrepOptions{}
+	}
+	re, err := CompileGrepPattern(pattern, opts.Fixed, opts.IgnoreCase)
+	if err != nil {
+		return err
+	}
+	match := opts.Match
+	if match == nil {
+		match = MatchAll
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultGrepParallelism
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan *zipfile.CDR)
+	results := make(chan *GrepResult)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range work {
+				result := a.grepRecord(ctx, re, record, opts.FirstMatchOnly)
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(work)
+		for _, record := range a.Records() {
+			if record.Mode.IsDir() || record.Mode&fs.ModeSymlink != 0 || !match(record) {
+				continue
+			}
+			if opts.MaxFileSize > 0 && record.UncompressedSizeBytes > opts.MaxFileSize {
+				continue
+			}
+			select {
+			case work <- record:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (a *Archive) grepRecord(ctx context.Context, re *regexp.Regexp, record *zipfile.CDR, firstOnly bool) *GrepResult {
+	result := &GrepResult{Record: record}
+	if record.Encrypted() {
+		result.Err = ErrEncrypted
+		return result
+	}
+	r, err := zipfile.ReaderForRecord(record, a.archive.Fetcher())
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer func() { _ = closeReader(r) }()
+	reader := bufio.NewReaderSize(r, binarySniffSize)
+	if head, _ := reader.Peek(binarySniffSize); bytes.IndexByte(head, 0) >= 0 {
+		result.Binary = true
+	}
+	for lineNumber := 1; ; lineNumber++ {
+		if lineNumber%1024 == 0 && ctx.Err() != nil {
+			result.Err = ctx.Err()
+			return result
+		}
+		line, truncated, err := readLine(reader, MaxGrepLineSize)
+		var matched bool
+		if truncated {
+			// match the rest of the line as it is read, keeping only its start
+			rest := &lineRemainder{r: reader}
+			matched = re.MatchReader(bufio.NewReader(io.MultiReader(bytes.NewReader(line), rest)))
+			_, _ = io.Copy(io.Discard, rest)
+			truncated = len(line) > MaxGrepLineSize || rest.n > 0
+			line, err = line[:MaxGrepLineSize], rest.err
+		} else if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			matched = re.Match(line)
+		}
+		if matched {
+			result.Count++
+			if !result.Binary {
+				result.Matches = append(result.Matches, GrepMatch{LineNumber: lineNumber, Line: line, Truncated: truncated})
+			}
+			if firstOnly {
+				return result
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return result
+		} else if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+}
+
+// readLine reads a line, including its "\n". Lines longer than limit are truncated: reading stops
+// once at least limit bytes of them were read, leaving the rest to be read from r.
+func readLine(r *bufio.Reader, limit int) ([]byte, bool, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, false, err
+		}
+		if len(line) >= limit {
+			return line, true, nil
+		}
+	}
+}
+
+// lineRemainder reads the rest of a truncated line from r, up to (and consuming) its "\n" or "\r\n"
+type lineRemainder struct {
+	r *bufio.Reader
+	// n is the number of bytes of the line read
+	n    int
+	done bool
+	err  error
+}
+
+func (l *lineRemainder) Read(p []byte) (int, error) {
+	n, err := l.read(p)
+	l.n += n
+	return n, err
+}
+
+func (l *lineRemainder) read(p []byte) (int, error) {
+	if l.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, err := l.r.Peek(min(len(p), max(l.r.Buffered(), 2)))
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		n := copy(p, bytes.TrimSuffix(buf[:i], []byte("\r")))
+		_, _ = l.r.Discard(i + 1)
+		l.done = true
+		return n, nil
+	}
+	if err != nil {
+		n := copy(p, bytes.TrimSuffix(buf, []byte("\r")))
+		_, _ = l.r.Discard(len(buf))
+		l.done = true
+		if !errors.Is(err, io.EOF) {
+			l.err = err
+		}
+		return n, nil
+	}
+	if buf[len(buf)-1] == '\r' {
+		// keep a "\r" that may end the line for the next read
+		buf = buf[:len(buf)-1]
+	}
+	n := copy(p, buf)
+	_, _ = l.r.Discard(n)
+	return n, nil
+}
@@ -0,0 +1,121 @@
+package cloudzip_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func TestArchive_Grep(t *testing.T) {
+	archive, err := cloudzip.NewClient().Open(context.Background(), regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := []struct {
+		Name    string
+		Pattern string
+		Opts    *cloudzip.GrepOptions
+		Counts  map[string]int
+	}{
+		{"regex", "dir.*y", &cloudzip.GrepOptions{},
+			map[string]int{"a/b/c/d.txt": 1, "foo/bar.txt": 1, "baz.txt": 0}},
+		{"fixed", "a directory!", &cloudzip.GrepOptions{Fixed: true},
+			map[string]int{"a/b/c/d.txt": 0, "foo/bar.txt": 1, "baz.txt": 0}},
+		{"ignore case", "BAZ|\\d+", &cloudzip.GrepOptions{IgnoreCase: true},
+			map[string]int{"a/b/c/d.txt": 0, "foo/bar.txt": 0, "baz.txt": 2}},
+		{"max file size", "file", &cloudzip.GrepOptions{MaxFileSize: 25},
+			map[string]int{"foo/bar.txt": 1, "baz.txt": 0}},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			counts := make(map[string]int)
+			err := archive.Grep(context.Background(), cas.Pattern, cas.Opts, func(result *cloudzip.GrepResult) error {
+				if result.Err != nil {
+					t.Errorf("unexpected error for %s: %v", result.Record.FileName, result.Err)
+				}
+				if len(result.Matches) != result.Count {
+					t.Errorf("expected %d matched lines, got %d", result.Count, len(result.Matches))
+				}
+				counts[result.Record.FileName] = result.Count
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(counts) != len(cas.Counts) {
+				t.Errorf("expected %d searched files, got %d: %v", len(cas.Counts), len(counts), counts)
+			}
+			for name, expected := range cas.Counts {
+				if counts[name] != expected {
+					t.Errorf("expected %d matches in %s, got %d", expected, name, counts[name])
+				}
+			}
+		})
+	}
+}
+
+func TestArchive_Grep_LongLinesAndBinary(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "grep.zip")
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range map[string]string{
+		"long.txt":   "needle" + strings.Repeat("x", 3*cloudzip.MaxGrepLineSize) + "\nneedle\n",
+		"late.txt":   strings.Repeat("x", 2*cloudzip.MaxGrepLineSize) + "needle\r\nneedle\r\n",
+		"binary.bin": "\x00" + strings.Repeat("needle\n", 100),
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	open := &atomic.Int64{}
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &trackingFetcher{next: next, open: open}
+	}))
+	archive, err := client.Open(context.Background(), "file://"+p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := make(map[string]*cloudzip.GrepResult)
+	err = archive.Grep(context.Background(), "needle", &cloudzip.GrepOptions{Fixed: true}, func(result *cloudzip.GrepResult) error {
+		if result.Err != nil {
+			t.Errorf("unexpected error for %s: %v", result.Record.FileName, result.Err)
+		}
+		results[result.Record.FileName] = result
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"long.txt", "late.txt"} {
+		r := results[name]
+		if r == nil || r.Count != 2 || len(r.Matches[0].Line) != cloudzip.MaxGrepLineSize || !r.Matches[0].Truncated ||
+			r.Matches[1].LineNumber != 2 || string(r.Matches[1].Line) != "needle" || r.Matches[1].Truncated {
+			t.Errorf("%s: expected the long line to be matched truncated, followed by line 2, got %+v", name, r)
+		}
+	}
+	if binary := results["binary.bin"]; binary == nil || !binary.Binary || binary.Count != 100 || len(binary.Matches) != 0 {
+		t.Errorf("expected all matching lines of a binary member to be counted, got %+v", binary)
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("%d readers left open", n)
+	}
+}
@@ -0,0 +1,490 @@
+package cloudzip
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	// KeepMethod, returned by the MethodSelector of a repack, keeps the member's current compression method
+	KeepMethod uint16 = 0xffff
+	// MaxAlign is the largest RepackOptions.Align, as the padding must fit in a single extra field
+	MaxAlign = 65535
+	// IndexSuffix is appended to the URI of a repacked archive to name its index
+	IndexSuffix = ".idx"
+	// indexHeader is the first line of an index, identifying its format
+	indexHeader = "# cz index v1"
+)
+
+// keptExtraFields are the extra fields copied to repacked members; all others are either rewritten
+// (timestamps, ZIP64 sizes) or redundant
+var keptExtraFields = map[uint16]bool{
+	zipfile.InfoZipUnixExtraId:   true,
+	zipfile.InfoZipUnicodePathId: true,
+	zipfile.AESExtraId:           true,
+}
+
+type RepackOptions struct {
+	// Method, if set, chooses the compression method of each member, and members using a different method
+	// are recompressed. Members are otherwise copied as is, without being decompressed; this is also the case
+	// for encrypted members and members using methods that can't be decompressed.
+	// Method is called with directory names as well, and may return KeepMethod.
+	Method MethodSelector
+	// Order of members in the new archive, as accepted by SortEntries. Defaults to "dir".
+	Order string
+	// Align pads the local headers of stored members, so that their data starts at a multiple of Align bytes
+	// and can be memory mapped or read directly. Zero disables alignment; at most MaxAlign.
+	Align int64
+	// Index also writes an index of the new archive to its URI with IndexSuffix appended (Client.Repack only)
+	Index bool
+	// Comment is the archive comment; Client.Repack keeps the comment of the source archive
+	Comment string
+	// Parallelism is the number of members read ahead of the writer. Defaults to DefaultCreateParallelism.
+	Parallelism int
+	// TempDir holds recompressed data of large members until it is written. Defaults to os.TempDir.
+	TempDir string
+	// OnProgress, if set, is called after each member is written to the new archive
+	OnProgress func(entry *CreateEntry, method uint16, compressedSize int64)
+}
+
+type RepackSummary struct {
+	CreateSummary
+	// Recompressed is the number of files whose compression method was changed
+	Recompressed int64
+	// Index locates the data of every file in the new archive
+	Index []*IndexEntry
+}
+
+func (s *RepackSummary) String() string {
+	return fmt.Sprintf("repacked %d files (%d bytes, %d recompressed) and %d directories, archive is %d bytes, in %s",
+		s.Files, s.Bytes, s.Recompressed, s.Directories, s.ArchiveBytes, s.Took.Round(time.Millisecond))
+}
+
+// IndexEntry locates the data of a single member, so that it can be read with a single range request,
+// without reading the central directory or local file header of the archive
+type IndexEntry struct {
+	Name string
+	// Offset is the offset of the member's (possibly compressed) data in the archive
+	Offset           int64
+	CompressedSize   int64
+	UncompressedSize int64
+	Method           uint16
+	CRC32            uint32
+}
+
+// WriteIndex writes entries as a compact, tab separated text file: a header line, followed by a line
+// per member holding its data offset, compressed size, uncompressed size, method, CRC-32 (hex) and name.
+// Names are last so they may contain tabs; names containing line breaks are quoted.
+func WriteIndex(w io.Writer, entries []*IndexEntry) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintln(bw, indexHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name
+		if strings.ContainsAny(name, "\r\n") || strings.HasPrefix(name, `"`) {
+			name = strconv.Quote(name)
+		}
+		_, err := fmt.Fprintf(bw, "%d\t%d\t%d\t%d\t%08x\t%s\n",
+			e.Offset, e.CompressedSize, e.UncompressedSize, e.Method, e.CRC32, name)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadIndex parses an index written by WriteIndex
+func ReadIndex(r io.Reader) ([]*IndexEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() || scanner.Text() != indexHeader {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid index: missing '%s' header", indexHeader)
+	}
+	entries := make([]*IndexEntry, 0)
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.SplitN(scanner.Text(), "\t", 6)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid index: line %d: expected 6 fields", line)
+		}
+		e := &IndexEntry{Name: fields[5]}
+		var err error
+		var n uint64
+		if e.Offset, err = strconv.ParseInt(fields[0], 10, 64); err == nil {
+			if e.CompressedSize, err = strconv.ParseInt(fields[1], 10, 64); err == nil {
+				if e.UncompressedSize, err = strconv.ParseInt(fields[2], 10, 64); err == nil {
+					if n, err = strconv.ParseUint(fields[3], 10, 16); err == nil {
+						e.Method = uint16(n)
+						n, err = strconv.ParseUint(fields[4], 16, 32)
+						e.CRC32 = uint32(n)
+					}
+				}
+			}
+		}
+		if err == nil && strings.HasPrefix(e.Name, `"`) {
+			e.Name, err = strconv.Unquote(e.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid index: line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// canDecompress returns true for the compression methods supported by zipfile.ReaderForRecord
+func canDecompress(method uint16) bool {
+	return method == zip.Store || method == zip.Deflate || method == zipfile.Zstd
+}
+
+// repackExtra returns the extra fields of record that are worth keeping, followed by a timestamp
+func repackExtra(record *zipfile.CDR) []byte {
+	extra := make([]byte, 0)
+	modified := record.Modified
+	for _, field := range zipfile.ParseExtraFields(record.ExtraFields) {
+		if !keptExtraFields[field.ID] {
+			continue
+		}
+		extra = binary.LittleEndian.AppendUint16(extra, field.ID)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(field.Data)))
+		extra = append(extra, field.Data...)
+	}
+	if precise := zipfile.ParseExtraMetadata(record.ExtraFields).Modified; precise != nil {
+		modified = *precise
+	}
+	return append(extra, extendedTimestamp(modified)...)
+}
+
+// repackHeader returns the header of record in the new archive, compressed with method
+func repackHeader(record *zipfile.CDR, method uint16) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:               record.FileName,
+		Comment:            string(record.FileComment),
+		CreatorVersion:     record.CreatorVersion,
+		ReaderVersion:      record.VersionNeededToExtract,
+		Flags:              record.Flags,
+		Method:             method,
+		Modified:           record.Modified,
+		CRC32:              record.CRC32Uncompressed,
+		CompressedSize64:   record.CompressedSizeBytes,
+		UncompressedSize64: record.UncompressedSizeBytes,
+		ExternalAttrs:      record.ExternalAttributes,
+	}
+	if record.Mode.IsDir() {
+		header.Name += "/"
+	}
+	// the DOS timestamp is kept exactly, since (traditionally) encrypted members may use it to verify passwords
+	header.ModifiedDate, header.ModifiedTime = msDosTime(record.Modified)
+	header.Extra = repackExtra(record)
+	if !record.Encrypted() {
+		// sizes and CRC are known upfront, no data descriptor is needed
+		header.Flags &^= 0x8
+	}
+	if method != record.CompressionMethod {
+		header.Flags &= 0x800 // keep only the UTF-8 flag, other bits are method specific
+		header.ReaderVersion = readerVersion(method)
+	}
+	return header
+}
+
+// lazyReader opens its underlying reader on first read, so that members can be queued without
+// holding connections open until they are written
+type lazyReader struct {
+	open func() (io.ReadCloser, error)
+	r    io.ReadCloser
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil {
+		r, err := l.open()
+		if err != nil {
+			return 0, err
+		}
+		l.r = r
+	}
+	return l.r.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	if l.r == nil {
+		return nil
+	}
+	return l.r.Close()
+}
+
+// recompress decompresses record and compresses it with method, into memory or a temporary file.
+// Members that don't get smaller when compressed are stored instead.
+func (a *Archive) recompress(entry *CreateEntry, record *zipfile.CDR, method uint16, tempDir string) *compressedEntry {
+	c := &compressedEntry{entry: entry, cleanup: func() {}}
+	r, err := a.OpenRecord(record)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	defer func() { _ = r.Close() }()
+	sp, err := newSpool(entry.Size, tempDir)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	c.cleanup = sp.Cleanup
+	counter := &countingWriter{w: sp}
+	cw, err := compressor(method, counter)
+	if err != nil {
+		c.cleanup()
+		c.err = err
+		return c
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(cw, h), r)
+	if closeErr := cw.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && (h.Sum32() != record.CRC32Uncompressed || uint64(n) != record.UncompressedSizeBytes) {
+		err = ErrChecksumMismatch
+	}
+	if err == nil {
+		c.data, err = sp.Reader()
+	}
+	if err != nil {
+		c.cleanup()
+		c.err = fmt.Errorf("%s: %w", record.FileName, err)
+		return c
+	}
+	if method != zip.Store && counter.n >= n {
+		// incompressible, store instead (like create)
+		c.cleanup()
+		if record.CompressionMethod == zip.Store {
+			return a.rawEntry(entry, record)
+		}
+		return a.recompress(entry, record, zip.Store, tempDir)
+	}
+	c.header = repackHeader(record, method)
+	c.header.CompressedSize64 = uint64(counter.n)
+	return c
+}
+
+// rawEntry copies the compressed data of record as is
+func (a *Archive) rawEntry(entry *CreateEntry, record *zipfile.CDR) *compressedEntry {
+	raw := &lazyReader{open: func() (io.ReadCloser, error) { return a.OpenRaw(record) }}
+	return &compressedEntry{
+		entry:   entry,
+		header:  repackHeader(record, record.CompressionMethod),
+		data:    raw,
+		cleanup: func() { _ = raw.Close() },
+	}
+}
+
+// localHeaderSize returns the size of the local file header archive/zip writes for header with CreateRaw
+func localHeaderSize(header *zip.FileHeader) int64 {
+	size := int64(30 + len(header.Name) + len(header.Extra))
+	if header.Flags&0x8 == 0 && (header.CompressedSize64 > 0xffffffff || header.UncompressedSize64 > 0xffffffff) {
+		size += 20 // ZIP64 sizes
+	}
+	return size
+}
+
+// alignmentPadding returns an extra field that, appended to the local header of a member starting at offset,
+// aligns the member's data to a multiple of align bytes
+func alignmentPadding(header *zip.FileHeader, offset, align int64) []byte {
+	pad := (align - (offset+localHeaderSize(header))%align) % align
+	if pad == 0 {
+		return nil
+	}
+	for pad < 4 {
+		pad += align // too small to hold an extra field header
+	}
+	padding := make([]byte, pad)
+	binary.LittleEndian.PutUint16(padding[0:2], zipfile.AlignmentExtraId)
+	binary.LittleEndian.PutUint16(padding[2:4], uint16(pad-4))
+	return padding
+}
+
+// WriteRepacked writes a copy of the archive to w, with members reordered, optionally recompressed
+// and aligned, and with redundant extra fields and data descriptors removed. Names, modes, timestamps
+// and comments are preserved. Members are streamed from the archive with range requests,
+// so neither the archive nor its copy is ever buffered.
+func (a *Archive) WriteRepacked(ctx context.Context, w io.Writer, opts *RepackOptions) (*RepackSummary, error) {
+	start := time.Now()
+	if opts == nil {
+		opts = &RepackOptions{}
+	}
+	if opts.Align < 0 || opts.Align > MaxAlign {
+		return nil, fmt.Errorf("invalid alignment: %d, expected 0 (no alignment) to %d", opts.Align, MaxAlign)
+	}
+	order := opts.Order
+	if order == "" {
+		order = "dir"
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultCreateParallelism
+	}
+	progress := opts.OnProgress
+	if progress == nil {
+		progress = func(*CreateEntry, uint16, int64) {}
+	}
+
+	records := make(map[*CreateEntry]*zipfile.CDR)
+	entries := make([]*CreateEntry, 0, len(a.Records()))
+	for _, record := range a.Records() {
+		entry := &CreateEntry{
+			Name:     record.FileName,
+			Size:     int64(record.UncompressedSizeBytes),
+			Mode:     record.Mode,
+			Modified: record.Modified,
+		}
+		if entry.IsDir() {
+			entry.Name += "/"
+		}
+		records[entry] = record
+		entries = append(entries, entry)
+	}
+	if err := SortEntries(entries, order, false); err != nil {
+		return nil, err
+	}
+
+	summary := &RepackSummary{}
+	counter := &countingWriter{w: w}
+	zw := zip.NewWriter(counter)
+	if err := zw.SetComment(opts.Comment); err != nil {
+		return nil, err
+	}
+	prepare := func(entry *CreateEntry) *compressedEntry {
+		record := records[entry]
+		method := record.CompressionMethod
+		if opts.Method != nil && !record.Encrypted() && canDecompress(method) && !entry.IsDir() {
+			if selected := opts.Method(entry.Name, entry.Size); selected != KeepMethod {
+				method = selected
+			}
+		}
+		if method != record.CompressionMethod {
+			return a.recompress(entry, record, method, opts.TempDir)
+		}
+		return a.rawEntry(entry, record)
+	}
+	write := func(c *compressedEntry) error {
+		// flush, so that counter.n is the offset of the local header
+		if err := zw.Flush(); err != nil {
+			return err
+		}
+		offset := counter.n
+		if c.entry.IsDir() {
+			if _, err := zw.CreateRaw(c.header); err != nil {
+				return err
+			}
+			summary.Directories++
+			progress(c.entry, c.header.Method, 0)
+			return nil
+		}
+		extra := c.header.Extra
+		// empty members have no data to align
+		if opts.Align > 0 && c.header.Method == zip.Store && c.header.CompressedSize64 > 0 && !records[c.entry].Encrypted() {
+			c.header.Extra = append(c.header.Extra, alignmentPadding(c.header, offset, opts.Align)...)
+		}
+		if err := writeEntry(zw, c); err != nil {
+			return err
+		}
+		dataOffset := offset + localHeaderSize(c.header)
+		// the local header is written by now: keep the padding out of the central directory record,
+		// which zw writes from the same header on Close
+		c.header.Extra = extra
+		summary.Index = append(summary.Index, &IndexEntry{
+			Name:             c.header.Name,
+			Offset:           dataOffset,
+			CompressedSize:   int64(c.header.CompressedSize64),
+			UncompressedSize: int64(c.header.UncompressedSize64),
+			Method:           c.header.Method,
+			CRC32:            c.header.CRC32,
+		})
+		summary.Files++
+		summary.Bytes += int64(c.header.UncompressedSize64)
+		if c.header.Method != records[c.entry].CompressionMethod {
+			summary.Recompressed++
+		}
+		progress(c.entry, c.header.Method, int64(c.header.CompressedSize64))
+		return nil
+	}
+	err := writeOrdered(ctx, entries, parallelism, prepare, write)
+	if err == nil {
+		err = zw.Close()
+	}
+	summary.ArchiveBytes = counter.n
+	summary.Took = time.Since(start)
+	return summary, err
+}
+
+// Repack writes an optimized copy of the archive at src to dst (see Archive.WriteRepacked),
+// followed by its index if requested. src and dst may be the same, since dst is replaced atomically
+// once the copy is complete; nothing is written to dst if repacking fails.
+func (c *Client) Repack(ctx context.Context, src, dst string, opts *RepackOptions, writerOpts ...remote.WriterOpt) (*RepackSummary, error) {
+	options := RepackOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	f, err := c.Fetcher(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	it, err := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(ctx, f)).Iterator()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*zipfile.CDR, 0)
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	options.Comment = string(it.Location().Comment)
+	archive := &Archive{
+		uri:     src,
+		client:  c,
+		fetcher: f,
+		archive: zipfile.NewArchiveFromRecords(zipfile.NewStorageAdapter(ctx, f), records),
+	}
+
+	w, err := c.Writer(ctx, dst, writerOpts...)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := archive.WriteRepacked(ctx, w, &options)
+	if err != nil {
+		_ = w.Abort()
+		return summary, err
+	}
+	if err := w.Close(); err != nil {
+		return summary, err
+	}
+	if !options.Index {
+		return summary, nil
+	}
+	iw, err := c.Writer(ctx, dst+IndexSuffix, writerOpts...)
+	if err != nil {
+		return summary, err
+	}
+	if err := WriteIndex(iw, summary.Index); err != nil {
+		_ = iw.Abort()
+		return summary, err
+	}
+	return summary, iw.Close()
+}
@@ -0,0 +1,321 @@
+package cloudzip_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+func TestClient_Repack(t *testing.T) {
+	modified := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	// an NTFS timestamp extra field, which is redundant once repacked
+	ntfs := make([]byte, 36)
+	binary.LittleEndian.PutUint16(ntfs[0:2], zipfile.NTFSExtraId)
+	binary.LittleEndian.PutUint16(ntfs[2:4], 32)
+	binary.LittleEndian.PutUint16(ntfs[8:10], 1)
+	binary.LittleEndian.PutUint16(ntfs[10:12], 24)
+	files := []struct {
+		Name    string
+		Content string
+		Method  uint16
+	}{
+		{"z/b.txt", strings.Repeat("b", 1000), zip.Deflate},
+		{"a.txt", "aaa", zip.Store},
+		{"z/a.bin", strings.Repeat("binary", 100), zip.Store},
+		{"b/c.txt", strings.Repeat("c", 1000), zip.Deflate},
+	}
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range files {
+		header := &zip.FileHeader{Name: f.Name, Method: f.Method, Modified: modified, Extra: ntfs}
+		header.SetMode(0640)
+		w, err := zw.CreateHeader(header) // written with data descriptors
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.Content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.SetComment("archive comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "dest.zip")
+	client := cloudzip.NewClient()
+	summary, err := client.Repack(context.Background(), "file://"+src, "file://"+dest, &cloudzip.RepackOptions{
+		Method: cloudzip.NewMethodSelector(cloudzip.KeepMethod, map[string]uint16{".bin": zip.Deflate}, 0),
+		Align:  64,
+		Index:  true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.Files != 4 || summary.Recompressed != 1 {
+		t.Errorf("expected 4 files, 1 recompressed, got %s", summary)
+	}
+
+	stdlib, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("archive/zip could not read file: %v", err)
+	}
+	defer func() { _ = stdlib.Close() }()
+	if stdlib.Comment != "archive comment" {
+		t.Errorf("expected archive comment to be preserved, got '%s'", stdlib.Comment)
+	}
+	contents := make(map[string]string)
+	for _, f := range files {
+		contents[f.Name] = f.Content
+	}
+	names := make([]string, 0)
+	for _, f := range stdlib.File {
+		names = append(names, f.Name)
+		if f.Flags&0x8 != 0 {
+			t.Errorf("%s: unexpected data descriptor", f.Name)
+		}
+		for _, id := range zipfile.ParseExtraMetadata(f.Extra).FieldIDs {
+			if id != zipfile.ExtendedTimestampExtraId {
+				t.Errorf("%s: unexpected extra field 0x%04x", f.Name, id)
+			}
+		}
+		if !f.Modified.Equal(modified) || f.Mode() != 0640 {
+			t.Errorf("%s: expected mode and time to be preserved, got %s %s", f.Name, f.Mode(), f.Modified)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", f.Name, err)
+		}
+		if string(data) != contents[f.Name] {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+	}
+	if strings.Join(names, ",") != "a.txt,b/c.txt,z/a.bin,z/b.txt" {
+		t.Errorf("unexpected order: %v", names)
+	}
+
+	idx, err := os.Open(dest + cloudzip.IndexSuffix)
+	if err != nil {
+		t.Fatalf("expected index to be written: %v", err)
+	}
+	defer func() { _ = idx.Close() }()
+	index, err := cloudzip.ReadIndex(idx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repacked, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range index {
+		if e.Method == zip.Store {
+			if e.Offset%64 != 0 {
+				t.Errorf("%s: expected data offset %d to be aligned", e.Name, e.Offset)
+			}
+			if string(repacked[e.Offset:e.Offset+e.CompressedSize]) != "aaa" {
+				t.Errorf("%s: index doesn't point at the member's data", e.Name)
+			}
+		} else if e.Name == "z/a.bin" && e.Method != zip.Deflate {
+			t.Errorf("%s: expected recompression", e.Name)
+		}
+	}
+	if len(index) != 4 {
+		t.Errorf("expected 4 index entries, got %d", len(index))
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	entries := []*cloudzip.IndexEntry{
+		{Name: "a\tb.txt", Offset: 30, CompressedSize: 10, UncompressedSize: 20, Method: 8, CRC32: 0xdeadbeef},
+		{Name: "line\nbreak", Offset: 100, Method: 93},
+	}
+	buf := &bytes.Buffer{}
+	if err := cloudzip.WriteIndex(buf, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := cloudzip.ReadIndex(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(parsed) != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), len(parsed))
+	}
+	for i := range entries {
+		if *parsed[i] != *entries[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, entries[i], parsed[i])
+		}
+	}
+	if _, err := cloudzip.ReadIndex(strings.NewReader("not an index\n")); err == nil {
+		t.Errorf("expected an error for a missing header")
+	}
+}
+
+func TestClient_Repack_LargeAlignment(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	contents := map[string]string{
+		"a.txt": "aaa",
+		"b.txt": strings.Repeat("b", 100),
+		"c.txt": strings.Repeat("c", 5000),
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// padding local headers by more than the 1 KiB fetched along with them when reading a member
+	dest := filepath.Join(dir, "dest.zip")
+	client := cloudzip.NewClient()
+	summary, err := client.Repack(context.Background(), "file://"+src, "file://"+dest, &cloudzip.RepackOptions{
+		Align: 4096,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range summary.Index {
+		if e.Offset%4096 != 0 {
+			t.Errorf("%s: expected data offset %d to be aligned", e.Name, e.Offset)
+		}
+	}
+
+	archive, err := client.Open(context.Background(), "file://"+dest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, content := range contents {
+		record, err := archive.Stat(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(record.ExtraFields) > 100 {
+			t.Errorf("%s: expected alignment padding to be left out of the central directory, got %d bytes of extra fields",
+				name, len(record.ExtraFields))
+		}
+		var out bytes.Buffer
+		if _, err := archive.Extract(name, &out); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if out.String() != content {
+			t.Errorf("%s: content mismatch", name)
+		}
+		r, err := archive.OpenRange(record, 1, 2)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil || string(data) != content[1:3] {
+			t.Errorf("%s: expected range '%s', got '%s' (%v)", name, content[1:3], data, err)
+		}
+	}
+}
+
+func TestClient_Repack_AlignEmptyMember(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	// an empty member whose local header is padded beyond the 1 KiB fetched along with it
+	padding := make([]byte, 2000)
+	binary.LittleEndian.PutUint16(padding[0:2], zipfile.AlignmentExtraId)
+	binary.LittleEndian.PutUint16(padding[2:4], uint16(len(padding)-4))
+	if _, err := zw.CreateRaw(&zip.FileHeader{Name: "padded.txt", Method: zip.Store, Extra: padding}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"empty.txt": "", "a.txt": "aaa"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.zip")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "dest.zip")
+	client := cloudzip.NewClient()
+	_, err := client.Repack(context.Background(), "file://"+src, "file://"+dest, &cloudzip.RepackOptions{
+		Align: cloudzip.MaxAlign,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var archive *cloudzip.Archive
+	for _, p := range []string{src, dest} {
+		archive, err = client.Open(context.Background(), "file://"+p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for name, content := range map[string]string{"padded.txt": "", "empty.txt": "", "a.txt": "aaa"} {
+			var out bytes.Buffer
+			if _, err := archive.Extract(name, &out); err != nil {
+				t.Fatalf("%s: %s: unexpected error: %v", filepath.Base(p), name, err)
+			}
+			if out.String() != content {
+				t.Errorf("%s: %s: content mismatch", filepath.Base(p), name)
+			}
+		}
+	}
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"padded.txt", "empty.txt"} {
+		record, err := archive.Stat(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		// the extra field length of the local header
+		if n := binary.LittleEndian.Uint16(data[record.LocalFileHeaderOffset+28:]); n > 100 {
+			t.Errorf("%s: expected empty members not to be padded, got %d bytes of extra fields", name, n)
+		}
+	}
+
+	_, err = client.Repack(context.Background(), "file://"+src, "file://"+dest, &cloudzip.RepackOptions{
+		Align: cloudzip.MaxAlign + 1,
+	})
+	if err == nil {
+		t.Errorf("expected an error for an alignment larger than %d", cloudzip.MaxAlign)
+	}
+}
@@ -0,0 +1,230 @@
+package cloudzip
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	DefaultTopFiles = 10
+	noExtension     = "(none)"
+)
+
+// SizeBreakdown aggregates sizes for a group of members
+type SizeBreakdown struct {
+	Files             uint64  `json:"files"`
+	CompressedBytes   uint64  `json:"compressed_bytes"`
+	UncompressedBytes uint64  `json:"uncompressed_bytes"`
+	CompressionRatio  float64 `json:"compression_ratio"`
+}
+
+func (b *SizeBreakdown) add(f *zipfile.CDR) {
+	b.Files++
+	b.CompressedBytes += f.CompressedSizeBytes
+	b.UncompressedBytes += f.UncompressedSizeBytes
+}
+
+func (b *SizeBreakdown) finalize() {
+	b.CompressionRatio = compressionRatio(b.CompressedBytes, b.UncompressedBytes)
+}
+
+// compressionRatio returns uncompressed/compressed, or 1 for empty groups
+func compressionRatio(compressed, uncompressed uint64) float64 {
+	if compressed == 0 {
+		return 1
+	}
+	return float64(uncompressed) / float64(compressed)
+}
+
+type FileSize struct {
+	Name              string `json:"name"`
+	CompressedBytes   uint64 `json:"compressed_bytes"`
+	UncompressedBytes uint64 `json:"uncompressed_bytes"`
+}
+
+// Stats are aggregate statistics about an archive, computed from its central directory.
+// DuplicateNames is approximate: names are compared by their 64-bit hashes, so a name whose hash collides
+// with another's is reported too, though for n files the odds of any collision are about n²/2^65
+// (under one in a million for 6 million files).
+type Stats struct {
+	Files                  uint64                    `json:"files"`
+	CompressedBytes        uint64                    `json:"compressed_bytes"`
+	UncompressedBytes      uint64                    `json:"uncompressed_bytes"`
+	CompressionRatio       float64                   `json:"compression_ratio"`
+	Directories            uint64                    `json:"directories"`
+	ExplicitDirectories    uint64                    `json:"explicit_directories"`
+	MaxDepth               int                       `json:"max_depth"`
+	Zip64                  bool                      `json:"zip64"`
+	Zip64Entries           uint64                    `json:"zip64_entries"`
+	EncryptedEntries       uint64                    `json:"encrypted_entries"`
+	CompressionMethods     map[string]*SizeBreakdown `json:"compression_methods"`
+	Extensions             map[string]*SizeBreakdown `json:"extensions"`
+	Creators               map[string]uint64         `json:"creators"`
+	Largest                []*FileSize               `json:"largest"`
+	DuplicateNames         []string                  `json:"duplicate_names"`
+	Comment                string                    `json:"comment"`
+	CentralDirectoryOffset uint64                    `json:"central_directory_offset"`
+	CentralDirectorySize   uint64                    `json:"central_directory_size"`
+	// EstimatedRangeRequests is the number of range requests needed to extract every file:
+	// one for the EOCD, one for the central directory and one per non-empty file
+	EstimatedRangeRequests uint64 `json:"estimated_range_requests"`
+}
+
+// StatsCollector computes Stats from a stream of records.
+// Names are only kept as 64-bit hashes (for duplicate detection), so memory grows by a few bytes per file,
+// at the cost of duplicates being detected approximately.
+type StatsCollector struct {
+	stats     *Stats
+	top       fileSizeHeap
+	topN      int
+	dirs      map[string]struct{}
+	nameSet   map[uint64]struct{}
+	duplicate map[string]struct{}
+
+	nonEmptyFiles uint64
+}
+
+func NewStatsCollector(topN int) *StatsCollector {
+	if topN < 0 {
+		topN = 0
+	}
+	return &StatsCollector{
+		stats: &Stats{
+			CompressionMethods: make(map[string]*SizeBreakdown),
+			Extensions:         make(map[string]*SizeBreakdown),
+			Creators:           make(map[string]uint64),
+			DuplicateNames:     make([]string, 0),
+		},
+		topN:      topN,
+		dirs:      make(map[string]struct{}),
+		nameSet:   make(map[uint64]struct{}),
+		duplicate: make(map[string]struct{}),
+	}
+}
+
+func nameHash(name string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return h.Sum64()
+}
+
+func breakdown(m map[string]*SizeBreakdown, key string) *SizeBreakdown {
+	b, ok := m[key]
+	if !ok {
+		b = &SizeBreakdown{}
+		m[key] = b
+	}
+	return b
+}
+
+// Add accounts for a single record
+func (c *StatsCollector) Add(f *zipfile.CDR) {
+	s := c.stats
+	// names are tracked by hash, to keep memory bounded on huge archives: a collision is taken for a duplicate
+	h := nameHash(f.FileName)
+	if _, seen := c.nameSet[h]; seen {
+		if _, reported := c.duplicate[f.FileName]; !reported {
+			c.duplicate[f.FileName] = struct{}{}
+			s.DuplicateNames = append(s.DuplicateNames, f.FileName)
+		}
+	} else {
+		c.nameSet[h] = struct{}{}
+	}
+	if f.Zip64 {
+		s.Zip64Entries++
+	}
+	if f.Encrypted() {
+		s.EncryptedEntries++
+	}
+	creator := zipfile.CreatorOSName(f.CreatorVersion) + " " + zipfile.CreatorSpecVersion(f.CreatorVersion)
+	s.Creators[creator]++
+
+	// directories: explicit entries and implicit parents
+	parts := strings.Split(strings.Trim(f.FileName, "/"), "/")
+	parents := parts[:len(parts)-1]
+	if f.Mode.IsDir() {
+		s.ExplicitDirectories++
+		parents = parts
+	}
+	for i := range parents {
+		c.dirs[strings.Join(parts[:i+1], "/")] = struct{}{}
+	}
+	if f.Mode.IsDir() {
+		return
+	}
+
+	s.MaxDepth = max(s.MaxDepth, len(parts))
+	s.Files++
+	s.CompressedBytes += f.CompressedSizeBytes
+	s.UncompressedBytes += f.UncompressedSizeBytes
+	if f.CompressedSizeBytes > 0 {
+		c.nonEmptyFiles++
+	}
+	breakdown(s.CompressionMethods, zipfile.CompressionMethodName(f.CompressionMethod)).add(f)
+	ext := strings.ToLower(path.Ext(f.FileName))
+	if ext == "" {
+		ext = noExtension
+	}
+	breakdown(s.Extensions, ext).add(f)
+
+	if c.topN > 0 {
+		heap.Push(&c.top, &FileSize{
+			Name:              f.FileName,
+			CompressedBytes:   f.CompressedSizeBytes,
+			UncompressedBytes: f.UncompressedSizeBytes,
+		})
+		if c.top.Len() > c.topN {
+			heap.Pop(&c.top)
+		}
+	}
+}
+
+// Stats returns the statistics of all records added so far.
+// loc, if not nil, provides central directory level information.
+func (c *StatsCollector) Stats(loc *zipfile.CDLocation) *Stats {
+	s := c.stats
+	s.CompressionRatio = compressionRatio(s.CompressedBytes, s.UncompressedBytes)
+	s.Directories = uint64(len(c.dirs))
+	for _, b := range s.CompressionMethods {
+		b.finalize()
+	}
+	for _, b := range s.Extensions {
+		b.finalize()
+	}
+	largest := make([]*FileSize, len(c.top))
+	copy(largest, c.top)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].UncompressedBytes > largest[j].UncompressedBytes
+	})
+	s.Largest = largest
+	s.EstimatedRangeRequests = 2 + c.nonEmptyFiles
+	if loc != nil {
+		s.Zip64 = loc.Zip64
+		s.Comment = string(loc.Comment)
+		s.CentralDirectoryOffset = loc.Offset
+		s.CentralDirectorySize = loc.SizeBytes
+	}
+	return s
+}
+
+// fileSizeHeap is a min-heap of files by uncompressed size, used to keep the N largest files
+type fileSizeHeap []*FileSize
+
+func (h fileSizeHeap) Len() int { return len(h) }
+func (h fileSizeHeap) Less(i, j int) bool {
+	return h[i].UncompressedBytes < h[j].UncompressedBytes
+}
+func (h fileSizeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *fileSizeHeap) Push(x any)   { *h = append(*h, x.(*FileSize)) }
+func (h *fileSizeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,54 @@
+package cloudzip_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+func TestStatsCollector(t *testing.T) {
+	it, err := cloudzip.NewClient().Iterator(context.Background(), regularZip)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	collector := cloudzip.NewStatsCollector(2)
+	for {
+		f, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		collector.Add(f)
+	}
+	// adding a record twice reports it as a duplicate
+	collector.Add(&zipfile.CDR{FileName: "foo/bar.txt", UncompressedSizeBytes: 21, CompressedSizeBytes: 21})
+	stats := collector.Stats(it.Location())
+
+	if stats.Files != 4 {
+		t.Errorf("expected 4 files, got %d", stats.Files)
+	}
+	if stats.Directories != 4 || stats.ExplicitDirectories != 4 {
+		t.Errorf("expected 4 directories (4 explicit), got %d (%d)", stats.Directories, stats.ExplicitDirectories)
+	}
+	if stats.MaxDepth != 4 {
+		t.Errorf("expected max depth 4, got %d", stats.MaxDepth)
+	}
+	if len(stats.Largest) != 2 || stats.Largest[0].Name != "a/b/c/d.txt" {
+		t.Errorf("unexpected largest files: %+v", stats.Largest)
+	}
+	if len(stats.DuplicateNames) != 1 || stats.DuplicateNames[0] != "foo/bar.txt" {
+		t.Errorf("unexpected duplicate names: %v", stats.DuplicateNames)
+	}
+	if b := stats.Extensions[".txt"]; b == nil || b.Files != 4 {
+		t.Errorf("unexpected extension breakdown: %+v", stats.Extensions)
+	}
+	if stats.CentralDirectoryOffset != 518 || stats.EstimatedRangeRequests != 6 {
+		t.Errorf("unexpected central directory stats: offset %d, requests %d",
+			stats.CentralDirectoryOffset, stats.EstimatedRangeRequests)
+	}
+}
@@ -0,0 +1,143 @@
+// Package metrics holds the Prometheus metrics collected by cloudzip's long-running servers (cz http and the
+// mount servers), and the admin handler exposing them along with health checks. Metrics are collected at
+// the layers shared by all front-ends - fetching from remote storage, caching and parsing archives - so they
+// are always recorded, whether or not they are exposed.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cz"
+
+// Cache hit/miss results
+const (
+	Hit  = "hit"
+	Miss = "miss"
+)
+
+var (
+	// Registry holds all cloudzip metrics, along with the Go runtime and process collectors
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by handler, method and status code",
+	}, []string{"handler", "method", "code"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by handler, method and status code",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+	HTTPRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served, by handler",
+	}, []string{"handler"})
+
+	UpstreamFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_fetches_total",
+		Help:      "Range requests made to remote storage, by backend and result (ok, not_found or error)",
+	}, []string{"backend", "result"})
+	UpstreamFetchBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_fetch_bytes_total",
+		Help:      "Bytes read from remote storage, by backend",
+	}, []string{"backend"})
+	UpstreamFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_fetch_duration_seconds",
+		Help:      "Time until remote storage responds to a range request, by backend",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend"})
+	UpstreamFetchesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_fetches_in_flight",
+		Help:      "Range requests to remote storage currently awaiting a response, by backend",
+	}, []string{"backend"})
+
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups, by cache (file: members cached on disk, archive: parsed archives) and result (hit or miss)",
+	}, []string{"cache", "result"})
+
+	CentralDirectoryParseDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "central_directory_parse_duration_seconds",
+		Help:      "Time to fetch and parse the central directory of an archive",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	CentralDirectoryRecords = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "central_directory_records",
+		Help:      "Number of records in parsed central directories",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPRequestDuration, HTTPRequestsInFlight,
+		UpstreamFetches, UpstreamFetchBytes, UpstreamFetchDuration, UpstreamFetchesInFlight,
+		CacheRequests,
+		CentralDirectoryParseDuration, CentralDirectoryRecords,
+	)
+}
+
+// ObserveCache counts a cache lookup
+func ObserveCache(cache string, hit bool) {
+	result := Miss
+	if hit {
+		result = Hit
+	}
+	CacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// InstrumentHandler records the requests served by h under the given handler name
+func InstrumentHandler(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerInFlight(HTTPRequestsInFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(HTTPRequestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(HTTPRequests.MustCurryWith(labels), h)))
+}
+
+// Readiness is set once a server is ready to serve requests
+type Readiness struct {
+	ready atomic.Bool
+}
+
+func (r *Readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+// AdminHandler serves /metrics, /healthz (the process is up) and /readyz (ready is set)
+func AdminHandler(ready *Readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	return mux
+}
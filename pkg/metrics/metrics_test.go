@@ -0,0 +1,52 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+)
+
+func TestAdminHandler(t *testing.T) {
+	ready := &metrics.Readiness{}
+	server := httptest.NewServer(metrics.AdminHandler(ready))
+	defer server.Close()
+	get := func(p string) (int, string) {
+		resp, err := http.Get(server.URL + p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, _ := get("/healthz"); status != http.StatusOK {
+		t.Errorf("/healthz: expected 200, got %d", status)
+	}
+	if status, _ := get("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("/readyz: expected 503 before ready, got %d", status)
+	}
+	ready.SetReady(true)
+	if status, _ := get("/readyz"); status != http.StatusOK {
+		t.Errorf("/readyz: expected 200 once ready, got %d", status)
+	}
+
+	metrics.ObserveCache("file", true)
+	metrics.InstrumentHandler("test", http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	status, body := get("/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("/metrics: expected 200, got %d", status)
+	}
+	for _, expected := range []string{
+		`cz_cache_requests_total{cache="file",result="hit"} 1`,
+		`cz_http_requests_total{code="404",handler="test",method="get"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+}
@@ -30,23 +30,22 @@ func asKey(strs ...string) string {
 }
 
 func getOpenerFor(logger *slog.Logger, zipPath string, record *zipfile.CDR, cache *commonfs.FileCache) commonfs.OpenFn {
-	return func(fullPath string, flag int, perm os.FileMode) (commonfs.FileLike, error) {
+	return func(ctx context.Context, fullPath string, flag int, perm os.FileMode) (commonfs.FileLike, error) {
 		filename := path.Clean(record.FileName)
 		key := asKey(zipPath, filename, strconv.Itoa(int(record.CRC32Uncompressed)))
-		f, err := cache.Get(key)
+		f, err := cache.Get(ctx, key)
 		if errors.Is(err, os.ErrNotExist) {
 			// cache miss!
 			remoteZip, err := remote.Object(zipPath, remote.WithLogger(logger))
 			if err != nil {
 				return nil, err
 			}
-			ctx := context.Background()
 			fetcher := zipfile.NewStorageAdapter(ctx, remoteZip)
 			reader, err := zipfile.ReaderForRecord(record, fetcher)
 			if err != nil {
 				return nil, err
 			}
-			f, err = cache.Set(key, io.NopCloser(reader), int64(record.UncompressedSizeBytes))
+			f, err = cache.Set(ctx, key, io.NopCloser(reader), int64(record.UncompressedSizeBytes))
 			return f, err
 		} else if err != nil {
 			return nil, err
@@ -1,10 +1,16 @@
 package commonfs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 )
 
 type FileCache struct {
@@ -15,12 +21,22 @@ func NewFileCache(dir string) *FileCache {
 	return &FileCache{dir: dir}
 }
 
-func (c *FileCache) Get(key string) (*os.File, error) {
+func (c *FileCache) Get(ctx context.Context, key string) (*os.File, error) {
+	_, span := tracing.Start(ctx, "FileCache.Get", attribute.String("cz.cache.key", key))
 	path := filepath.Join(c.dir, key)
-	return os.Open(path)
+	f, err := os.Open(path)
+	metrics.ObserveCache("file", err == nil)
+	span.SetAttributes(attribute.Bool("cz.cache.hit", err == nil))
+	span.End()
+	return f, err
 }
 
-func (c *FileCache) Set(key string, content io.ReadCloser, expected int64) (*os.File, error) {
+func (c *FileCache) Set(ctx context.Context, key string, content io.ReadCloser, expected int64) (f *os.File, err error) {
+	ctx, span := tracing.Start(ctx, "FileCache.Set",
+		attribute.String("cz.cache.key", key),
+		attribute.Int64("cz.cache.expected_bytes", expected))
+	defer func() { tracing.End(span, err) }()
+
 	path := filepath.Join(c.dir, fmt.Sprintf("%s-w", key))
 	out, err := os.Create(path)
 	if err != nil {
@@ -46,6 +62,5 @@ func (c *FileCache) Set(key string, content io.ReadCloser, expected int64) (*os.
 	if err != nil {
 		return nil, err
 	}
-	f, err := c.Get(key)
-	return f, err
+	return c.Get(ctx, key)
 }
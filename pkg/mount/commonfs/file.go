@@ -1,6 +1,7 @@
 package commonfs
 
 import (
+	"context"
 	"io"
 	"os"
 )
@@ -15,11 +16,11 @@ type FileLike interface {
 }
 
 type Opener interface {
-	Open(fullPath string, flag int, perm os.FileMode) (FileLike, error)
+	Open(ctx context.Context, fullPath string, flag int, perm os.FileMode) (FileLike, error)
 }
 
-type OpenFn func(fullPath string, flag int, perm os.FileMode) (FileLike, error)
+type OpenFn func(ctx context.Context, fullPath string, flag int, perm os.FileMode) (FileLike, error)
 
-func (o OpenFn) Open(fullPath string, flag int, perm os.FileMode) (FileLike, error) {
-	return o(fullPath, flag, perm)
+func (o OpenFn) Open(ctx context.Context, fullPath string, flag int, perm os.FileMode) (FileLike, error) {
+	return o(ctx, fullPath, flag, perm)
 }
@@ -87,8 +87,8 @@ func (f *FileInfo) Name() string {
 	return f.currentName
 }
 
-func (f *FileInfo) Open(flag int, perm os.FileMode) (FileLike, error) {
-	return f.opener.Open(f.name, flag, perm)
+func (f *FileInfo) Open(ctx context.Context, flag int, perm os.FileMode) (FileLike, error) {
+	return f.opener.Open(ctx, f.name, flag, perm)
 }
 
 func (f *FileInfo) Size() int64 {
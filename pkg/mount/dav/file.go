@@ -1,6 +1,7 @@
 package dav
 
 import (
+	"context"
 	"io/fs"
 
 	"golang.org/x/net/webdav"
@@ -11,6 +12,8 @@ import (
 var _ webdav.File = &treeFile{}
 
 type treeFile struct {
+	// ctx is the context of the request that opened the file
+	ctx  context.Context
 	tree commonfs.Tree
 	fi   *commonfs.FileInfo
 
@@ -32,7 +35,7 @@ func (f *treeFile) Read(p []byte) (n int, err error) {
 		return 0, nil
 	}
 	if f.handle == nil {
-		f.handle, err = f.fi.Open(0, 0755)
+		f.handle, err = f.fi.Open(f.ctx, 0, 0755)
 		if err != nil {
 			return
 		}
@@ -43,7 +46,7 @@ func (f *treeFile) Read(p []byte) (n int, err error) {
 func (f *treeFile) Seek(offset int64, whence int) (int64, error) {
 	var err error
 	if f.handle == nil {
-		f.handle, err = f.fi.Open(0, 0755)
+		f.handle, err = f.fi.Open(f.ctx, 0, 0755)
 		if err != nil {
 			return 0, err
 		}
@@ -73,7 +76,7 @@ func (f *treeFile) Stat() (fs.FileInfo, error) {
 
 func (f *treeFile) Write(p []byte) (n int, err error) {
 	if f.handle == nil {
-		f.handle, err = f.fi.Open(0, 0755)
+		f.handle, err = f.fi.Open(f.ctx, 0, 0755)
 		if err != nil {
 			return
 		}
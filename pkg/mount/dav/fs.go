@@ -29,6 +29,7 @@ func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.Fi
 		return nil, err
 	}
 	return &treeFile{
+		ctx:  ctx,
 		tree: fs.tree,
 		fi:   f,
 	}, nil
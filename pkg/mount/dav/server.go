@@ -7,7 +7,9 @@ import (
 
 	"golang.org/x/net/webdav"
 
+	"github.com/ozkatz/cloudzip/pkg/metrics"
 	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 )
 
 func newHandler(fs webdav.FileSystem, prefix string) http.Handler {
@@ -26,6 +28,6 @@ func Serve(listener net.Listener, tree commonfs.Tree, logger *slog.Logger) error
 			next:   h,
 		}
 	}
-	server := &http.Server{Handler: h}
+	server := &http.Server{Handler: metrics.InstrumentHandler("webdav", tracing.Handler("webdav", h))}
 	return server.Serve(listener)
 }
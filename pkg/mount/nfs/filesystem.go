@@ -1,20 +1,26 @@
 package nfs
 
 import (
+	"context"
 	"os"
 	"path"
 
 	"github.com/go-git/go-billy/v5"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 )
 
+// ZipFS serves a tree over NFS. Billy filesystems aren't passed a context per call,
+// so operations are traced as children of the server's context.
 type ZipFS struct {
 	Tree commonfs.Tree
+	ctx  context.Context
 }
 
-func NewZipFS(tree commonfs.Tree) billy.Filesystem {
-	return &ZipFS{Tree: tree}
+func NewZipFS(ctx context.Context, tree commonfs.Tree) billy.Filesystem {
+	return &ZipFS{Tree: tree, ctx: ctx}
 }
 
 func (fs *ZipFS) Create(filename string) (billy.File, error) {
@@ -25,7 +31,10 @@ func (fs *ZipFS) Open(filename string) (billy.File, error) {
 	return fs.OpenFile(filename, os.O_RDONLY, 0)
 }
 
-func (fs *ZipFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+func (fs *ZipFS) OpenFile(filename string, flag int, perm os.FileMode) (_ billy.File, err error) {
+	ctx, span := tracing.Start(fs.ctx, "nfs.OpenFile", attribute.String("cz.path", filename))
+	defer func() { tracing.End(span, err) }()
+
 	s, err := fs.Tree.Stat(filename)
 	if err != nil {
 		return nil, err
@@ -33,14 +42,17 @@ func (fs *ZipFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.Fi
 	if s.IsDir() {
 		return nil, billy.ErrNotSupported
 	}
-	f, err := s.Open(flag, perm)
+	f, err := s.Open(ctx, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 	return fileLikeToBilly(f, filename), nil
 }
 
-func (fs *ZipFS) Stat(filename string) (os.FileInfo, error) {
+func (fs *ZipFS) Stat(filename string) (_ os.FileInfo, err error) {
+	_, span := tracing.Start(fs.ctx, "nfs.Stat", attribute.String("cz.path", filename))
+	defer func() { tracing.End(span, err) }()
+
 	info, err := fs.Tree.Stat(filename)
 	if err != nil {
 		return nil, err
@@ -65,7 +77,10 @@ func (fs *ZipFS) TempFile(dir, prefix string) (billy.File, error) {
 	return nil, billy.ErrReadOnly
 }
 
-func (fs *ZipFS) ReadDir(name string) ([]os.FileInfo, error) {
+func (fs *ZipFS) ReadDir(name string) (_ []os.FileInfo, err error) {
+	_, span := tracing.Start(fs.ctx, "nfs.ReadDir", attribute.String("cz.path", name))
+	defer func() { tracing.End(span, err) }()
+
 	dir, err := fs.Tree.Readdir(name)
 	if err != nil {
 		return nil, err
@@ -31,7 +31,7 @@ var DefaultOptions = &Options{
 }
 
 func NewHandler(ctx context.Context, tree commonfs.Tree, opts *Options) nfs.Handler {
-	zipFs := NewZipFS(tree)
+	zipFs := NewZipFS(ctx, tree)
 	if opts == nil {
 		opts = DefaultOptions
 	}
@@ -2,6 +2,7 @@ package procfs
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"time"
 
@@ -46,7 +47,7 @@ func (f *InMemFile) Size() int64 {
 
 func NewProcFile(path string, content []byte, modTime time.Time) *commonfs.FileInfo {
 	f := &InMemFile{bytes.NewReader(content)}
-	opener := func(fullPath string, flag int, perm os.FileMode) (commonfs.FileLike, error) {
+	opener := func(ctx context.Context, fullPath string, flag int, perm os.FileMode) (commonfs.FileLike, error) {
 		return f, nil
 	}
 	return commonfs.ImmutableInfo(path, modTime, ProcFileMode, f.Size(), commonfs.OpenFn(opener))
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AccessRule matches archives by the glob pattern of their path (relative to the served root),
+// and optionally their members by another glob pattern. Patterns use the syntax of path.Match,
+// and also match anything residing in a matching directory. As in .gitignore, a pattern without
+// a slash matches names at any depth: "*.zip" matches "a/b.zip", while "/*.zip" doesn't.
+type AccessRule struct {
+	Archive string
+	// Member is empty for rules applying to all members of matching archives
+	Member string
+}
+
+// ParseAccessRule parses a rule in the form "archive-glob" or "archive-glob:member-glob",
+// e.g. "datasets/*.zip" or "*.zip:private/*"
+func ParseAccessRule(s string) (*AccessRule, error) {
+	archive, member, _ := strings.Cut(s, ":")
+	rule := &AccessRule{
+		Archive: anchorPattern(archive),
+		Member:  anchorPattern(member),
+	}
+	if strings.Trim(rule.Archive, "/") == "" {
+		return nil, fmt.Errorf("invalid access rule: '%s': missing archive pattern", s)
+	}
+	for _, pattern := range []string{rule.Archive, rule.Member} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid access rule: '%s': %w", s, err)
+		}
+	}
+	return rule, nil
+}
+
+// anchorPattern makes patterns containing a slash relative to the root, as matched against by matchesPath
+func anchorPattern(pattern string) string {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if strings.Contains(pattern, "/") && !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	return pattern
+}
+
+// absolutePath returns name relative to the root, with a leading slash ("" for the root itself)
+func absolutePath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return ""
+	}
+	return "/" + name
+}
+
+// matchesPath returns true if name (an absolute path), or any of its parent directories, matches pattern
+func matchesPath(pattern, name string) bool {
+	anyDepth := !strings.Contains(pattern, "/")
+	for ; name != "." && name != "/" && name != ""; name = path.Dir(name) {
+		target := name
+		if anyDepth {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true if the rule applies to member (or the archive's root, if member is "" or ".")
+func (r *AccessRule) Matches(archivePath, member string) bool {
+	if !matchesPath(r.Archive, absolutePath(archivePath)) {
+		return false
+	}
+	return r.Member == "" || matchesPath(r.Member, absolutePath(member))
+}
+
+// AccessList decides which archives and members may be served: a path must not match any Deny rule,
+// and if there are Allow rules, must match at least one of them.
+type AccessList struct {
+	Allow []*AccessRule
+	Deny  []*AccessRule
+}
+
+// Allowed returns true if member of the archive at archivePath may be served
+func (l *AccessList) Allowed(archivePath, member string) bool {
+	if l == nil {
+		return true
+	}
+	for _, rule := range l.Deny {
+		if rule.Matches(archivePath, member) {
+			return false
+		}
+	}
+	if len(l.Allow) == 0 {
+		return true
+	}
+	for _, rule := range l.Allow {
+		if rule.Matches(archivePath, member) {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectoryAllowed returns false if everything under the directory at dirPath is denied.
+// With Allow rules, a directory may hold allowed archives at any depth, so it is only checked against Deny rules.
+func (l *AccessList) DirectoryAllowed(dirPath string) bool {
+	if l == nil {
+		return true
+	}
+	for _, rule := range l.Deny {
+		if rule.Member == "" && matchesPath(rule.Archive, absolutePath(dirPath)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package proxy_test
+
+import (
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+)
+
+func TestAccessList_Allowed(t *testing.T) {
+	rules := func(values ...string) []*proxy.AccessRule {
+		parsed := make([]*proxy.AccessRule, len(values))
+		for i, value := range values {
+			rule, err := proxy.ParseAccessRule(value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			parsed[i] = rule
+		}
+		return parsed
+	}
+	cases := []struct {
+		Name     string
+		Allow    []string
+		Deny     []string
+		Archive  string
+		Member   string
+		Expected bool
+	}{
+		{"no rules", nil, nil, "/a/b.zip", "c.txt", true},
+		{"allowed archive", []string{"public/*.zip"}, nil, "/public/b.zip", "c.txt", true},
+		{"archive not allowed", []string{"public/*.zip"}, nil, "/private/b.zip", "c.txt", false},
+		{"allowed directory", []string{"public"}, nil, "/x/public/y/b.zip", "c.txt", true},
+		{"anchored", []string{"/public"}, nil, "/x/public/b.zip", "c.txt", false},
+		{"denied at any depth", nil, []string{"*.zip:secrets"}, "/a/b.zip", "x/secrets/key", false},
+		{"other members", nil, []string{"*.zip:secrets"}, "/a/b.zip", "x/public/key", true},
+		{"member rule spares the root", nil, []string{"*.zip:secrets"}, "/a/b.zip", ".", true},
+		{"anchored member", nil, []string{"*.zip:x/secrets"}, "/a/b.zip", "y/x/secrets/key", true},
+		{"deny wins", []string{"*.zip"}, []string{"b.zip:*.key"}, "/a/b.zip", "d/e.key", false},
+		{"allowed member", []string{"*.zip:*.csv"}, nil, "/a/b.zip", "d/e.csv", true},
+		{"member not allowed", []string{"*.zip:*.csv"}, nil, "/a/b.zip", "d/e.json", false},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			list := &proxy.AccessList{Allow: rules(cas.Allow...), Deny: rules(cas.Deny...)}
+			if got := list.Allowed(cas.Archive, cas.Member); got != cas.Expected {
+				t.Errorf("expected %v, got %v", cas.Expected, got)
+			}
+		})
+	}
+}
+
+func TestParseAccessRule(t *testing.T) {
+	for _, value := range []string{"", ":a", "[.zip", "a.zip:[b"} {
+		if _, err := proxy.ParseAccessRule(value); err == nil {
+			t.Errorf("expected an error parsing '%s'", value)
+		}
+	}
+}
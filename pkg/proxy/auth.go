@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ExpiresParam and SignatureParam are the query arguments of signed URLs
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+
+	authRealm = "cz http"
+)
+
+var (
+	ErrSignatureExpired = errors.New("signature expired")
+	ErrSignatureInvalid = errors.New("invalid signature")
+)
+
+// signedResource returns what a signature covers: the request path (without a trailing slash,
+// so that signing an archive also covers the redirect to its root) and, if given, the member
+// requested using ?filename=
+func signedResource(p, filename string) string {
+	resource := strings.TrimSuffix(p, "/")
+	if filename != "" {
+		resource += "?filename=" + filename
+	}
+	return resource
+}
+
+func signature(key []byte, resource string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(resource + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPath returns the request path p (e.g. /a/b.zip/c.txt) along with the query arguments granting
+// access to it until expires, without any other credentials. A signature grants access to a single path:
+// it doesn't cover other members of the archive, or the members of a signed directory.
+func SignPath(key []byte, p string, expires time.Time) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	query := url.Values{}
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(SignatureParam, signature(key, signedResource(p, ""), expires.Unix()))
+	return (&url.URL{Path: p, RawQuery: query.Encode()}).String()
+}
+
+// VerifySignature checks the signature of a request for a signed URL
+func VerifySignature(key []byte, r *http.Request, now time.Time) error {
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	expected := signature(key, signedResource(r.URL.Path, query.Get("filename")), expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(SignatureParam))) {
+		return ErrSignatureInvalid
+	}
+	if now.Unix() > expires {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// authEnabled returns true if requests must be authenticated
+func (h *Handler) authEnabled() bool {
+	return len(h.tokens) > 0 || len(h.basicAuth) > 0 || len(h.signingKey) > 0
+}
+
+// authenticate returns true if the request carries a valid bearer token, basic auth credentials
+// or URL signature. Every request is authenticated if no credentials are configured.
+func (h *Handler) authenticate(r *http.Request) bool {
+	if !h.authEnabled() {
+		return true
+	}
+	if len(h.signingKey) > 0 && r.URL.Query().Has(SignatureParam) {
+		return VerifySignature(h.signingKey, r, time.Now()) == nil
+	}
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		for _, expected := range h.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		expected, exists := h.basicAuth[user]
+		// compare anyway, so unknown users take as long as wrong passwords
+		match := subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+		return exists && match
+	}
+	return false
+}
+
+// writeUnauthorized asks the client for the credentials it may use
+func (h *Handler) writeUnauthorized(w http.ResponseWriter) {
+	if len(h.basicAuth) > 0 {
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+	}
+	if len(h.tokens) > 0 {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+	"github.com/ozkatz/cloudzip/pkg/zipfs"
+)
+
+const (
+	// DefaultCacheTTL is how long an opened archive is served before checking whether it was replaced
+	DefaultCacheTTL = time.Minute
+	// DefaultCacheSize is the approximate memory used by opened archives before the least recently used are dropped
+	DefaultCacheSize = 256 << 20
+
+	// recordOverhead approximates the memory used per member, besides its name, extra fields and comment:
+	// its record, its entries in the name index and the directory tree
+	recordOverhead = 512
+
+	// archiveCacheName labels the cache's metrics
+	archiveCacheName = "archive"
+)
+
+// cachedArchive is an archive whose central directory was read and indexed
+type cachedArchive struct {
+	uri       string
+	etag      string
+	archive   *cloudzip.Archive
+	fsys      *zipfs.FS
+	size      int64
+	validated time.Time
+
+	filesOnce sync.Once
+	files     []*zipfile.CDR
+}
+
+// open returns the archive reading members with ctx, pinned to the ETag its central directory was read at:
+// reading a member of an archive replaced since then fails with remote.ErrPreconditionFailed (where the storage
+// can tell), rather than reading the new archive at the offsets of the old one
+func (c *cachedArchive) open(ctx context.Context) *cloudzip.Archive {
+	if c.etag != "" {
+		ctx = remote.ContextWithIfMatch(ctx, c.etag)
+	}
+	return c.archive.WithContext(ctx)
+}
+
+// sortedFiles returns the records of the archive's files (not directories), ordered by name
+func (c *cachedArchive) sortedFiles() []*zipfile.CDR {
+	c.filesOnce.Do(func() {
+		for _, record := range c.archive.Records() {
+			if !record.Mode.IsDir() {
+				c.files = append(c.files, record)
+			}
+		}
+		sort.Slice(c.files, func(i, j int) bool {
+			return c.files[i].FileName < c.files[j].FileName
+		})
+	})
+	return c.files
+}
+
+// loadCall is an in-progress load of an archive, shared by all requests for it
+type loadCall struct {
+	done  chan struct{}
+	entry *cachedArchive
+	err   error
+}
+
+// archiveCache is an LRU of opened archives, keyed by URI and revalidated against the archive's ETag
+// once its TTL has passed. Concurrent requests for an archive share a single load.
+// A non-positive maxBytes disables caching: every request reads the central directory.
+type archiveCache struct {
+	client   *cloudzip.Client
+	ttl      time.Duration
+	maxBytes int64
+
+	l        *sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List
+	size     int64
+	inflight map[string]*loadCall
+}
+
+func newArchiveCache(client *cloudzip.Client, ttl time.Duration, maxBytes int64) *archiveCache {
+	return &archiveCache{
+		client:   client,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		l:        &sync.Mutex{},
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*loadCall),
+	}
+}
+
+// get returns the archive at uri, from the cache if it is fresh or unchanged since it was opened
+func (c *archiveCache) get(ctx context.Context, uri string) (*cachedArchive, error) {
+	c.l.Lock()
+	if e, ok := c.entries[uri]; ok {
+		entry := e.Value.(*cachedArchive)
+		if time.Since(entry.validated) < c.ttl {
+			c.order.MoveToFront(e)
+			c.l.Unlock()
+			metrics.ObserveCache(archiveCacheName, true)
+			return entry, nil
+		}
+	}
+	call, ok := c.inflight[uri]
+	if !ok {
+		call = &loadCall{done: make(chan struct{})}
+		c.inflight[uri] = call
+		go func() {
+			// loads outlive the request that started them, as other requests may be waiting for them
+			call.entry, call.err = c.load(context.WithoutCancel(ctx), uri)
+			c.l.Lock()
+			delete(c.inflight, uri)
+			c.l.Unlock()
+			close(call.done)
+		}()
+	}
+	c.l.Unlock()
+	select {
+	case <-call.done:
+		return call.entry, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load revalidates the cached archive at uri, opening it again if it was replaced
+func (c *archiveCache) load(ctx context.Context, uri string) (*cachedArchive, error) {
+	etag, err := c.etag(ctx, uri)
+	if err != nil {
+		if errors.Is(err, remote.ErrDoesNotExist) {
+			c.remove(uri)
+		}
+		return nil, err
+	}
+	c.l.Lock()
+	if e, ok := c.entries[uri]; ok {
+		entry := e.Value.(*cachedArchive)
+		if etag != "" && entry.etag == etag {
+			entry.validated = time.Now()
+			c.order.MoveToFront(e)
+			c.l.Unlock()
+			metrics.ObserveCache(archiveCacheName, true)
+			return entry, nil
+		}
+	}
+	c.l.Unlock()
+
+	metrics.ObserveCache(archiveCacheName, false)
+	archive, err := c.client.Open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := archive.FS()
+	if err != nil {
+		return nil, err
+	}
+	entry := &cachedArchive{
+		uri:       uri,
+		etag:      etag,
+		archive:   archive,
+		fsys:      fsys,
+		size:      archiveSize(archive),
+		validated: time.Now(),
+	}
+	c.put(entry)
+	return entry, nil
+}
+
+// etag returns the ETag of the archive at uri, or "" if it can't be determined
+func (c *archiveCache) etag(ctx context.Context, uri string) (string, error) {
+	f, err := c.client.Fetcher(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	info, err := remote.Stat(ctx, f)
+	if errors.Is(err, remote.ErrDoesNotExist) || errors.Is(err, remote.ErrTooManyFetches) {
+		return "", err
+	} else if err != nil {
+		// serve the archive anyway, without an ETag
+		slog.DebugContext(ctx, "could not stat zip file", "error", err, "uri", uri)
+		return "", nil
+	}
+	return info.ETag, nil
+}
+
+func (c *archiveCache) put(entry *cachedArchive) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[entry.uri]; ok {
+		c.size -= e.Value.(*cachedArchive).size
+		c.order.Remove(e)
+		delete(c.entries, entry.uri)
+	}
+	if c.maxBytes <= 0 || entry.size > c.maxBytes {
+		return
+	}
+	c.entries[entry.uri] = c.order.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		evicted := oldest.Value.(*cachedArchive)
+		delete(c.entries, evicted.uri)
+		c.size -= evicted.size
+	}
+}
+
+// evict removes entry once reading from it failed because the archive was replaced (unless it was reloaded
+// already), so that the next request reads the archive again
+func (c *archiveCache) evict(entry *cachedArchive) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[entry.uri]; ok && e.Value.(*cachedArchive) == entry {
+		c.size -= entry.size
+		c.order.Remove(e)
+		delete(c.entries, entry.uri)
+	}
+}
+
+// pinnedContent reads a member of a cached archive, evicting the archive if it was replaced
+type pinnedContent struct {
+	io.ReadSeekCloser
+	cache *archiveCache
+	entry *cachedArchive
+}
+
+func (p *pinnedContent) Read(b []byte) (int, error) {
+	n, err := p.ReadSeekCloser.Read(b)
+	if errors.Is(err, remote.ErrPreconditionFailed) {
+		slog.Warn("zip file replaced while cached, evicting it", "uri", p.entry.uri)
+		p.cache.evict(p.entry)
+	}
+	return n, err
+}
+
+// pin wraps content, read from entry.open, to evict entry once reading it shows the archive was replaced
+func (c *archiveCache) pin(entry *cachedArchive, content io.ReadSeekCloser) io.ReadSeekCloser {
+	return &pinnedContent{ReadSeekCloser: content, cache: c, entry: entry}
+}
+
+func (c *archiveCache) remove(uri string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[uri]; ok {
+		c.size -= e.Value.(*cachedArchive).size
+		c.order.Remove(e)
+		delete(c.entries, uri)
+	}
+}
+
+// archiveSize approximates the memory used by an opened archive
+func archiveSize(archive *cloudzip.Archive) int64 {
+	var size int64
+	for _, record := range archive.Records() {
+		size += recordOverhead + 2*int64(len(record.FileName)) + int64(len(record.ExtraFields)) + int64(len(record.FileComment))
+	}
+	return size
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const encodingGzip = "gzip"
+
+// acceptsEncoding returns true if the Accept-Encoding header value allows coding, i.e. lists it
+// (or "*") without a q-value of 0
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	accepted := false
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != coding && name != "*" {
+			continue
+		}
+		q := 1.0
+		if key, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(key) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == coding {
+			// an explicit entry takes precedence over "*"
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
+
+// passthroughEncoding returns the Content-Encoding with which the compressed bytes of record can be sent
+// as-is, or "" if the member must be decompressed: only deflated members qualify, and range requests are
+// served decompressed, so that ranges refer to the member's content.
+// HTTP's deflate coding is zlib-wrapped, which would require an Adler-32 checksum of the content: the raw
+// deflate stream is only sent gzip-wrapped, using the CRC stored in the archive.
+func passthroughEncoding(r *http.Request, record *zipfile.CDR) string {
+	if record.CompressionMethod != zip.Deflate || record.Encrypted() || r.Header.Get("Range") != "" {
+		return ""
+	}
+	if acceptsEncoding(r.Header.Get("Accept-Encoding"), encodingGzip) {
+		return encodingGzip
+	}
+	return ""
+}
+
+// encodedETag derives the ETag of an encoded representation from the ETag of the member
+func encodedETag(etag, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// gzipWrapper returns the header and trailer that make the raw deflate stream of record a gzip stream,
+// using the CRC and size stored in the central directory
+func gzipWrapper(record *zipfile.CDR) ([]byte, []byte) {
+	// magic, CM=deflate, no flags, no mtime, no extra flags, OS=unknown
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[0:4], record.CRC32Uncompressed)
+	binary.LittleEndian.PutUint32(trailer[4:8], uint32(record.UncompressedSizeBytes))
+	return header, trailer
+}
+
+// openEncoded returns a seeker over the compressed bytes of record, wrapped according to encoding
+func openEncoded(archive *cloudzip.Archive, record *zipfile.CDR, encoding string) io.ReadSeekCloser {
+	var header, trailer []byte
+	if encoding == encodingGzip {
+		header, trailer = gzipWrapper(record)
+	}
+	return &encodedSeeker{
+		archive: archive,
+		record:  record,
+		header:  header,
+		trailer: trailer,
+		size:    int64(len(header)) + int64(record.CompressedSizeBytes) + int64(len(trailer)),
+	}
+}
+
+// encodedSeeker reads the compressed bytes of a member, between a header and a trailer.
+// Nothing is read until the first call to Read; reading from an offset other than the start
+// reads (and discards) the bytes before it.
+type encodedSeeker struct {
+	archive *cloudzip.Archive
+	record  *zipfile.CDR
+	header  []byte
+	trailer []byte
+	size    int64
+	offset  int64
+	r       io.Reader
+	raw     io.ReadCloser
+}
+
+func (e *encodedSeeker) Read(p []byte) (int, error) {
+	if e.r == nil {
+		raw, err := e.archive.OpenRaw(e.record)
+		if err != nil {
+			return 0, err
+		}
+		e.raw = raw
+		e.r = io.MultiReader(bytes.NewReader(e.header), raw, bytes.NewReader(e.trailer))
+		if _, err := io.CopyN(io.Discard, e.r, e.offset); err != nil {
+			return 0, err
+		}
+	}
+	n, err := e.r.Read(p)
+	e.offset += int64(n)
+	return n, err
+}
+
+func (e *encodedSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.offset
+	case io.SeekEnd:
+		offset += e.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if offset != e.offset && e.r != nil {
+		_ = e.Close()
+		e.r, e.raw = nil, nil
+	}
+	e.offset = offset
+	return offset, nil
+}
+
+func (e *encodedSeeker) Close() error {
+	if e.raw == nil {
+		return nil
+	}
+	return e.raw.Close()
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ListingEntry is a single entry of a directory listing
+type ListingEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Listing is the JSON representation of a directory
+type Listing struct {
+	Path    string          `json:"path"`
+	Entries []*ListingEntry `json:"entries"`
+	// Parent is set for directories that can link to their parent: those within the archive,
+	// and when browsing, the archive root and directories below the served root
+	Parent bool `json:"-"`
+	// Next is set when only part of a directory above archives was listed: ?after=<Next> lists the rest
+	Next string `json:"next,omitempty"`
+}
+
+// Href returns a link to the entry, relative to its directory
+func (e *ListingEntry) Href() string {
+	// "./" keeps names such as "a:b" from being parsed as a URL scheme
+	href := "./" + url.PathEscape(e.Name)
+	if e.Dir {
+		href += "/"
+	}
+	return href
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{ .Path }}</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 0 1em 0 0; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of {{ .Path }}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if .Parent }}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end }}
+{{- range .Entries }}
+<tr><td><a href="{{ .Href }}">{{ .Name }}{{ if .Dir }}/{{ end }}</a></td><td class="size">{{ if not .Dir }}{{ .Size }}{{ end }}</td><td>{{ if not .Modified.IsZero }}{{ .Modified.UTC.Format "2006-01-02 15:04:05" }}{{ end }}</td></tr>
+{{- end }}
+</table>
+{{- if .Next }}
+<p><a href="?after={{ .Next }}">Next page</a></p>
+{{- end }}
+</body>
+</html>
+`))
+
+// wantsJSON returns true if the client asked for a JSON listing
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// listingEntries returns the listing entries for the entries of a directory in an archive
+func listingEntries(entries []fs.DirEntry) []*ListingEntry {
+	listingEntries := make([]*ListingEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		listingEntries = append(listingEntries, &ListingEntry{
+			Name:     entry.Name(),
+			Dir:      entry.IsDir(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	return listingEntries
+}
+
+// writeListing writes a listing of a directory's entries, as JSON or HTML
+func writeListing(w http.ResponseWriter, r *http.Request, listing *Listing) {
+	w.Header().Add("Vary", "Accept")
+	var err error
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(listing)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = listingTemplate.Execute(w, listing)
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "error writing listing", "error", err, "path", listing.Path)
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Access log formats supported by AccessLog
+const (
+	AccessLogJSON   = "json"
+	AccessLogCommon = "clf"
+)
+
+// responseRecorder captures the status code and the number of bytes written by a handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// clientAddress returns the IP address of the client making r
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AccessLog writes a line to out for every request served by h, in the given format:
+// AccessLogJSON (a JSON object per request) or AccessLogCommon (the Common Log Format)
+func AccessLog(h http.Handler, out io.Writer, format string) (http.Handler, error) {
+	var write func(r *http.Request, rec *responseRecorder, start time.Time)
+	switch format {
+	case AccessLogJSON:
+		logger := slog.New(slog.NewJSONHandler(out, nil))
+		write = func(r *http.Request, rec *responseRecorder, start time.Time) {
+			logger.Info("request",
+				"remote_addr", clientAddress(r),
+				"method", r.Method,
+				"uri", r.RequestURI,
+				"proto", r.Proto,
+				"status", rec.status,
+				"bytes", rec.bytes,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"user_agent", r.UserAgent(),
+				"referer", r.Referer())
+		}
+	case AccessLogCommon:
+		var l sync.Mutex
+		write = func(r *http.Request, rec *responseRecorder, start time.Time) {
+			user := "-"
+			if u, _, ok := r.BasicAuth(); ok && u != "" {
+				user = u
+			}
+			size := "-"
+			if rec.bytes > 0 {
+				size = strconv.FormatInt(rec.bytes, 10)
+			}
+			l.Lock()
+			defer l.Unlock()
+			_, _ = fmt.Fprintf(out, "%s - %s [%s] %q %d %s\n", clientAddress(r), user,
+				start.Format("02/Jan/2006:15:04:05 -0700"), r.Method+" "+r.RequestURI+" "+r.Proto, rec.status, size)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported access log format: '%s' (use %s or %s)", format, AccessLogJSON, AccessLogCommon)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		write(r, rec, start)
+	}), nil
+}
+
+// RequestTimeout cancels the work done for requests served by h (including streaming the response)
+// once they have taken longer than timeout
+func RequestTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenBucket holds up to burst tokens, refilled at a fixed rate
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per client address
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	l         sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// rateLimiterSweepInterval is how often buckets that have refilled (of clients gone idle) are dropped
+const rateLimiterSweepInterval = time.Minute
+
+// take takes a token from the bucket of client, returning how long to wait for one if there is none
+func (l *rateLimiter) take(client string, now time.Time) (time.Duration, bool) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if now.Sub(l.lastSweep) > rateLimiterSweepInterval {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// RateLimit limits each client (by IP address) to rate requests per second to h, allowing bursts of up to
+// burst requests. Requests over the limit are rejected with 429 Too Many Requests.
+func RateLimit(h http.Handler, rate float64, burst int) http.Handler {
+	limiter := &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait, ok := limiter.take(clientAddress(r), time.Now()); !ok {
+			slog.DebugContext(r.Context(), "rate limited", "client", clientAddress(r), "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
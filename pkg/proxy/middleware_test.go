@@ -0,0 +1,130 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("hello"))
+})
+
+func TestAccessLog(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		h, err := proxy.AccessLog(okHandler, out, proxy.AccessLogJSON)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/a.zip/b.txt?x=1", nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		var line map[string]any
+		if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+			t.Fatalf("expected a JSON line, got %s", out.String())
+		}
+		if line["uri"] != "/a.zip/b.txt?x=1" || line["status"] != float64(http.StatusOK) || line["bytes"] != float64(5) || line["remote_addr"] != "192.0.2.1" {
+			t.Errorf("unexpected log line: %s", out.String())
+		}
+	})
+	t.Run("clf", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		h, err := proxy.AccessLog(http.NotFoundHandler(), out, proxy.AccessLogCommon)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		req.SetBasicAuth("alice", "secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		expected := regexp.MustCompile(`^192\.0\.2\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /missing HTTP/1\.1" 404 \d+\n$`)
+		if !expected.MatchString(out.String()) {
+			t.Errorf("unexpected log line: %s", out.String())
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := proxy.AccessLog(okHandler, &bytes.Buffer{}, "xml"); err == nil {
+			t.Errorf("expected an error for an unsupported format")
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	h := proxy.RateLimit(okHandler, 0.5, 2)
+	statuses := func(remoteAddr string, n int) []int {
+		var statuses []int
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			statuses = append(statuses, w.Code)
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+				t.Errorf("expected Retry-After: 2, got %s", w.Header().Get("Retry-After"))
+			}
+		}
+		return statuses
+	}
+	if got := statuses("192.0.2.1:1234", 3); got[0] != http.StatusOK || got[1] != http.StatusOK || got[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the burst to be allowed and the next request limited, got %v", got)
+	}
+	// clients are limited separately, regardless of their port
+	if got := statuses("192.0.2.2:1234", 1); got[0] != http.StatusOK {
+		t.Errorf("expected another client not to be limited, got %v", got)
+	}
+	if got := statuses("192.0.2.1:5678", 1); got[0] != http.StatusTooManyRequests {
+		t.Errorf("expected the same client on another port to be limited, got %v", got)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	h := proxy.RequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}), 10*time.Millisecond)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected the request's context to time out, got %d", w.Code)
+	}
+}
+
+func TestHandler_TooManyFetches(t *testing.T) {
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "archive.zip"), content)
+	limiter := remote.NewFetchLimiter(1, 10*time.Millisecond)
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(limiter.Wrap))
+	server := httptest.NewServer(proxy.NewHandler(client, "file://"+dir))
+	t.Cleanup(server.Close)
+
+	// hold the only slot
+	f, err := remote.NewLocalFetcher("file://" + filepath.Join(dir, "archive.zip"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	held, err := limiter.Wrap(f).Fetch(context.Background(), nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, _ := do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After while upstream fetches are limited, got %d", resp.StatusCode)
+	}
+	_ = held.Close()
+	resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Errorf("expected the member to be served once a slot is released, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,503 @@
+// Package proxy serves the members of remote zip archives over HTTP, as used by `cz http`,
+// and through a read-only S3-compatible API, as used by `cz s3-gateway` (see S3Handler).
+// Responses support HEAD, (multi-)range and conditional requests, so members can be seeked
+// by media players, downloads can be resumed and clients can revalidate cached copies.
+package proxy
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+// directoryPageSize is the most entries listed at once when browsing the paths above archives
+const directoryPageSize = 1000
+
+// Handler serves members of the archives under root: a request for /path/to/archive.zip/member
+// (or /path/to/archive.zip?filename=member) returns the uncompressed content of member in the archive
+// at root + "/path/to/archive.zip". Requests for directories in the archive return a listing, as HTML
+// or (with ?format=json or "Accept: application/json") as JSON, unless the directory has an index.html.
+// Opened archives are kept in memory and shared across requests, so serving a member of a recently used
+// archive costs a single range request.
+// With WithBrowsing, the paths above archives are listed too, showing the archives under root as directories,
+// so the archives of a whole prefix can be browsed, a page at a time (?after=<last entry> lists the next page).
+// Archives are opened only once one of their paths is requested.
+type Handler struct {
+	client     *cloudzip.Client
+	root       string
+	archives   *archiveCache
+	tokens     []string
+	basicAuth  map[string]string
+	signingKey []byte
+	access     *AccessList
+	browse     bool
+}
+
+type Option func(o *handlerOptions)
+
+type handlerOptions struct {
+	cacheTTL   time.Duration
+	cacheSize  int64
+	tokens     []string
+	basicAuth  map[string]string
+	signingKey []byte
+	access     *AccessList
+	browse     bool
+	// credentials are the S3 gateway's secret access keys, by access key ID
+	credentials map[string]string
+}
+
+// WithCacheTTL sets how long an opened archive is served before its ETag is checked to see whether
+// it was replaced. A TTL of 0 checks on every request, which still saves reading the central directory.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *handlerOptions) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithCacheSize limits the approximate memory used by opened archives. A size of 0 disables caching.
+func WithCacheSize(size int64) Option {
+	return func(o *handlerOptions) {
+		o.cacheSize = size
+	}
+}
+
+// WithBearerTokens requires requests to carry one of the given tokens in an "Authorization: Bearer" header
+// (unless they are otherwise authenticated)
+func WithBearerTokens(tokens ...string) Option {
+	return func(o *handlerOptions) {
+		o.tokens = append(o.tokens, tokens...)
+	}
+}
+
+// WithBasicAuth requires requests to carry the given credentials using basic authentication
+// (unless they are otherwise authenticated). It may be used more than once to allow several users.
+func WithBasicAuth(user, password string) Option {
+	return func(o *handlerOptions) {
+		if o.basicAuth == nil {
+			o.basicAuth = make(map[string]string)
+		}
+		o.basicAuth[user] = password
+	}
+}
+
+// WithSigningKey requires requests to be for URLs signed using key (see SignPath),
+// unless they are otherwise authenticated
+func WithSigningKey(key []byte) Option {
+	return func(o *handlerOptions) {
+		o.signingKey = key
+	}
+}
+
+// WithCredentials requires requests to the S3 gateway to be signed (using AWS Signature Version 4)
+// with the given credentials. It may be used more than once to allow several keys.
+func WithCredentials(accessKeyID, secretAccessKey string) Option {
+	return func(o *handlerOptions) {
+		if o.credentials == nil {
+			o.credentials = make(map[string]string)
+		}
+		o.credentials[accessKeyID] = secretAccessKey
+	}
+}
+
+// WithAccessList limits the archives and members that are served
+func WithAccessList(list *AccessList) Option {
+	return func(o *handlerOptions) {
+		o.access = list
+	}
+}
+
+// WithBrowsing lists the archives and directories under the root, for backends that support listing
+func WithBrowsing() Option {
+	return func(o *handlerOptions) {
+		o.browse = true
+	}
+}
+
+func NewHandler(client *cloudzip.Client, root string, opts ...Option) *Handler {
+	o := &handlerOptions{
+		cacheTTL:  DefaultCacheTTL,
+		cacheSize: DefaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Handler{
+		client:     client,
+		root:       strings.TrimSuffix(root, "/"),
+		archives:   newArchiveCache(client, o.cacheTTL, o.cacheSize),
+		tokens:     o.tokens,
+		basicAuth:  o.basicAuth,
+		signingKey: o.signingKey,
+		access:     o.access,
+		browse:     o.browse,
+	}
+}
+
+// writeError responds with the status matching err
+func writeError(w http.ResponseWriter, r *http.Request, err error, objectPath, internalPath string) {
+	switch {
+	case errors.Is(err, remote.ErrDoesNotExist) || errors.Is(err, zipfile.ErrFileNotFound):
+		slog.DebugContext(r.Context(), "not found",
+			"error", err,
+			"objectPath", objectPath,
+			"internalPath", internalPath)
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, remote.ErrTooManyFetches):
+		slog.Warn("too many concurrent requests to upstream storage", "objectPath", objectPath)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case errors.Is(err, remote.ErrInvalidURI):
+		slog.Warn("could not open zip file", "error", err,
+			"objectPath", objectPath, "internalPath", internalPath)
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		slog.Warn("Error reading zip file from upstream", "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// MemberETag derives a strong ETag for a member from the ETag of its archive and the member's CRC and size,
+// so that it changes whenever the archive is replaced. It returns "" if the archive's ETag is unknown.
+func MemberETag(archiveETag string, record *zipfile.CDR) string {
+	if archiveETag == "" {
+		return ""
+	}
+	prefix := ""
+	if strings.HasPrefix(archiveETag, "W/") {
+		// a weak archive ETag can only yield a weak member ETag
+		prefix = "W/"
+		archiveETag = strings.TrimPrefix(archiveETag, "W/")
+	}
+	return fmt.Sprintf(`%s"%s-%08x-%x"`, prefix, strings.Trim(archiveETag, `"`),
+		record.CRC32Uncompressed, record.UncompressedSizeBytes)
+}
+
+// contentTypes are types for extensions common in datasets, which may be missing from the system's MIME tables
+var contentTypes = map[string]string{
+	".csv":     "text/csv; charset=utf-8",
+	".geojson": "application/geo+json",
+	".jsonl":   "application/jsonl",
+	".md":      "text/markdown; charset=utf-8",
+	".mp3":     "audio/mpeg",
+	".mp4":     "video/mp4",
+	".parquet": "application/vnd.apache.parquet",
+	".tsv":     "text/tab-separated-values; charset=utf-8",
+	".txt":     "text/plain; charset=utf-8",
+	".webm":    "video/webm",
+	".yaml":    "application/yaml",
+	".yml":     "application/yaml",
+}
+
+// ContentType returns the MIME type of a member by its extension, or application/octet-stream if unknown
+func ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// splitArchivePath splits a request path such as /a/b.zip/c/d.txt into the path of the archive (/a/b.zip)
+// and the path within it (/c/d.txt). The first path element ending with .zip is taken to be the archive.
+func splitArchivePath(p string) (string, string, bool) {
+	for i := 0; i < len(p); {
+		next := strings.IndexByte(p[i+1:], '/')
+		end := len(p)
+		if next >= 0 {
+			end = i + 1 + next
+		}
+		if strings.HasSuffix(strings.ToLower(p[i:end]), ".zip") {
+			return p[:end], p[end:], true
+		}
+		i = end
+	}
+	return "", "", false
+}
+
+// isCleanPath returns false for paths that don't resolve to themselves, such as those with ".." segments,
+// which could otherwise address objects outside the served root (or evade anchored access rules).
+// A trailing slash is allowed.
+func isCleanPath(p string) bool {
+	trimmed := strings.TrimSuffix(p, "/")
+	if trimmed == "" {
+		return true
+	}
+	for _, segment := range strings.Split(trimmed, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return path.Clean(trimmed) == trimmed
+}
+
+// redirect responds with a permanent redirect to the request's URL, with its path replaced
+func redirect(w http.ResponseWriter, r *http.Request, p string) {
+	u := *r.URL
+	u.Path = p
+	u.RawPath = ""
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authenticate(r) {
+		slog.DebugContext(r.Context(), "unauthenticated request", "path", r.URL.Path)
+		h.writeUnauthorized(w)
+		return
+	}
+	if !isCleanPath(r.URL.Path) {
+		slog.DebugContext(r.Context(), "rejected unclean path", "path", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("filename") {
+		h.serveQuery(w, r)
+		return
+	}
+	archivePath, internalPath, ok := splitArchivePath(r.URL.Path)
+	if !ok && h.browse {
+		h.serveDirectory(w, r)
+		return
+	} else if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if internalPath == "" {
+		// the archive root is a directory, so relative links must resolve within it
+		redirect(w, r, archivePath+"/")
+		return
+	}
+	h.servePath(w, r, archivePath, internalPath)
+}
+
+// serveQuery serves /path/to/archive.zip?filename=member
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
+	internalPath := r.URL.Query().Get("filename")
+	objectURI := h.root + r.URL.Path
+	slog.Debug("HTTP Handler", "objectPath", r.URL.Path, "internalPath", internalPath)
+	if !h.access.Allowed(r.URL.Path, internalPath) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	cached, err := h.archives.get(r.Context(), objectURI)
+	if err != nil {
+		writeError(w, r, err, r.URL.Path, internalPath)
+		return
+	}
+	record, err := cached.archive.Stat(internalPath)
+	if err != nil {
+		writeError(w, r, err, r.URL.Path, internalPath)
+		return
+	}
+	if record.Mode.IsDir() {
+		writeError(w, r, zipfile.ErrFileNotFound, r.URL.Path, internalPath)
+		return
+	}
+	h.serveRecord(w, r, cached, record)
+}
+
+// servePath serves /path/to/archive.zip/internal/path: a member, or a listing (or index.html) of a directory
+func (h *Handler) servePath(w http.ResponseWriter, r *http.Request, archivePath, internalPath string) {
+	objectURI := h.root + archivePath
+	name := strings.Trim(internalPath, "/")
+	slog.Debug("HTTP Handler", "objectPath", archivePath, "internalPath", name)
+	if !h.access.Allowed(archivePath, name) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	cached, err := h.archives.get(r.Context(), objectURI)
+	if err != nil {
+		writeError(w, r, err, archivePath, name)
+		return
+	}
+	archive, fsys := cached.archive, cached.fsys
+	if name == "" {
+		name = "."
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		writeError(w, r, zipfile.ErrFileNotFound, archivePath, name)
+		return
+	}
+	if !info.IsDir() {
+		if strings.HasSuffix(internalPath, "/") {
+			redirect(w, r, strings.TrimSuffix(r.URL.Path, "/"))
+			return
+		}
+		record, err := archive.Stat(name)
+		if err != nil {
+			writeError(w, r, err, archivePath, name)
+			return
+		}
+		h.serveRecord(w, r, cached, record)
+		return
+	}
+	if !strings.HasSuffix(internalPath, "/") {
+		redirect(w, r, r.URL.Path+"/")
+		return
+	}
+	index := path.Join(name, "index.html")
+	if record, err := archive.Stat(index); err == nil && !record.Mode.IsDir() && h.access.Allowed(archivePath, index) {
+		h.serveRecord(w, r, cached, record)
+		return
+	}
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		writeError(w, r, zipfile.ErrFileNotFound, archivePath, name)
+		return
+	}
+	// only list what may be served
+	allowed := entries[:0]
+	for _, entry := range entries {
+		if h.access.Allowed(archivePath, path.Join(name, entry.Name())) {
+			allowed = append(allowed, entry)
+		}
+	}
+	writeListing(w, r, &Listing{Path: r.URL.Path, Entries: listingEntries(allowed), Parent: name != "." || h.browse})
+}
+
+// serveDirectory lists the archives (as directories) and directories under root + r.URL.Path
+func (h *Handler) serveDirectory(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		redirect(w, r, r.URL.Path+"/")
+		return
+	}
+	dirPath := strings.TrimSuffix(r.URL.Path, "/")
+	slog.Debug("HTTP Handler", "dirPath", dirPath)
+	if !h.access.DirectoryAllowed(dirPath) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f, err := h.client.Fetcher(r.Context(), h.root+dirPath)
+	if err != nil {
+		writeError(w, r, err, dirPath, "")
+		return
+	}
+	after := r.URL.Query().Get("after")
+	entries, err := remote.List(r.Context(), f, after, directoryPageSize+1)
+	if errors.Is(err, remote.ErrListUnsupported) {
+		slog.Warn("could not list directory", "error", err, "dirPath", dirPath)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		writeError(w, r, err, dirPath, "")
+		return
+	}
+	// object stores have no empty directories: an empty prefix doesn't exist
+	if len(entries) == 0 && dirPath != "" && after == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var next string
+	if len(entries) > directoryPageSize {
+		entries = entries[:directoryPageSize]
+		next = entries[len(entries)-1].Key()
+	}
+	listing := make([]*ListingEntry, 0, len(entries))
+	for _, entry := range entries {
+		entryPath := dirPath + "/" + entry.Name
+		switch {
+		case entry.IsPrefix && h.access.DirectoryAllowed(entryPath):
+			listing = append(listing, &ListingEntry{Name: entry.Name, Dir: true})
+		case !entry.IsPrefix && strings.HasSuffix(strings.ToLower(entry.Name), ".zip") && h.access.Allowed(entryPath, ""):
+			// archives are browsed as directories
+			listing = append(listing, &ListingEntry{Name: entry.Name, Dir: true, Size: entry.Size, Modified: entry.LastModified})
+		}
+	}
+	writeListing(w, r, &Listing{Path: r.URL.Path, Entries: listing, Parent: dirPath != "", Next: next})
+}
+
+// requestRanges returns the ranges of a member of the given size requested by r's Range header,
+// so that only those are fetched. Invalid headers are left to http.ServeContent to reject.
+func requestRanges(r *http.Request, size int64) []cloudzip.Range {
+	specs, found := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !found {
+		return nil
+	}
+	var ranges []cloudzip.Range
+	for _, spec := range strings.Split(specs, ",") {
+		first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+		if !found {
+			return nil
+		}
+		var start, end int64
+		var err error
+		if first == "" {
+			// suffix range: the last bytes of the member
+			var n int64
+			if n, err = strconv.ParseInt(last, 10, 64); err != nil || n < 0 {
+				return nil
+			}
+			start, end = max(size-n, 0), size-1
+		} else {
+			if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+				return nil
+			}
+			end = size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil
+				}
+				end = min(end, size-1)
+			}
+		}
+		if start < size {
+			ranges = append(ranges, cloudzip.Range{Offset: start, Length: end - start + 1})
+		}
+	}
+	return ranges
+}
+
+// serveRecord writes the content of record, handling HEAD, range and conditional requests.
+// Deflated members are sent compressed, as stored in the archive, to clients accepting gzip.
+func (h *Handler) serveRecord(w http.ResponseWriter, r *http.Request, cached *cachedArchive, record *zipfile.CDR) {
+	// the parsed archive is shared, but members are read using this request's context
+	archive := cached.open(r.Context())
+	etag := MemberETag(cached.etag, record)
+	var content io.ReadSeekCloser
+	if record.CompressionMethod == zip.Deflate {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if encoding := passthroughEncoding(r, record); encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		etag = encodedETag(etag, encoding)
+		content = openEncoded(archive, record, encoding)
+	} else {
+		content = archive.OpenSeeker(record, requestRanges(r, int64(record.UncompressedSizeBytes))...)
+	}
+	content = h.archives.pin(cached, content)
+	defer func() { _ = content.Close() }()
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Content-Type", ContentType(record.FileName))
+	// handles HEAD, Range (including multiple ranges), If-None-Match, If-Modified-Since, If-Range and If-Match
+	http.ServeContent(w, r, record.FileName, record.Modified, content)
+	slog.DebugContext(r.Context(), "wrote response",
+		"objectPath", r.URL.Path,
+		"internalPath", record.FileName,
+		"range", r.Header.Get("Range"),
+		"encoding", w.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,730 @@
+package proxy_test
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+var content = strings.Repeat("0123456789", 1000)
+
+func testServer(t *testing.T, opts ...proxy.Option) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "archive.zip"), content)
+	server := httptest.NewServer(proxy.NewHandler(cloudzip.NewClient(), "file://"+dir, opts...))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeTestArchive(t *testing.T, p, content string) {
+	t.Helper()
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	members := []struct {
+		Name    string
+		Method  uint16
+		Content string
+	}{
+		{"stored.txt", zip.Store, content},
+		{"deflated.txt", zip.Deflate, content},
+		{"site/index.html", zip.Deflate, "<h1>site</h1>"},
+		{"data/a b.csv", zip.Deflate, "a,b\n"},
+		{"data/nested/x.bin", zip.Store, "x"},
+	}
+	for _, m := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.Name, Method: m.Method, Modified: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, m.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func do(t *testing.T, method, url string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// don't let the transport ask for (and transparently decode) gzip
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestHandler_Range(t *testing.T) {
+	server := testServer(t)
+	for _, member := range []string{"stored.txt", "deflated.txt"} {
+		t.Run(member, func(t *testing.T) {
+			url := server.URL + "/archive.zip?filename=" + member
+			resp, body := do(t, http.MethodGet, url, nil)
+			if resp.StatusCode != http.StatusOK || body != content || resp.ContentLength != int64(len(content)) {
+				t.Errorf("GET: unexpected response: %d, %d bytes", resp.StatusCode, len(body))
+			}
+			if resp.Header.Get("Last-Modified") != "Tue, 02 Jan 2024 03:04:06 GMT" || resp.Header.Get("ETag") == "" {
+				t.Errorf("GET: missing validators: %v", resp.Header)
+			}
+
+			resp, body = do(t, http.MethodHead, url, nil)
+			if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != int64(len(content)) {
+				t.Errorf("HEAD: unexpected response: %d, length %d", resp.StatusCode, resp.ContentLength)
+			}
+
+			resp, body = do(t, http.MethodGet, url, map[string]string{"Range": "bytes=5012-5015"})
+			if resp.StatusCode != http.StatusPartialContent || body != "2345" {
+				t.Errorf("Range: unexpected response: %d, '%s'", resp.StatusCode, body)
+			}
+			if resp.Header.Get("Content-Range") != "bytes 5012-5015/10000" {
+				t.Errorf("Range: unexpected Content-Range: %s", resp.Header.Get("Content-Range"))
+			}
+
+			resp, body = do(t, http.MethodGet, url, map[string]string{"Range": "bytes=0-1,-3"})
+			if resp.StatusCode != http.StatusPartialContent ||
+				!strings.HasPrefix(resp.Header.Get("Content-Type"), "multipart/byteranges") ||
+				!strings.Contains(body, "\r\n\r\n01\r\n") || !strings.Contains(body, "\r\n\r\n789\r\n") {
+				t.Errorf("multi-range: unexpected response: %d, '%s'", resp.StatusCode, body)
+			}
+
+			resp, _ = do(t, http.MethodGet, url, map[string]string{"Range": "bytes=20000-"})
+			if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("unsatisfiable range: expected 416, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// rangeFetcher records the number of bytes requested by each fetch, -1 for open ended ones
+type rangeFetcher struct {
+	next   remote.Fetcher
+	l      *sync.Mutex
+	ranges *[]int64
+}
+
+func (f *rangeFetcher) Fetch(ctx context.Context, start, end *int64) (io.ReadCloser, error) {
+	n := int64(-1)
+	if start != nil && end != nil {
+		n = *end - *start + 1
+	}
+	f.l.Lock()
+	*f.ranges = append(*f.ranges, n)
+	f.l.Unlock()
+	return f.next.Fetch(ctx, start, end)
+}
+
+func (f *rangeFetcher) Stat(ctx context.Context) (*remote.ObjectInfo, error) {
+	return remote.Stat(ctx, f.next)
+}
+
+func TestHandler_RangeIsBounded(t *testing.T) {
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "archive.zip"), content)
+	l := &sync.Mutex{}
+	ranges := make([]int64, 0)
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &rangeFetcher{next: next, l: l, ranges: &ranges}
+	}))
+	server := httptest.NewServer(proxy.NewHandler(client, "file://"+dir))
+	defer server.Close()
+	url := server.URL + "/archive.zip/stored.txt"
+	// open the archive first, so only member reads are recorded
+	resp, _ := do(t, http.MethodHead, url, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+	cases := []struct {
+		Name     string
+		Headers  map[string]string
+		Body     string
+		MaxFetch int64
+	}{
+		{"range", map[string]string{"Range": "bytes=5012-5015"}, "2345", 4},
+		{"range from the start", map[string]string{"Range": "bytes=0-1"}, "01", 2 + 2048},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, "789", 3},
+		{"if-range mismatch", map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`}, content, int64(len(content)) + 2048},
+		{"if-range match", map[string]string{"Range": "bytes=5012-5015", "If-Range": etag}, "2345", 4},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			l.Lock()
+			ranges = ranges[:0]
+			l.Unlock()
+			_, body := do(t, http.MethodGet, url, cas.Headers)
+			if body != cas.Body {
+				t.Errorf("expected '%.20s' (%d bytes), got '%.20s' (%d bytes)", cas.Body, len(cas.Body), body, len(body))
+			}
+			l.Lock()
+			defer l.Unlock()
+			for _, n := range ranges {
+				// the local header of the member is fetched separately, or along with its data
+				if n < 0 || n > max(cas.MaxFetch, 30) {
+					t.Errorf("expected fetches of at most %d bytes, got %v", cas.MaxFetch, ranges)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Conditional(t *testing.T) {
+	server := testServer(t)
+	url := server.URL + "/archive.zip?filename=stored.txt"
+	resp, _ := do(t, http.MethodHead, url, nil)
+	etag := resp.Header.Get("ETag")
+
+	cases := []struct {
+		Name     string
+		Headers  map[string]string
+		Expected int
+	}{
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"not modified since", map[string]string{"If-Modified-Since": "Wed, 03 Jan 2024 00:00:00 GMT"}, http.StatusNotModified},
+		{"modified since", map[string]string{"If-Modified-Since": "Mon, 01 Jan 2024 00:00:00 GMT"}, http.StatusOK},
+		{"if-range mismatch", map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`}, http.StatusOK},
+		{"if-range match", map[string]string{"Range": "bytes=0-1", "If-Range": etag}, http.StatusPartialContent},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			resp, _ := do(t, http.MethodGet, url, cas.Headers)
+			if resp.StatusCode != cas.Expected {
+				t.Errorf("expected %d, got %d", cas.Expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	server := testServer(t)
+	for _, path := range []string{"/archive.zip?filename=missing.txt", "/missing.zip?filename=stored.txt"} {
+		resp, _ := do(t, http.MethodGet, server.URL+path, nil)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, resp.StatusCode)
+		}
+	}
+	resp, _ := do(t, http.MethodPost, server.URL+"/archive.zip?filename=stored.txt", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_Paths(t *testing.T) {
+	server := testServer(t)
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	redirects := map[string]string{
+		"/archive.zip":             "/archive.zip/",
+		"/archive.zip/data":        "/archive.zip/data/",
+		"/archive.zip/stored.txt/": "/archive.zip/stored.txt",
+	}
+	for from, to := range redirects {
+		resp, err := noRedirect.Get(server.URL + from)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != to {
+			t.Errorf("%s: expected redirect to %s, got %d %s", from, to, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	cases := []struct {
+		Path        string
+		Status      int
+		ContentType string
+		Body        string
+	}{
+		{"/archive.zip/stored.txt", http.StatusOK, "text/plain; charset=utf-8", content},
+		{"/archive.zip/data/a%20b.csv", http.StatusOK, "text/csv; charset=utf-8", "a,b\n"},
+		{"/archive.zip/data/nested/x.bin", http.StatusOK, "application/octet-stream", "x"},
+		{"/archive.zip/site/", http.StatusOK, "text/html; charset=utf-8", "<h1>site</h1>"},
+		{"/archive.zip/missing.txt", http.StatusNotFound, "", ""},
+		{"/missing.zip/stored.txt", http.StatusNotFound, "", ""},
+		{"/not-an-archive/stored.txt", http.StatusNotFound, "", ""},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Path, func(t *testing.T) {
+			resp, body := do(t, http.MethodGet, server.URL+cas.Path, nil)
+			if resp.StatusCode != cas.Status {
+				t.Fatalf("expected %d, got %d", cas.Status, resp.StatusCode)
+			}
+			if cas.Status != http.StatusOK {
+				return
+			}
+			if resp.Header.Get("Content-Type") != cas.ContentType {
+				t.Errorf("expected Content-Type %s, got %s", cas.ContentType, resp.Header.Get("Content-Type"))
+			}
+			if body != cas.Body {
+				t.Errorf("unexpected body: '%s'", body)
+			}
+		})
+	}
+}
+
+func TestHandler_Listing(t *testing.T) {
+	server := testServer(t)
+	resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/data/", map[string]string{"Accept": "application/json"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	listing := &proxy.Listing{}
+	if err := json.Unmarshal([]byte(body), listing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listing.Path != "/archive.zip/data/" || len(listing.Entries) != 2 ||
+		listing.Entries[0].Name != "a b.csv" || listing.Entries[0].Size != 4 ||
+		listing.Entries[1].Name != "nested" || !listing.Entries[1].Dir {
+		t.Errorf("unexpected listing: %s", body)
+	}
+
+	resp, body = do(t, http.MethodGet, server.URL+"/archive.zip/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, link := range []string{`href="./data/"`, `href="./site/"`, `href="./stored.txt"`} {
+		if !strings.Contains(body, link) {
+			t.Errorf("expected listing to contain %s", link)
+		}
+	}
+	if strings.Contains(body, `href="../"`) {
+		t.Errorf("expected no parent link at the archive root")
+	}
+}
+
+type countingFetcher struct {
+	next  remote.Fetcher
+	calls *atomic.Int64
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, start, end *int64) (io.ReadCloser, error) {
+	f.calls.Add(1)
+	return f.next.Fetch(ctx, start, end)
+}
+
+func (f *countingFetcher) Stat(ctx context.Context) (*remote.ObjectInfo, error) {
+	return remote.Stat(ctx, f.next)
+}
+
+func TestHandler_Cache(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.zip")
+	writeTestArchive(t, archivePath, content)
+	calls := &atomic.Int64{}
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &countingFetcher{next: next, calls: calls}
+	}))
+	cases := []struct {
+		Name          string
+		Opts          []proxy.Option
+		ExpectedCalls int64
+	}{
+		{"cached", []proxy.Option{proxy.WithCacheTTL(time.Hour)}, 1},
+		{"revalidated", []proxy.Option{proxy.WithCacheTTL(0)}, 1},
+		{"disabled", []proxy.Option{proxy.WithCacheSize(0)}, 3},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			server := httptest.NewServer(proxy.NewHandler(client, "file://"+dir, cas.Opts...))
+			defer server.Close()
+			for _, p := range []string{"/archive.zip/stored.txt", "/archive.zip?filename=deflated.txt"} {
+				if resp, _ := do(t, http.MethodGet, server.URL+p, nil); resp.StatusCode != http.StatusOK {
+					t.Fatalf("expected 200, got %d", resp.StatusCode)
+				}
+			}
+			before := calls.Load()
+			resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/deflated.txt", nil)
+			if resp.StatusCode != http.StatusOK || body != content {
+				t.Fatalf("unexpected response: %d", resp.StatusCode)
+			}
+			if calls.Load()-before != cas.ExpectedCalls {
+				t.Errorf("expected %d fetches, got %d", cas.ExpectedCalls, calls.Load()-before)
+			}
+		})
+	}
+}
+
+func TestHandler_CacheReplaced(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.zip")
+	writeTestArchive(t, archivePath, content)
+	server := httptest.NewServer(proxy.NewHandler(cloudzip.NewClient(), "file://"+dir, proxy.WithCacheTTL(0)))
+	defer server.Close()
+	resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("unexpected response: %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+
+	replaced := "replaced"
+	writeTestArchive(t, archivePath, replaced)
+	// make sure the modification time changes, as it is part of the local ETag
+	if err := os.Chtimes(archivePath, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	resp, body = do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != replaced {
+		t.Fatalf("expected replaced content, got %d '%s'", resp.StatusCode, body)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("expected ETag to change")
+	}
+
+	if err := os.Remove(archivePath); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted archive, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_CacheReplacedWithinTTL(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "archive.zip")
+	writeTestArchive(t, archivePath, content)
+	server := httptest.NewServer(proxy.NewHandler(cloudzip.NewClient(), "file://"+dir, proxy.WithCacheTTL(time.Hour)))
+	defer server.Close()
+	if resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/data/a%20b.csv", nil); resp.StatusCode != http.StatusOK || body != "a,b\n" {
+		t.Fatalf("unexpected response: %d '%s'", resp.StatusCode, body)
+	}
+
+	// rewritten in place, so that the file still open for the cached archive changes too
+	replaced := "replaced"
+	writeTestArchive(t, archivePath, replaced)
+	if err := os.Chtimes(archivePath, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// the cached offsets no longer match the archive: reading must fail rather than return other bytes
+	resp, err := http.Get(server.URL + "/archive.zip/stored.txt")
+	if err == nil {
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err == nil && (resp.StatusCode != http.StatusOK || string(body) != replaced) {
+			t.Errorf("expected reading a replaced archive to fail, got %d '%s'", resp.StatusCode, body)
+		}
+	}
+	// and the archive is read again by the next request
+	if resp, body := do(t, http.MethodGet, server.URL+"/archive.zip/stored.txt", nil); resp.StatusCode != http.StatusOK || body != replaced {
+		t.Errorf("expected replaced content, got %d '%s'", resp.StatusCode, body)
+	}
+}
+
+func TestHandler_CacheConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "archive.zip"), content)
+	calls := &atomic.Int64{}
+	client := cloudzip.NewClient(cloudzip.WithFetcherMiddleware(func(next remote.Fetcher) remote.Fetcher {
+		return &countingFetcher{next: next, calls: calls}
+	}))
+	server := httptest.NewServer(proxy.NewHandler(client, "file://"+dir))
+	defer server.Close()
+
+	const requests = 10
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/archive.zip/stored.txt")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	// the central directory is read once (end of central directory + central directory), then one fetch per request
+	if calls.Load() != 2+requests {
+		t.Errorf("expected %d fetches, got %d", 2+requests, calls.Load())
+	}
+}
+
+func TestHandler_Encoding(t *testing.T) {
+	server := testServer(t)
+	cases := []struct {
+		Name             string
+		Path             string
+		Headers          map[string]string
+		ExpectedEncoding string
+	}{
+		{"gzip", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "gzip, deflate"}, "gzip"},
+		{"deflate", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "deflate"}, ""},
+		{"gzip refused", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "gzip;q=0, deflate"}, ""},
+		{"wildcard", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "*"}, "gzip"},
+		{"identity", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "identity"}, ""},
+		{"none", "/archive.zip/deflated.txt", nil, ""},
+		{"stored", "/archive.zip/stored.txt", map[string]string{"Accept-Encoding": "gzip"}, ""},
+		{"range", "/archive.zip/deflated.txt", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-"}, ""},
+	}
+	etags := make(map[string]string)
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			resp, body := do(t, http.MethodGet, server.URL+cas.Path, cas.Headers)
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+				t.Fatalf("unexpected status: %d", resp.StatusCode)
+			}
+			encoding := resp.Header.Get("Content-Encoding")
+			if encoding != cas.ExpectedEncoding {
+				t.Fatalf("expected Content-Encoding '%s', got '%s'", cas.ExpectedEncoding, encoding)
+			}
+			var r io.Reader = strings.NewReader(body)
+			if encoding == "gzip" {
+				// also verifies the CRC and size in the synthesized trailer
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				r = gz
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error decoding response: %v", err)
+			}
+			if string(data) != content {
+				t.Errorf("unexpected content: %d bytes", len(data))
+			}
+			if cas.Path == "/archive.zip/deflated.txt" && !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+				t.Errorf("expected Vary: Accept-Encoding")
+			}
+			etags[encoding] = resp.Header.Get("ETag")
+		})
+	}
+	if etags["gzip"] == etags[""] {
+		t.Errorf("expected a distinct ETag per encoding, got %v", etags)
+	}
+
+	resp, _ := do(t, http.MethodGet, server.URL+"/archive.zip/deflated.txt",
+		map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etags["gzip"]})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching encoded ETag, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_AccessList(t *testing.T) {
+	deny, err := proxy.ParseAccessRule("*.zip:data/nested")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := testServer(t, proxy.WithAccessList(&proxy.AccessList{Deny: []*proxy.AccessRule{deny}}))
+	for p, expected := range map[string]int{
+		"/archive.zip/stored.txt":                 http.StatusOK,
+		"/archive.zip/data/nested/x.bin":          http.StatusForbidden,
+		"/archive.zip/data/nested/":               http.StatusForbidden,
+		"/archive.zip?filename=data/nested/x.bin": http.StatusForbidden,
+	} {
+		if resp, _ := do(t, http.MethodGet, server.URL+p, nil); resp.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d", p, expected, resp.StatusCode)
+		}
+	}
+	_, body := do(t, http.MethodGet, server.URL+"/archive.zip/data/?format=json", nil)
+	if strings.Contains(body, "nested") || !strings.Contains(body, "a b.csv") {
+		t.Errorf("expected denied entries to be hidden from listings, got %s", body)
+	}
+}
+
+func TestHandler_Browse(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "datasets", "2024"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestArchive(t, filepath.Join(dir, "datasets", "a.zip"), content)
+	writeTestArchive(t, filepath.Join(dir, "datasets", "2024", "B.ZIP"), "b")
+	if err := os.WriteFile(filepath.Join(dir, "datasets", "notes.txt"), []byte("not an archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deny, err := proxy.ParseAccessRule("/private")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "private"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestArchive(t, filepath.Join(dir, "private", "c.zip"), "c")
+	server := httptest.NewServer(proxy.NewHandler(cloudzip.NewClient(), "file://"+dir,
+		proxy.WithBrowsing(), proxy.WithAccessList(&proxy.AccessList{Deny: []*proxy.AccessRule{deny}})))
+	t.Cleanup(server.Close)
+
+	list := func(p string) *proxy.Listing {
+		t.Helper()
+		resp, body := do(t, http.MethodGet, server.URL+p+"?format=json", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", p, resp.StatusCode)
+		}
+		listing := &proxy.Listing{}
+		if err := json.Unmarshal([]byte(body), listing); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return listing
+	}
+	names := func(listing *proxy.Listing) []string {
+		var names []string
+		for _, entry := range listing.Entries {
+			if !entry.Dir {
+				t.Errorf("expected %s to be listed as a directory", entry.Name)
+			}
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	if got := strings.Join(names(list("/")), ","); got != "datasets" {
+		t.Errorf("unexpected root listing: %s", got)
+	}
+	listing := list("/datasets/")
+	if got := strings.Join(names(listing), ","); got != "2024,a.zip" {
+		t.Errorf("unexpected listing: %s", got)
+	}
+	if listing.Entries[1].Size == 0 || listing.Entries[1].Modified.IsZero() {
+		t.Errorf("expected archives to be listed with their size and modification time")
+	}
+	if got := strings.Join(names(list("/datasets/2024/")), ","); got != "B.ZIP" {
+		t.Errorf("unexpected listing: %s", got)
+	}
+
+	_, body := do(t, http.MethodGet, server.URL+"/datasets/2024/B.ZIP/", nil)
+	if !strings.Contains(body, `href="../"`) || !strings.Contains(body, `href="./stored.txt"`) {
+		t.Errorf("expected the archive root to be listed with a parent link, got %s", body)
+	}
+	for p, expected := range map[string]int{
+		"/datasets/a.zip/data/a%20b.csv":  http.StatusOK,
+		"/datasets/2024/B.ZIP/stored.txt": http.StatusOK,
+		"/datasets":                       http.StatusMovedPermanently,
+		"/missing/":                       http.StatusNotFound,
+		"/datasets/notes.txt/":            http.StatusNotFound,
+		"/private/":                       http.StatusForbidden,
+		"/private/c.zip/stored.txt":       http.StatusForbidden,
+	} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("%s: expected %d, got %d", p, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandler_BrowsePages(t *testing.T) {
+	dir := t.TempDir()
+	const archives = 1001
+	for i := 0; i < archives; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%04d.zip", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(proxy.NewHandler(cloudzip.NewClient(), "file://"+dir, proxy.WithBrowsing()))
+	t.Cleanup(server.Close)
+
+	var listed []string
+	query := "?format=json"
+	for pages := 1; ; pages++ {
+		resp, body := do(t, http.MethodGet, server.URL+"/"+query, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+		listing := &proxy.Listing{}
+		if err := json.Unmarshal([]byte(body), listing); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, entry := range listing.Entries {
+			listed = append(listed, entry.Name)
+		}
+		if listing.Next == "" {
+			if pages != 2 {
+				t.Errorf("expected 2 pages, got %d", pages)
+			}
+			break
+		}
+		query = "?format=json&after=" + url.QueryEscape(listing.Next)
+	}
+	if len(listed) != archives || listed[0] != "0000.zip" || listed[archives-1] != "1000.zip" {
+		t.Errorf("expected all %d archives to be listed once, got %d", archives, len(listed))
+	}
+	if _, body := do(t, http.MethodGet, server.URL+"/", nil); !strings.Contains(body, `href="?after=0999.zip"`) {
+		t.Errorf("expected a link to the next page")
+	}
+}
+
+func TestHandler_PathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "secret.zip"), "secret")
+	if err := os.MkdirAll(filepath.Join(dir, "root", "private"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestArchive(t, filepath.Join(dir, "root", "archive.zip"), content)
+	writeTestArchive(t, filepath.Join(dir, "root", "private", "c.zip"), "c")
+	deny, err := proxy.ParseAccessRule("/private")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := proxy.NewHandler(cloudzip.NewClient(), "file://"+filepath.Join(dir, "root"),
+		proxy.WithBrowsing(), proxy.WithAccessList(&proxy.AccessList{Deny: []*proxy.AccessRule{deny}}))
+	for _, p := range []string{
+		"/../secret.zip/stored.txt",
+		"/../secret.zip?filename=stored.txt",
+		"/x/../../secret.zip/stored.txt",
+		"/x/../private/c.zip/stored.txt",
+		"//private/c.zip/stored.txt",
+		"/./private/c.zip/stored.txt",
+		"/../",
+	} {
+		// sent as is, without the cleaning done by http.ServeMux
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", p, http.StatusBadRequest, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/archive.zip/stored.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected clean paths to be served, got %d", w.Code)
+	}
+}
@@ -0,0 +1,522 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+	// s3TimeFormat is the format of timestamps in S3 responses
+	s3TimeFormat = "2006-01-02T15:04:05.000Z"
+	// maxListKeys is the most keys (and common prefixes) returned by a single ListObjectsV2 request
+	maxListKeys = 1000
+)
+
+// s3Error is an error response of the S3 API
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string `xml:",omitempty"`
+	status   int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied          = &s3Error{status: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+	errPresignExpired        = &s3Error{status: http.StatusForbidden, Code: "AccessDenied", Message: "Request has expired"}
+	errInvalidAccessKeyID    = &s3Error{status: http.StatusForbidden, Code: "InvalidAccessKeyId", Message: "The AWS access key ID you provided does not exist in our records."}
+	errSignatureDoesNotMatch = &s3Error{status: http.StatusForbidden, Code: "SignatureDoesNotMatch", Message: "The request signature we calculated does not match the signature you provided."}
+	errRequestTimeTooSkewed  = &s3Error{status: http.StatusForbidden, Code: "RequestTimeTooSkewed", Message: "The difference between the request time and the server's time is too large."}
+	errNoSuchBucket          = &s3Error{status: http.StatusNotFound, Code: "NoSuchBucket", Message: "The specified bucket does not exist."}
+	errNoSuchKey             = &s3Error{status: http.StatusNotFound, Code: "NoSuchKey", Message: "The specified key does not exist."}
+	errMethodNotAllowed      = &s3Error{status: http.StatusMethodNotAllowed, Code: "MethodNotAllowed", Message: "The gateway is read-only."}
+	errInternal              = &s3Error{status: http.StatusInternalServerError, Code: "InternalError", Message: "We encountered an internal error. Please try again."}
+	errUpstream              = &s3Error{status: http.StatusBadGateway, Code: "InternalError", Message: "Could not read the archive from upstream storage."}
+	errSlowDown              = &s3Error{status: http.StatusServiceUnavailable, Code: "SlowDown", Message: "Please reduce your request rate."}
+)
+
+func errAuthorizationMalformed(message string) *s3Error {
+	return &s3Error{status: http.StatusBadRequest, Code: "AuthorizationHeaderMalformed", Message: message}
+}
+
+func errInvalidArgument(message string) *s3Error {
+	return &s3Error{status: http.StatusBadRequest, Code: "InvalidArgument", Message: message}
+}
+
+func errNotImplemented(message string) *s3Error {
+	return &s3Error{status: http.StatusNotImplemented, Code: "NotImplemented", Message: message}
+}
+
+// s3ErrorFor maps errors opening archives and members to S3 errors
+func s3ErrorFor(r *http.Request, err error) *s3Error {
+	var s3Err *s3Error
+	switch {
+	case errors.As(err, &s3Err):
+		return s3Err
+	case errors.Is(err, remote.ErrDoesNotExist) || errors.Is(err, zipfile.ErrFileNotFound):
+		return errNoSuchKey
+	case errors.Is(err, remote.ErrTooManyFetches):
+		slog.Warn("too many concurrent requests to upstream storage", "path", r.URL.Path)
+		return errSlowDown
+	case errors.Is(err, remote.ErrInvalidURI):
+		slog.Warn("could not open zip file", "error", err, "path", r.URL.Path)
+		return errInternal
+	}
+	slog.Warn("Error reading zip file from upstream", "error", err, "path", r.URL.Path)
+	return errUpstream
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("could not write response", "error", err)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, err *s3Error) {
+	slog.DebugContext(r.Context(), "S3 error", "code", err.Code, "path", r.URL.Path)
+	response := *err
+	response.Resource = r.URL.Path
+	writeXML(w, err.status, &response)
+}
+
+// objectSubresources are query arguments selecting something other than an object's content, which isn't supported
+var objectSubresources = []string{"acl", "attributes", "legal-hold", "retention", "tagging", "torrent", "uploadId", "uploads"}
+
+// responseOverrides are the query arguments of GetObject overriding response headers. As on S3, they are
+// only honoured on signed requests: otherwise anyone could have members served with, say, an HTML Content-Type.
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// S3Handler serves the members of remote zip archives through a read-only subset of the S3 API, as used by
+// `cz s3-gateway`. Each bucket maps to a prefix: the members of the archive at prefix + "/path/to/archive.zip"
+// are the objects with keys "path/to/archive.zip/<member>". Buckets are addressed in the request path
+// (path-style) or as the first label of the host name (virtual-hosted-style).
+//
+// Supported operations are ListBuckets, HeadBucket, GetBucketLocation, ListObjectsV2, HeadObject and
+// GetObject (including range and conditional requests). Above archive level, listing requires the "/" delimiter
+// and returns directories and archives as common prefixes (e.g. "path/to/archive.zip/"), not recursing into them.
+type S3Handler struct {
+	client      *cloudzip.Client
+	buckets     map[string]string
+	created     time.Time
+	archives    *archiveCache
+	credentials map[string]string
+	access      *AccessList
+}
+
+// NewS3Handler returns a handler serving buckets, mapping bucket names to the prefixes they serve.
+// It supports the cache, access list and credentials options; other options are ignored.
+func NewS3Handler(client *cloudzip.Client, buckets map[string]string, opts ...Option) *S3Handler {
+	o := &handlerOptions{
+		cacheTTL:  DefaultCacheTTL,
+		cacheSize: DefaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	roots := make(map[string]string, len(buckets))
+	for name, root := range buckets {
+		roots[name] = strings.TrimSuffix(root, "/")
+	}
+	return &S3Handler{
+		client:      client,
+		buckets:     roots,
+		created:     time.Now(),
+		archives:    newArchiveCache(client, o.cacheTTL, o.cacheSize),
+		credentials: o.credentials,
+		access:      o.access,
+	}
+}
+
+// route returns the bucket and key addressed by r, using virtual-hosted-style addressing
+// if the first label of the host name is a bucket
+func (h *S3Handler) route(r *http.Request) (string, string) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if label, _, found := strings.Cut(host, "."); found {
+		if _, ok := h.buckets[label]; ok {
+			return label, strings.TrimPrefix(r.URL.Path, "/")
+		}
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return bucket, key
+}
+
+func (h *S3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeS3Error(w, r, errMethodNotAllowed)
+		return
+	}
+	if len(h.credentials) > 0 {
+		if err := verifySigV4(h.credentials, r, time.Now()); err != nil {
+			slog.DebugContext(r.Context(), "unauthenticated request", "path", r.URL.Path, "error", err)
+			writeS3Error(w, r, s3ErrorFor(r, err))
+			return
+		}
+	}
+	bucket, key := h.route(r)
+	if bucket == "" {
+		h.listBuckets(w)
+		return
+	}
+	root, ok := h.buckets[bucket]
+	if !ok {
+		writeS3Error(w, r, errNoSuchBucket)
+		return
+	}
+	query := r.URL.Query()
+	// keys map to paths under root, so they must not climb out of it
+	if !isCleanPath("/"+key) || !isCleanPath("/"+query.Get("prefix")) {
+		writeS3Error(w, r, errInvalidArgument("Keys and prefixes may not contain '.', '..' or empty path segments."))
+		return
+	}
+	switch {
+	case key != "":
+		h.getObject(w, r, root, key)
+	case query.Has("location"):
+		writeXML(w, http.StatusOK, &locationConstraint{})
+	case query.Get("list-type") == "2":
+		h.listObjects(w, r, bucket, root)
+	case r.Method == http.MethodHead:
+		// HeadBucket
+		w.WriteHeader(http.StatusOK)
+	case len(query) == 0:
+		writeS3Error(w, r, errNotImplemented("Only ListObjectsV2 (list-type=2) is supported."))
+	default:
+		writeS3Error(w, r, errNotImplemented("This bucket operation is not supported."))
+	}
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+	// an empty location is us-east-1
+	Location string `xml:",chardata"`
+}
+
+func (h *S3Handler) listBuckets(w http.ResponseWriter) {
+	result := &listAllMyBucketsResult{Owner: s3Owner{ID: "cz", DisplayName: "cz"}}
+	for name := range h.buckets {
+		result.Buckets = append(result.Buckets, s3Bucket{Name: name, CreationDate: h.created.UTC().Format(s3TimeFormat)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Name < result.Buckets[j].Name
+	})
+	writeXML(w, http.StatusOK, result)
+}
+
+// s3ETag returns the ETag of a member as an S3 object: strong, and never empty
+func s3ETag(archiveETag string, record *zipfile.CDR) string {
+	etag := strings.TrimPrefix(MemberETag(archiveETag, record), "W/")
+	if etag == "" {
+		etag = fmt.Sprintf(`"%08x-%x"`, record.CRC32Uncompressed, record.UncompressedSizeBytes)
+	}
+	return etag
+}
+
+// getObject serves GetObject and HeadObject
+func (h *S3Handler) getObject(w http.ResponseWriter, r *http.Request, root, key string) {
+	query := r.URL.Query()
+	for _, subresource := range objectSubresources {
+		if query.Has(subresource) {
+			writeS3Error(w, r, errNotImplemented("Only GetObject and HeadObject are supported on objects."))
+			return
+		}
+	}
+	archivePath, internalPath, ok := splitArchivePath("/" + key)
+	name := strings.TrimPrefix(internalPath, "/")
+	if !ok || name == "" || strings.HasSuffix(name, "/") {
+		// directories aren't objects
+		writeS3Error(w, r, errNoSuchKey)
+		return
+	}
+	if !h.access.Allowed(archivePath, name) {
+		writeS3Error(w, r, errAccessDenied)
+		return
+	}
+	slog.Debug("S3 Handler", "objectPath", archivePath, "internalPath", name)
+
+	cached, err := h.archives.get(r.Context(), root+archivePath)
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFor(r, err))
+		return
+	}
+	record, err := cached.archive.Stat(name)
+	if err == nil && record.Mode.IsDir() {
+		err = zipfile.ErrFileNotFound
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFor(r, err))
+		return
+	}
+
+	ranges := requestRanges(r, int64(record.UncompressedSizeBytes))
+	content := h.archives.pin(cached, cached.open(r.Context()).OpenSeeker(record, ranges...))
+	defer func() { _ = content.Close() }()
+	w.Header().Set("ETag", s3ETag(cached.etag, record))
+	w.Header().Set("Content-Type", ContentType(record.FileName))
+	w.Header().Set("Accept-Ranges", "bytes")
+	// requests were verified by ServeHTTP if credentials are required, and can't be otherwise
+	if len(h.credentials) > 0 {
+		for param, header := range responseOverrides {
+			if value := query.Get(param); value != "" {
+				w.Header().Set(header, value)
+			}
+		}
+	}
+	// handles HEAD, Range, If-None-Match, If-Modified-Since, If-Range and If-Match
+	http.ServeContent(w, r, record.FileName, record.Modified, content)
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         uint64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	Contents              []listedObject
+	CommonPrefixes        []commonPrefix
+}
+
+// listEncode encodes a key as S3 does for encoding-type=url: spaces become "+", slashes are kept
+func listEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "%2F", "/")
+}
+
+// listObjects serves ListObjectsV2, over the members of the archive the prefix starts with
+func (h *S3Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket, root string) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, errInvalidArgument("max-keys must be a non-negative integer"))
+			return
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeS3Error(w, r, errInvalidArgument("Invalid Encoding Method specified in Request"))
+		return
+	}
+	// listing resumes after the last key or common prefix returned, or after start-after
+	marker := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeS3Error(w, r, errInvalidArgument("The continuation token provided is incorrect"))
+			return
+		}
+		marker = string(decoded)
+	}
+
+	result := &listBucketResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+		EncodingType:      encodingType,
+	}
+	var err error
+	if archivePath, _, ok := splitArchivePath("/" + prefix); ok {
+		err = h.listArchive(r, result, root, archivePath, marker)
+	} else {
+		err = h.listDirectory(r, result, root, marker)
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFor(r, err))
+		return
+	}
+	if encodingType == "url" {
+		result.Prefix = listEncode(result.Prefix)
+		result.Delimiter = listEncode(result.Delimiter)
+		result.StartAfter = listEncode(result.StartAfter)
+		for i := range result.Contents {
+			result.Contents[i].Key = listEncode(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = listEncode(result.CommonPrefixes[i].Prefix)
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listDirectory adds the subdirectories and archives of the directory holding result.Prefix to result,
+// as common prefixes (archives hold their members as keys under "path/to/archive.zip/"), starting after marker.
+// Members aren't listed recursively, so the "/" delimiter is required.
+func (h *S3Handler) listDirectory(r *http.Request, result *listBucketResult, root, marker string) error {
+	if result.Delimiter != "/" {
+		return errNotImplemented("Listing without the '/' delimiter is only supported within an archive.")
+	}
+	dir := result.Prefix[:strings.LastIndex(result.Prefix, "/")+1]
+	dirPath := strings.TrimSuffix("/"+dir, "/")
+	if !h.access.DirectoryAllowed(dirPath) {
+		return nil
+	}
+	// the directory is listed a page at a time: markers are either a key listed (or not) in it, or the key
+	// of the last entry of the directory the previous page read, when it ended with entries that aren't listed
+	var after string
+	if strings.HasPrefix(marker, dir) {
+		after = marker[len(dir):]
+	} else if marker > dir {
+		return nil
+	}
+	f, err := h.client.Fetcher(r.Context(), root+dirPath)
+	var entries []*remote.ListEntry
+	if err == nil {
+		entries, err = remote.List(r.Context(), f, after, result.MaxKeys+1)
+	}
+	if errors.Is(err, remote.ErrDoesNotExist) {
+		return nil
+	} else if errors.Is(err, remote.ErrListUnsupported) {
+		return errNotImplemented("The storage this bucket is served from can't be listed.")
+	} else if err != nil {
+		return err
+	}
+	truncated := len(entries) > result.MaxKeys
+	if truncated {
+		entries = entries[:result.MaxKeys]
+	}
+	namePrefix := result.Prefix[len(dir):]
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name, namePrefix) {
+			if entry.Key() > namePrefix {
+				// entries are ordered, so none of the rest starts with the prefix either
+				truncated = false
+				break
+			}
+			continue
+		}
+		entryPath := dirPath + "/" + entry.Name
+		item := dir + entry.Name + "/"
+		if item == marker || item == marker+"/" {
+			// on object stores, a directory may share the name of the archive the previous page ended with
+			continue
+		}
+		if entry.IsPrefix && h.access.DirectoryAllowed(entryPath) ||
+			!entry.IsPrefix && strings.HasSuffix(strings.ToLower(entry.Name), ".zip") && h.access.Allowed(entryPath, "") {
+			result.KeyCount++
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: item})
+		}
+	}
+	if truncated && len(entries) > 0 {
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(dir + entries[len(entries)-1].Key()))
+	}
+	return nil
+}
+
+// listArchive adds the keys of the archive at archivePath to result, starting after marker.
+// A missing archive lists nothing, as would a prefix no object starts with.
+func (h *S3Handler) listArchive(r *http.Request, result *listBucketResult, root, archivePath, marker string) error {
+	cached, err := h.archives.get(r.Context(), root+archivePath)
+	if errors.Is(err, remote.ErrDoesNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	keyPrefix := strings.TrimPrefix(archivePath, "/") + "/"
+	var last string
+	for _, record := range cached.sortedFiles() {
+		key := keyPrefix + record.FileName
+		if !strings.HasPrefix(key, result.Prefix) || !h.access.Allowed(archivePath, record.FileName) {
+			continue
+		}
+		// keys sharing a common prefix are listed once, as that prefix
+		item, isPrefix := key, false
+		if result.Delimiter != "" {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				item, isPrefix = key[:len(result.Prefix)+i+len(result.Delimiter)], true
+			}
+		}
+		if item <= marker || item == last {
+			continue
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+		last = item
+		result.KeyCount++
+		if isPrefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: item})
+			continue
+		}
+		result.Contents = append(result.Contents, listedObject{
+			Key:          key,
+			LastModified: record.Modified.UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(cached.etag, record),
+			Size:         record.UncompressedSizeBytes,
+			StorageClass: "STANDARD",
+		})
+	}
+	return nil
+}
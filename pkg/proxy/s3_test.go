@@ -0,0 +1,436 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/ozkatz/cloudzip/pkg/cloudzip"
+	"github.com/ozkatz/cloudzip/pkg/proxy"
+)
+
+func s3TestServer(t *testing.T, opts ...proxy.Option) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "archive.zip"), content)
+	buckets := map[string]string{"data": "file://" + dir}
+	server := httptest.NewServer(proxy.NewS3Handler(cloudzip.NewClient(), buckets, opts...))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func s3Client(server *httptest.Server, provider aws.CredentialsProvider) *s3.Client {
+	return s3.New(s3.Options{
+		BaseEndpoint:     aws.String(server.URL),
+		UsePathStyle:     true,
+		Region:           "us-east-1",
+		Credentials:      provider,
+		RetryMaxAttempts: 1,
+	})
+}
+
+func staticCredentials(accessKeyID, secretAccessKey string) aws.CredentialsProvider {
+	return credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func getObject(t *testing.T, client *s3.Client, key, rangeHeader string) string {
+	t.Helper()
+	input := &s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String(key)}
+	if rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+	}
+	out, err := client.GetObject(context.Background(), input)
+	if err != nil {
+		t.Fatalf("GetObject %s: unexpected error: %v", key, err)
+	}
+	defer func() { _ = out.Body.Close() }()
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("GetObject %s: unexpected error: %v", key, err)
+	}
+	return string(body)
+}
+
+func TestS3Handler_GetObject(t *testing.T) {
+	client := s3Client(s3TestServer(t), aws.AnonymousCredentials{})
+	ctx := context.Background()
+	for _, member := range []string{"stored.txt", "deflated.txt"} {
+		key := "archive.zip/" + member
+		if body := getObject(t, client, key, ""); body != content {
+			t.Errorf("GetObject %s: unexpected content (%d bytes)", key, len(body))
+		}
+		if body := getObject(t, client, key, "bytes=5012-5015"); body != "2345" {
+			t.Errorf("GetObject %s: unexpected range content: '%s'", key, body)
+		}
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("data"), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("HeadObject %s: unexpected error: %v", key, err)
+		}
+		if aws.ToInt64(head.ContentLength) != int64(len(content)) || aws.ToString(head.ETag) == "" ||
+			!aws.ToTime(head.LastModified).Equal(time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)) {
+			t.Errorf("HeadObject %s: unexpected metadata: %d bytes, ETag %s, modified %v",
+				key, aws.ToInt64(head.ContentLength), aws.ToString(head.ETag), aws.ToTime(head.LastModified))
+		}
+	}
+	if body := getObject(t, client, "archive.zip/data/a b.csv", ""); body != "a,b\n" {
+		t.Errorf("GetObject: unexpected content: '%s'", body)
+	}
+
+	for _, key := range []string{"archive.zip/missing.txt", "archive.zip/data", "archive.zip/data/", "missing.zip/a.txt", "archive.zip"} {
+		_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String(key)})
+		var noSuchKey *types.NoSuchKey
+		if !errors.As(err, &noSuchKey) {
+			t.Errorf("GetObject %s: expected NoSuchKey, got %v", key, err)
+		}
+		_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("data"), Key: aws.String(key)})
+		var notFound *types.NotFound
+		if !errors.As(err, &notFound) {
+			t.Errorf("HeadObject %s: expected NotFound, got %v", key, err)
+		}
+	}
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("nope"), Key: aws.String("archive.zip/stored.txt")})
+	if errorCode(err) != "NoSuchBucket" {
+		t.Errorf("expected NoSuchBucket, got %v", err)
+	}
+
+	// response overrides require a signed request
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:              aws.String("data"),
+		Key:                 aws.String("archive.zip/stored.txt"),
+		ResponseContentType: aws.String("text/html"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = out.Body.Close()
+	if aws.ToString(out.ContentType) == "text/html" {
+		t.Errorf("expected anonymous requests not to override the Content-Type")
+	}
+}
+
+// listAll returns the keys and common prefixes listed for input, following continuation tokens
+func listAll(t *testing.T, client *s3.Client, input *s3.ListObjectsV2Input) ([]string, []string, int) {
+	t.Helper()
+	var keys, prefixes []string
+	pages := 0
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("ListObjectsV2: unexpected error: %v", err)
+		}
+		pages++
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+		for _, prefix := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(prefix.Prefix))
+		}
+	}
+	return keys, prefixes, pages
+}
+
+func TestS3Handler_ListObjectsV2(t *testing.T) {
+	client := s3Client(s3TestServer(t), aws.AnonymousCredentials{})
+	allKeys := []string{
+		"archive.zip/data/a b.csv",
+		"archive.zip/data/nested/x.bin",
+		"archive.zip/deflated.txt",
+		"archive.zip/site/index.html",
+		"archive.zip/stored.txt",
+	}
+	cases := []struct {
+		Name      string
+		Input     *s3.ListObjectsV2Input
+		Keys      []string
+		Prefixes  []string
+		Pages     int
+		Encoding  bool
+		NoListing bool
+	}{
+		{
+			Name:  "all",
+			Input: &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/")},
+			Keys:  allKeys,
+			Pages: 1,
+		},
+		{
+			Name:  "paginated",
+			Input: &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/"), MaxKeys: aws.Int32(2)},
+			Keys:  allKeys,
+			Pages: 3,
+		},
+		{
+			Name:     "delimiter",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/"), Delimiter: aws.String("/")},
+			Keys:     []string{"archive.zip/deflated.txt", "archive.zip/stored.txt"},
+			Prefixes: []string{"archive.zip/data/", "archive.zip/site/"},
+			Pages:    1,
+		},
+		{
+			Name:     "delimiter paginated",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/"), Delimiter: aws.String("/"), MaxKeys: aws.Int32(1)},
+			Keys:     []string{"archive.zip/deflated.txt", "archive.zip/stored.txt"},
+			Prefixes: []string{"archive.zip/data/", "archive.zip/site/"},
+			Pages:    4,
+		},
+		{
+			Name:     "archive as prefix",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip"), Delimiter: aws.String("/")},
+			Prefixes: []string{"archive.zip/"},
+			Pages:    1,
+		},
+		{
+			Name:     "partial name",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/data/n"), Delimiter: aws.String("/")},
+			Prefixes: []string{"archive.zip/data/nested/"},
+			Pages:    1,
+		},
+		{
+			Name:  "start after",
+			Input: &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/"), StartAfter: aws.String("archive.zip/deflated.txt")},
+			Keys:  allKeys[3:],
+			Pages: 1,
+		},
+		{
+			Name:     "url encoding",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("archive.zip/data/"), EncodingType: types.EncodingTypeUrl},
+			Keys:     []string{"archive.zip/data/a+b.csv", "archive.zip/data/nested/x.bin"},
+			Pages:    1,
+			Encoding: true,
+		},
+		{
+			Name:     "not an archive",
+			Input:    &s3.ListObjectsV2Input{Prefix: aws.String("arch"), Delimiter: aws.String("/")},
+			Prefixes: []string{"archive.zip/"},
+			Pages:    1,
+		},
+		{
+			Name:  "missing archive",
+			Input: &s3.ListObjectsV2Input{Prefix: aws.String("missing.zip/")},
+			Pages: 1,
+		},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			cas.Input.Bucket = aws.String("data")
+			keys, prefixes, pages := listAll(t, client, cas.Input)
+			if !reflect.DeepEqual(keys, cas.Keys) {
+				t.Errorf("expected keys %v, got %v", cas.Keys, keys)
+			}
+			if !reflect.DeepEqual(prefixes, cas.Prefixes) {
+				t.Errorf("expected common prefixes %v, got %v", cas.Prefixes, prefixes)
+			}
+			if pages != cas.Pages {
+				t.Errorf("expected %d pages, got %d", cas.Pages, pages)
+			}
+		})
+	}
+}
+
+func TestS3Handler_Buckets(t *testing.T) {
+	client := s3Client(s3TestServer(t), aws.AnonymousCredentials{})
+	ctx := context.Background()
+	buckets, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		t.Fatalf("ListBuckets: unexpected error: %v", err)
+	}
+	if len(buckets.Buckets) != 1 || aws.ToString(buckets.Buckets[0].Name) != "data" {
+		t.Errorf("ListBuckets: unexpected buckets: %+v", buckets.Buckets)
+	}
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("data")}); err != nil {
+		t.Errorf("HeadBucket: unexpected error: %v", err)
+	}
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("nope")}); err == nil {
+		t.Errorf("HeadBucket: expected an error for a missing bucket")
+	}
+	if _, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String("data")}); err != nil {
+		t.Errorf("GetBucketLocation: unexpected error: %v", err)
+	}
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("data"), Key: aws.String("archive.zip/new.txt"), Body: strings.NewReader("x")})
+	if errorCode(err) != "MethodNotAllowed" {
+		t.Errorf("PutObject: expected MethodNotAllowed, got %v", err)
+	}
+}
+
+func TestS3Handler_Auth(t *testing.T) {
+	server := s3TestServer(t, proxy.WithCredentials("AKIDEXAMPLE", "secret"))
+	ctx := context.Background()
+	input := &s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String("archive.zip/data/a b.csv")}
+
+	client := s3Client(server, staticCredentials("AKIDEXAMPLE", "secret"))
+	if body := getObject(t, client, "archive.zip/data/a b.csv", ""); body != "a,b\n" {
+		t.Errorf("signed GetObject: unexpected content: '%s'", body)
+	}
+	if keys, _, _ := listAll(t, client, &s3.ListObjectsV2Input{Bucket: aws.String("data"), Prefix: aws.String("archive.zip/data/")}); len(keys) != 2 {
+		t.Errorf("signed ListObjectsV2: unexpected keys: %v", keys)
+	}
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:                     aws.String("data"),
+		Key:                        aws.String("archive.zip/data/a b.csv"),
+		ResponseContentType:        aws.String("text/plain"),
+		ResponseContentDisposition: aws.String("attachment"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = out.Body.Close()
+	if aws.ToString(out.ContentType) != "text/plain" || aws.ToString(out.ContentDisposition) != "attachment" {
+		t.Errorf("signed GetObject: expected response overrides, got %s, %s",
+			aws.ToString(out.ContentType), aws.ToString(out.ContentDisposition))
+	}
+
+	for _, cas := range []struct {
+		Name     string
+		Provider aws.CredentialsProvider
+		Code     string
+	}{
+		{"anonymous", aws.AnonymousCredentials{}, "AccessDenied"},
+		{"wrong secret", staticCredentials("AKIDEXAMPLE", "wrong"), "SignatureDoesNotMatch"},
+		{"unknown key", staticCredentials("AKIDOTHER", "secret"), "InvalidAccessKeyId"},
+	} {
+		_, err := s3Client(server, cas.Provider).GetObject(ctx, input)
+		if errorCode(err) != cas.Code {
+			t.Errorf("%s: expected %s, got %v", cas.Name, cas.Code, err)
+		}
+	}
+
+	presigned, err := s3.NewPresignClient(client).PresignGetObject(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, body := do(t, http.MethodGet, presigned.URL, nil)
+	if resp.StatusCode != http.StatusOK || body != "a,b\n" {
+		t.Errorf("presigned: unexpected response: %d, '%s'", resp.StatusCode, body)
+	}
+	tampered := strings.Replace(presigned.URL, "a%20b.csv", "x.csv", 1)
+	if resp, _ := do(t, http.MethodGet, tampered, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("tampered: expected 403, got %d", resp.StatusCode)
+	}
+	// move the signing date (in both X-Amz-Date and the credential scope) to the past
+	expired := regexp.MustCompile(`\d{8}(T\d{6}Z)`).ReplaceAllString(presigned.URL, "20200101$1")
+	expired = strings.Replace(expired, time.Now().UTC().Format("20060102")+"%2F", "20200101%2F", 1)
+	if resp, body := do(t, http.MethodGet, expired, nil); resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "Request has expired") {
+		t.Errorf("expired: unexpected response: %d, '%s'", resp.StatusCode, body)
+	}
+	// and to the future, where it would outlive its expiry
+	future := time.Now().UTC().Add(2 * time.Hour)
+	postdated := regexp.MustCompile(`\d{8}T\d{6}Z`).ReplaceAllString(presigned.URL, future.Format("20060102T150405Z"))
+	postdated = strings.Replace(postdated, time.Now().UTC().Format("20060102")+"%2F", future.Format("20060102")+"%2F", 1)
+	if resp, body := do(t, http.MethodGet, postdated, nil); resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "RequestTimeTooSkewed") {
+		t.Errorf("postdated: unexpected response: %d, '%s'", resp.StatusCode, body)
+	}
+}
+
+func TestS3Handler_ListAboveArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a.zip", "logs/2024.zip", "logs/2025.zip", "logs/old/2023.zip"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestArchive(t, filepath.Join(dir, p), content)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "logs", "notes.txt"), []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(proxy.NewS3Handler(cloudzip.NewClient(), map[string]string{"data": "file://" + dir}))
+	t.Cleanup(server.Close)
+	client := s3Client(server, aws.AnonymousCredentials{})
+	cases := []struct {
+		Name     string
+		Input    *s3.ListObjectsV2Input
+		Prefixes []string
+		Pages    int
+	}{
+		{"bucket root", &s3.ListObjectsV2Input{}, []string{"a.zip/", "logs/"}, 1},
+		{"directory", &s3.ListObjectsV2Input{Prefix: aws.String("logs/")}, []string{"logs/2024.zip/", "logs/2025.zip/", "logs/old/"}, 1},
+		{"partial name", &s3.ListObjectsV2Input{Prefix: aws.String("logs/202")}, []string{"logs/2024.zip/", "logs/2025.zip/"}, 1},
+		{"paginated", &s3.ListObjectsV2Input{Prefix: aws.String("logs/"), MaxKeys: aws.Int32(2)}, []string{"logs/2024.zip/", "logs/2025.zip/", "logs/old/"}, 2},
+		{"missing directory", &s3.ListObjectsV2Input{Prefix: aws.String("missing/")}, nil, 1},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			cas.Input.Bucket = aws.String("data")
+			cas.Input.Delimiter = aws.String("/")
+			keys, prefixes, pages := listAll(t, client, cas.Input)
+			if len(keys) != 0 || !reflect.DeepEqual(prefixes, cas.Prefixes) {
+				t.Errorf("expected common prefixes %v, got %v (and keys %v)", cas.Prefixes, prefixes, keys)
+			}
+			if pages != cas.Pages {
+				t.Errorf("expected %d pages, got %d", cas.Pages, pages)
+			}
+		})
+	}
+	_, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("data"), Prefix: aws.String("logs/")})
+	if errorCode(err) != "NotImplemented" {
+		t.Errorf("expected listing above archives without a delimiter to be rejected, got %v", err)
+	}
+}
+
+func TestS3Handler_AccessList(t *testing.T) {
+	rule, err := proxy.ParseAccessRule("*.zip:site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := s3TestServer(t, proxy.WithAccessList(&proxy.AccessList{Deny: []*proxy.AccessRule{rule}}))
+	client := s3Client(server, aws.AnonymousCredentials{})
+	_, err = client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String("data"), Key: aws.String("archive.zip/site/index.html")})
+	if errorCode(err) != "AccessDenied" {
+		t.Errorf("expected AccessDenied, got %v", err)
+	}
+	keys, prefixes, _ := listAll(t, client, &s3.ListObjectsV2Input{Bucket: aws.String("data"), Prefix: aws.String("archive.zip/"), Delimiter: aws.String("/")})
+	if len(keys) != 2 || !reflect.DeepEqual(prefixes, []string{"archive.zip/data/"}) {
+		t.Errorf("expected denied members not to be listed, got %v, %v", keys, prefixes)
+	}
+}
+
+func TestS3Handler_PathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestArchive(t, filepath.Join(dir, "secret.zip"), "secret")
+	if err := os.Mkdir(filepath.Join(dir, "root"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestArchive(t, filepath.Join(dir, "root", "archive.zip"), content)
+	handler := proxy.NewS3Handler(cloudzip.NewClient(), map[string]string{"data": "file://" + filepath.Join(dir, "root")})
+	for _, p := range []string{
+		"/data/../secret.zip/stored.txt",
+		"/data/x/../../secret.zip/stored.txt",
+		"/data/archive.zip//stored.txt",
+		"/data?list-type=2&prefix=../secret.zip/",
+	} {
+		// sent as is, without the cleaning done by http.ServeMux
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidArgument") {
+			t.Errorf("%s: expected InvalidArgument, got %d %s", p, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/data/archive.zip/stored.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected clean keys to be served, got %d", w.Code)
+	}
+}
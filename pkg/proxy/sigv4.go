@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4Terminator = "aws4_request"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// emptyPayloadHash is the SHA-256 of an empty body, as GET and HEAD requests have
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxRequestSkew is how far the time a request was signed at may be from the server's clock
+	maxRequestSkew = 15 * time.Minute
+	// maxPresignExpiry is the longest a presigned URL may be valid for
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// sigV4Request holds the parts of a request signed using AWS Signature Version 4,
+// taken from either its Authorization header or the query arguments of a presigned URL
+type sigV4Request struct {
+	accessKeyID   string
+	scope         string
+	signedHeaders []string
+	signature     string
+	date          time.Time
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+// parseSigV4 extracts the signature of r, returning an *s3Error if it is missing or malformed
+func parseSigV4(r *http.Request) (*sigV4Request, error) {
+	var req *sigV4Request
+	var err error
+	if r.URL.Query().Has("X-Amz-Signature") {
+		req, err = parsePresigned(r.URL.Query())
+	} else {
+		req, err = parseAuthorization(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.date, err = time.Parse(sigV4TimeFormat, req.amzDate)
+	if err != nil {
+		return nil, errAuthorizationMalformed("invalid X-Amz-Date: " + req.amzDate)
+	}
+	// the scope is <access key ID>/<date>/<region>/<service>/aws4_request
+	parts := strings.Split(req.scope, "/")
+	if len(parts) != 5 || parts[3] != "s3" || parts[4] != sigV4Terminator {
+		return nil, errAuthorizationMalformed("invalid credential scope: " + req.scope)
+	}
+	if parts[1] != req.amzDate[:8] {
+		return nil, errAuthorizationMalformed("credential scope date doesn't match X-Amz-Date")
+	}
+	req.accessKeyID = parts[0]
+	req.scope = strings.Join(parts[1:], "/")
+	if !containsString(req.signedHeaders, "host") {
+		return nil, errAuthorizationMalformed("the host header must be signed")
+	}
+	return req, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAuthorization parses an "Authorization: AWS4-HMAC-SHA256 Credential=..., SignedHeaders=..., Signature=..." header
+func parseAuthorization(r *http.Request) (*sigV4Request, error) {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, errAccessDenied
+	}
+	params, found := strings.CutPrefix(authorization, sigV4Algorithm+" ")
+	if !found {
+		return nil, errAuthorizationMalformed("unsupported authorization type, expected " + sigV4Algorithm)
+	}
+	req := &sigV4Request{
+		amzDate:     r.Header.Get("X-Amz-Date"),
+		payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch key {
+		case "Credential":
+			req.scope = value
+		case "SignedHeaders":
+			req.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			req.signature = value
+		}
+	}
+	if req.scope == "" || len(req.signedHeaders) == 0 || req.signature == "" {
+		return nil, errAuthorizationMalformed("the authorization header is missing Credential, SignedHeaders or Signature")
+	}
+	if req.payloadHash == "" {
+		req.payloadHash = emptyPayloadHash
+	}
+	return req, nil
+}
+
+// parsePresigned parses the X-Amz-* query arguments of a presigned URL
+func parsePresigned(query url.Values) (*sigV4Request, error) {
+	if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, errAuthorizationMalformed("unsupported X-Amz-Algorithm, expected " + sigV4Algorithm)
+	}
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxPresignExpiry {
+		return nil, errAuthorizationMalformed("X-Amz-Expires must be a number of seconds, up to a week")
+	}
+	req := &sigV4Request{
+		scope:         query.Get("X-Amz-Credential"),
+		signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     query.Get("X-Amz-Signature"),
+		amzDate:       query.Get("X-Amz-Date"),
+		payloadHash:   query.Get("X-Amz-Content-Sha256"),
+		presigned:     true,
+		expires:       time.Duration(seconds) * time.Second,
+	}
+	if req.payloadHash == "" {
+		req.payloadHash = unsignedPayload
+	}
+	return req, nil
+}
+
+// verifySigV4 checks that r was signed by one of credentials (secret access keys by access key ID)
+// using AWS Signature Version 4, as an Authorization header or a presigned URL.
+// It returns an *s3Error describing why verification failed, if it did.
+func verifySigV4(credentials map[string]string, r *http.Request, now time.Time) error {
+	req, err := parseSigV4(r)
+	if err != nil {
+		return err
+	}
+	secret, ok := credentials[req.accessKeyID]
+	if !ok {
+		return errInvalidAccessKeyID
+	}
+	if req.presigned {
+		if now.After(req.date.Add(req.expires)) {
+			return errPresignExpired
+		}
+		// a URL dated in the future would stay valid for longer than its expiry
+		if req.date.Sub(now) > maxRequestSkew {
+			return errRequestTimeTooSkewed
+		}
+	} else if skew := now.Sub(req.date); skew > maxRequestSkew || skew < -maxRequestSkew {
+		return errRequestTimeTooSkewed
+	}
+	expected := sigV4Signature(secret, req, canonicalRequest(r, req))
+	if !hmac.Equal([]byte(expected), []byte(req.signature)) {
+		return errSignatureDoesNotMatch
+	}
+	return nil
+}
+
+// canonicalRequest builds the canonical form of r that its signature covers
+func canonicalRequest(r *http.Request, req *sigV4Request) string {
+	canonicalURI := awsURIEncode(r.URL.Path, false)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	var query []string
+	for key, values := range r.URL.Query() {
+		if req.presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			query = append(query, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(query)
+
+	var headers strings.Builder
+	for _, name := range req.signedHeaders {
+		headers.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		canonicalURI,
+		strings.Join(query, "&"),
+		headers.String(),
+		strings.Join(req.signedHeaders, ";"),
+		req.payloadHash,
+	}, "\n")
+}
+
+// canonicalHeaderValue returns the values of the named header, trimmed and with sequential spaces collapsed
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		// net/http moves the Host header to r.Host
+		values = []string{r.Host}
+	case "content-length":
+		// and drops Content-Length, leaving r.ContentLength
+		if r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// sigV4Signature signs canonicalRequest with a key derived from secret for the request's scope
+func sigV4Signature(secret string, req *sigV4Request, canonicalRequest string) string {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		req.amzDate,
+		req.scope,
+		hex.EncodeToString(hashed[:]),
+	}, "\n")
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(req.scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes every byte of s other than unreserved characters (and, unless encodeSlash
+// is set, slashes), with upper case hex digits, as signed requests are
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,304 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// s3MinPartSize is the minimal size of all but the last part of a multipart upload
+	s3MinPartSize = 5 * 1024 * 1024
+	// s3CopyPartSize is the size of the parts the kept prefix is copied in (UploadPartCopy allows up to 5GiB)
+	s3CopyPartSize = 1024 * 1024 * 1024
+)
+
+var ErrConcurrentModification = errors.New("object was modified concurrently")
+
+// S3AppendAPI is the subset of the S3 API used to rewrite an object while keeping a prefix of it in place
+type S3AppendAPI interface {
+	S3Getter
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	UploadPartCopy(context.Context, *s3.UploadPartCopyInput, ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+func s3IsPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// NewAppender returns a Writer that replaces the object at uri with its first keep bytes,
+// followed by everything written to it. The object is replaced atomically on Close.
+// Where supported (S3), the kept bytes are copied server-side and never downloaded.
+// Pass WithIfMatch to fail if the object was modified since the kept bytes were read.
+func NewAppender(ctx context.Context, uri string, keep int64, opts ...WriterOpt) (Writer, error) {
+	cfg := &writerConfig{
+		partSize:    DefaultPartSize,
+		concurrency: DefaultUploadConcurrency,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, ErrInvalidURI
+	}
+	switch parsed.Scheme {
+	case "s3", "S3", "s3a":
+		s3uri, err := s3parseUri(uri)
+		if err != nil {
+			return nil, err
+		}
+		var client S3AppendAPI
+		if c, ok := cfg.s3Client.(S3AppendAPI); ok {
+			client = c
+		} else {
+			client, err = s3clientForBucket(ctx, s3uri.Bucket)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewS3Appender(ctx, client, s3uri.Bucket, s3uri.Path, keep, cfg.partSize, cfg.ifMatch)
+	case "local", "file":
+		filePath, err := localParseUri(uri)
+		if err != nil {
+			return nil, err
+		}
+		return NewLocalAppender(filePath, keep, cfg.ifMatch)
+	}
+	return nil, fmt.Errorf("%w: unsupported scheme for appending: %s", ErrInvalidURI, parsed.Scheme)
+}
+
+// NewLocalAppender copies the first keep bytes of the file at destination into a temporary file,
+// which is then written to and renamed into place on Close. Unless ifMatch is empty, the file's ETag
+// (see LocalFetcher.Stat) must match it, otherwise its current ETag is used: Close fails rather than
+// replace the file if it was modified in between.
+func NewLocalAppender(destination string, keep int64, ifMatch string) (*LocalWriter, error) {
+	source, err := os.Open(destination)
+	if os.IsNotExist(err) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = source.Close() }()
+	stat, err := source.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch != "" && localETag(stat) != ifMatch {
+		return nil, fmt.Errorf("%w: %s", ErrConcurrentModification, destination)
+	}
+	w, err := NewLocalWriter(destination)
+	if err != nil {
+		return nil, err
+	}
+	w.ifMatch = localETag(stat)
+	_ = os.Chmod(w.f.Name(), stat.Mode().Perm())
+	if _, err := io.CopyN(w.f, source, keep); err != nil {
+		_ = w.Abort()
+		return nil, fmt.Errorf("%w: %s is shorter than %d bytes", ErrInvalidRange, destination, keep)
+	}
+	return w, nil
+}
+
+// S3Appender rewrites an object as a multipart upload: the kept prefix is copied with UploadPartCopy,
+// new data is uploaded in parts of partSize as it is written. Completing the upload atomically replaces
+// the object. Both the copy and the completion are conditional on the object's ETag, so concurrent
+// modifications fail the upload rather than being overwritten.
+type S3Appender struct {
+	ctx      context.Context
+	client   S3AppendAPI
+	bucket   string
+	key      string
+	uploadId *string
+	etag     *string
+	partSize int64
+	parts    []types.CompletedPart
+	buf      *bytes.Buffer
+	done     bool
+}
+
+// NewS3Appender starts rewriting the object at bucket/key, keeping its first keep bytes. Unless ifMatch is empty,
+// the object's ETag must match it (for the whole copy), otherwise the object's current ETag is used.
+func NewS3Appender(ctx context.Context, client S3AppendAPI, bucket, key string, keep, partSize int64, ifMatch string) (*S3Appender, error) {
+	partSize = max(partSize, s3MinPartSize)
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if s3IsNotFoundErr(err) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	etag := head.ETag
+	if ifMatch != "" {
+		if aws.ToString(head.ETag) != ifMatch {
+			return nil, fmt.Errorf("%w: s3://%s/%s", ErrConcurrentModification, bucket, key)
+		}
+		etag = aws.String(ifMatch)
+	}
+	if keep > aws.ToInt64(head.ContentLength) {
+		return nil, fmt.Errorf("%w: object is shorter than %d bytes", ErrInvalidRange, keep)
+	}
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	a := &S3Appender{
+		ctx:      ctx,
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		uploadId: upload.UploadId,
+		etag:     etag,
+		partSize: partSize,
+		buf:      &bytes.Buffer{},
+	}
+	if err := a.copyPrefix(keep); err != nil {
+		_ = a.Abort()
+		return nil, err
+	}
+	return a, nil
+}
+
+// copyPrefix copies the first keep bytes of the object into the upload
+func (a *S3Appender) copyPrefix(keep int64) error {
+	if keep < s3MinPartSize {
+		// too small to be a part on its own, download it and upload it with the data that follows
+		if keep == 0 {
+			return nil
+		}
+		response, err := a.client.GetObject(a.ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(a.bucket),
+			Key:     aws.String(a.key),
+			Range:   aws.String(fmt.Sprintf("bytes=0-%d", keep-1)),
+			IfMatch: a.etag,
+		})
+		if s3IsPreconditionFailed(err) {
+			return fmt.Errorf("%w: %w", ErrConcurrentModification, err)
+		} else if err != nil {
+			return err
+		}
+		defer func() { _ = response.Body.Close() }()
+		_, err = io.CopyN(a.buf, response.Body, keep)
+		return err
+	}
+	copySource := fmt.Sprintf("%s/%s", a.bucket, url.PathEscape(a.key))
+	for start := int64(0); start < keep; {
+		end := min(start+s3CopyPartSize, keep)
+		if keep-end < s3MinPartSize {
+			// fold a remainder too small to be a part of its own into this part
+			end = keep
+		}
+		partNumber := int32(len(a.parts) + 1)
+		response, err := a.client.UploadPartCopy(a.ctx, &s3.UploadPartCopyInput{
+			Bucket:            aws.String(a.bucket),
+			Key:               aws.String(a.key),
+			UploadId:          a.uploadId,
+			PartNumber:        aws.Int32(partNumber),
+			CopySource:        aws.String(copySource),
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+			CopySourceIfMatch: a.etag,
+		})
+		if s3IsPreconditionFailed(err) {
+			return fmt.Errorf("%w: %w", ErrConcurrentModification, err)
+		} else if err != nil {
+			return err
+		}
+		a.parts = append(a.parts, types.CompletedPart{
+			ETag:       response.CopyPartResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+		start = end
+	}
+	return nil
+}
+
+func (a *S3Appender) uploadPart(data []byte) error {
+	partNumber := int32(len(a.parts) + 1)
+	response, err := a.client.UploadPart(a.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(a.bucket),
+		Key:        aws.String(a.key),
+		UploadId:   a.uploadId,
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+	a.parts = append(a.parts, types.CompletedPart{ETag: response.ETag, PartNumber: aws.Int32(partNumber)})
+	return nil
+}
+
+func (a *S3Appender) Write(p []byte) (int, error) {
+	if a.done {
+		return 0, ErrWriterClosed
+	}
+	n, _ := a.buf.Write(p)
+	for int64(a.buf.Len()) >= a.partSize {
+		if err := a.uploadPart(a.buf.Next(int(a.partSize))); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close uploads the remaining data and completes the upload, replacing the object unless it was modified
+// since the appender was created
+func (a *S3Appender) Close() error {
+	if a.done {
+		return ErrWriterClosed
+	}
+	if a.buf.Len() > 0 || len(a.parts) == 0 {
+		if err := a.uploadPart(a.buf.Bytes()); err != nil {
+			_ = a.Abort()
+			return err
+		}
+	}
+	a.done = true
+	_, err := a.client.CompleteMultipartUpload(a.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(a.bucket),
+		Key:             aws.String(a.key),
+		UploadId:        a.uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: a.parts},
+		IfMatch:         a.etag,
+	})
+	if err != nil {
+		a.done = false
+		_ = a.Abort()
+	}
+	if s3IsPreconditionFailed(err) {
+		return fmt.Errorf("%w: %w", ErrConcurrentModification, err)
+	}
+	return err
+}
+
+// Abort discards the upload, leaving the object unchanged
+func (a *S3Appender) Abort() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	_, err := a.client.AbortMultipartUpload(context.WithoutCancel(a.ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(a.bucket),
+		Key:      aws.String(a.key),
+		UploadId: a.uploadId,
+	})
+	return err
+}
@@ -0,0 +1,382 @@
+package remote_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+// fakeS3 is an in-memory implementation of the multipart upload API
+type fakeS3 struct {
+	l       sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int32][]byte
+	copied  int64 // bytes copied server side
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int32][]byte)}
+}
+
+func (f *fakeS3) etag(key string) *string {
+	return aws.String(strconv.Itoa(len(f.objects[key])))
+}
+
+func parseRange(rng string) (int64, int64) {
+	var start, end int64
+	_, _ = fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+	return start, end
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if in.IfMatch != nil && *in.IfMatch != *f.etag(*in.Key) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	if in.Range != nil {
+		start, end := parseRange(*in.Range)
+		data = data[start : end+1]
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(_ context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	prefix, after, token := aws.ToString(in.Prefix), aws.ToString(in.StartAfter), aws.ToString(in.ContinuationToken)
+	maxKeys := int(aws.ToInt32(in.MaxKeys))
+	if maxKeys == 0 {
+		maxKeys = 1000
+	}
+	out := &s3.ListObjectsV2Output{}
+	var last string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after || key <= token || strings.HasSuffix(token, "/") && strings.HasPrefix(key, token) {
+			continue
+		}
+		item, isPrefix := key, false
+		if i := strings.Index(key[len(prefix):], "/"); i >= 0 && aws.ToString(in.Delimiter) == "/" {
+			item, isPrefix = key[:len(prefix)+i+1], true
+		}
+		if item == last {
+			continue
+		}
+		if len(out.Contents)+len(out.CommonPrefixes) == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(last)
+			break
+		}
+		last = item
+		if isPrefix {
+			out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(item)})
+		} else {
+			out.Contents = append(out.Contents, types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(f.objects[key])))})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data))), ETag: f.etag(*in.Key)}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(_ context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	id := strconv.Itoa(len(f.uploads))
+	f.uploads[id] = make(map[int32][]byte)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(_ context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.uploads[*in.UploadId][*in.PartNumber] = data
+	return &s3.UploadPartOutput{ETag: aws.String(strconv.Itoa(int(*in.PartNumber)))}, nil
+}
+
+func (f *fakeS3) UploadPartCopy(_ context.Context, in *s3.UploadPartCopyInput, _ ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	key := strings.SplitN(*in.CopySource, "/", 2)[1]
+	if aws.ToString(in.CopySourceIfMatch) != *f.etag(key) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	start, end := parseRange(*in.CopySourceRange)
+	f.uploads[*in.UploadId][*in.PartNumber] = f.objects[key][start : end+1]
+	f.copied += end + 1 - start
+	return &s3.UploadPartCopyOutput{CopyPartResult: &types.CopyPartResult{ETag: aws.String(strconv.Itoa(int(*in.PartNumber)))}}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if in.IfMatch != nil && *in.IfMatch != *f.etag(*in.Key) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	parts := f.uploads[*in.UploadId]
+	numbers := make([]int, 0, len(parts))
+	for _, part := range in.MultipartUpload.Parts {
+		numbers = append(numbers, int(*part.PartNumber))
+	}
+	sort.Ints(numbers)
+	object := make([]byte, 0)
+	for i, n := range numbers {
+		if i < len(numbers)-1 && len(parts[int32(n)]) < 5*1024*1024 {
+			return nil, fmt.Errorf("EntityTooSmall: part %d", n)
+		}
+		object = append(object, parts[int32(n)]...)
+	}
+	f.objects[*in.Key] = object
+	delete(f.uploads, *in.UploadId)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(_ context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	delete(f.uploads, *in.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestS3Appender(t *testing.T) {
+	const mib = 1024 * 1024
+	cases := []struct {
+		Name       string
+		ObjectSize int
+		Keep       int64
+		Append     int
+		Copied     int64
+	}{
+		{"small prefix is re-uploaded", 3 * mib, 2 * mib, 100, 0},
+		{"large prefix is copied", 12 * mib, 11 * mib, 7 * mib, 11 * mib},
+		{"nothing kept", 1 * mib, 0, 100, 0},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			client := newFakeS3()
+			original := bytes.Repeat([]byte("0123456789"), cas.ObjectSize/10)
+			client.objects["archive.zip"] = original
+			a, err := remote.NewS3Appender(context.Background(), client, "bucket", "archive.zip", cas.Keep, 5*mib, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			appended := bytes.Repeat([]byte("x"), cas.Append)
+			if _, err := a.Write(appended); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(client.objects["archive.zip"], original) {
+				t.Fatalf("object modified before Close")
+			}
+			if err := a.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := append(append([]byte{}, original[:cas.Keep]...), appended...)
+			if !bytes.Equal(client.objects["archive.zip"], expected) {
+				t.Errorf("unexpected object content (%d bytes, expected %d)", len(client.objects["archive.zip"]), len(expected))
+			}
+			if client.copied != cas.Copied {
+				t.Errorf("expected %d bytes copied server side, got %d", cas.Copied, client.copied)
+			}
+			if len(client.uploads) != 0 {
+				t.Errorf("expected no pending uploads")
+			}
+		})
+	}
+}
+
+func TestS3Appender_Abort(t *testing.T) {
+	client := newFakeS3()
+	client.objects["archive.zip"] = []byte("original")
+	a, err := remote.NewS3Appender(context.Background(), client, "bucket", "archive.zip", 4, 0, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = a.Write([]byte("new"))
+	if err := a.Abort(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(client.objects["archive.zip"]) != "original" || len(client.uploads) != 0 {
+		t.Errorf("expected object to be left unchanged")
+	}
+}
+
+// racingS3 replaces the object right after it is inspected by HeadObject
+type racingS3 struct {
+	*fakeS3
+}
+
+func (f *racingS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	head, err := f.fakeS3.HeadObject(ctx, in, opts...)
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.objects[*in.Key] = append(f.objects[*in.Key], "modified"...)
+	return head, err
+}
+
+func TestS3Appender_ConcurrentModification(t *testing.T) {
+	const mib = 1024 * 1024
+	cases := []struct {
+		Name    string
+		Keep    int64
+		IfMatch string
+		Racing  bool
+	}{
+		{"small prefix modified while copied", 2 * mib, "", true},
+		{"large prefix modified while copied", 11 * mib, "", true},
+		{"modified since read", 11 * mib, `"stale"`, false},
+		{"nothing kept, modified since read", 0, `"stale"`, false},
+	}
+	for _, cas := range cases {
+		t.Run(cas.Name, func(t *testing.T) {
+			fake := newFakeS3()
+			fake.objects["archive.zip"] = bytes.Repeat([]byte("0123456789"), 12*mib/10)
+			var client remote.S3AppendAPI = fake
+			if cas.Racing {
+				client = &racingS3{fake}
+			}
+			_, err := remote.NewS3Appender(context.Background(), client, "bucket", "archive.zip", cas.Keep, 5*mib, cas.IfMatch)
+			if !errors.Is(err, remote.ErrConcurrentModification) {
+				t.Fatalf("expected ErrConcurrentModification, got %v", err)
+			}
+			if len(fake.uploads) != 0 {
+				t.Errorf("expected no pending uploads")
+			}
+		})
+	}
+}
+
+func TestS3Appender_ModifiedBeforeClose(t *testing.T) {
+	const mib = 1024 * 1024
+	for _, keep := range []int64{2 * mib, 11 * mib} {
+		client := newFakeS3()
+		client.objects["archive.zip"] = bytes.Repeat([]byte("0123456789"), 12*mib/10)
+		a, err := remote.NewS3Appender(context.Background(), client, "bucket", "archive.zip", keep, 5*mib, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := a.Write([]byte("new")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.objects["archive.zip"] = []byte("replaced")
+		if err := a.Close(); !errors.Is(err, remote.ErrConcurrentModification) {
+			t.Fatalf("expected ErrConcurrentModification, got %v", err)
+		}
+		if string(client.objects["archive.zip"]) != "replaced" || len(client.uploads) != 0 {
+			t.Errorf("expected the replaced object to be left unchanged")
+		}
+	}
+}
+
+func TestLocalAppender(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := remote.NewAppender(context.Background(), "file://"+p, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.Write([]byte("abc")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123abc" {
+		t.Errorf("expected '0123abc', got '%s'", data)
+	}
+}
+
+func TestLocalAppender_ConcurrentModification(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := remote.Object("file://" + p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := remote.Stat(context.Background(), f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(p, []byte("0123456789modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.NewAppender(context.Background(), "file://"+p, 4, remote.WithIfMatch(info.ETag))
+	if !errors.Is(err, remote.ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+}
+
+func TestLocalAppender_ModifiedBeforeClose(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "archive.zip")
+	if err := os.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := remote.NewAppender(context.Background(), "file://"+p, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := a.Write([]byte("abc")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(p, []byte("replaced"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); !errors.Is(err, remote.ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "replaced" {
+		t.Errorf("expected the replaced file to be left unchanged, got '%s'", data)
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(p), ".archive.zip.*"))
+	if len(matches) != 0 {
+		t.Errorf("expected the temporary file to be removed, found %v", matches)
+	}
+}
@@ -37,7 +37,7 @@ func Object(uri string, opts ...ObjectOpt) (Fetcher, error) {
 	for _, opt := range opts {
 		opt(f)
 	}
-	return f, nil
+	return Instrument(f, Backend(uri)), nil
 }
 
 func getObject(uri string) (Fetcher, error) {
@@ -3,6 +3,12 @@ package remote
 import "errors"
 
 var (
-	ErrInvalidURI   = errors.New("invalid URI")
-	ErrDoesNotExist = errors.New("object does not exist")
+	ErrInvalidURI      = errors.New("invalid URI")
+	ErrDoesNotExist    = errors.New("object does not exist")
+	ErrSizeUnknown     = errors.New("object size unknown")
+	ErrInvalidRange    = errors.New("invalid range")
+	ErrListUnsupported = errors.New("listing is not supported")
+	ErrTooManyFetches  = errors.New("too many concurrent fetches")
+	// ErrPreconditionFailed is returned by fetches pinned to an ETag (see ContextWithIfMatch) the object no longer has
+	ErrPreconditionFailed = errors.New("object was replaced")
 )
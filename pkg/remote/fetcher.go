@@ -4,12 +4,131 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Fetcher interface {
 	Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error)
 }
 
+type ifMatchKey struct{}
+
+// ContextWithIfMatch returns a copy of ctx pinning fetches made with it to the given ETag: once the object
+// no longer has it, they fail with ErrPreconditionFailed. This keeps offsets read from an object from being
+// used to read a replaced one. Fetchers that can't check an object's ETag ignore it.
+func ContextWithIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// ifMatch returns the ETag fetches made with ctx are pinned to, if any
+func ifMatch(ctx context.Context) string {
+	etag, _ := ctx.Value(ifMatchKey{}).(string)
+	return etag
+}
+
+// Sizer is implemented by fetchers that can tell the total size of the object they fetch
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// Size returns the size of the object fetched by f, if f implements Sizer
+func Size(ctx context.Context, f Fetcher) (int64, error) {
+	if s, ok := f.(Sizer); ok {
+		return s.Size(ctx)
+	}
+	return 0, ErrSizeUnknown
+}
+
+// ObjectInfo describes the object fetched by a Fetcher. ETag and LastModified are empty when unknown.
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// Stater is implemented by fetchers that can describe the object they fetch
+type Stater interface {
+	Stat(ctx context.Context) (*ObjectInfo, error)
+}
+
+// Stat returns information about the object fetched by f: everything known if f implements Stater,
+// otherwise just its size
+func Stat(ctx context.Context, f Fetcher) (*ObjectInfo, error) {
+	if s, ok := f.(Stater); ok {
+		return s.Stat(ctx)
+	}
+	size, err := Size(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Size: size}, nil
+}
+
+// ListEntry is an object, or a common prefix (a "directory"), directly under a listed prefix
+type ListEntry struct {
+	// Name is relative to the listed prefix, without a trailing slash
+	Name         string
+	IsPrefix     bool
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// Key returns what entries are ordered by, and listings resumed after: the name, followed by "/" for prefixes
+// (as the keys of objects under them would be)
+func (e *ListEntry) Key() string {
+	if e.IsPrefix {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// Lister is implemented by fetchers that can list the objects under their URI, taken as a prefix
+// (a "directory"). List returns up to limit of the entries directly under it (all of them if limit
+// isn't positive), ordered by Key, starting with the first whose Key sorts after after.
+type Lister interface {
+	List(ctx context.Context, after string, limit int) ([]*ListEntry, error)
+}
+
+// List returns a page of the entries under the prefix fetched by f (see Lister), if f implements Lister
+func List(ctx context.Context, f Fetcher, after string, limit int) ([]*ListEntry, error) {
+	if l, ok := f.(Lister); ok {
+		return l.List(ctx, after, limit)
+	}
+	return nil, ErrListUnsupported
+}
+
+// pageEntries orders entries by Key, returning up to limit of those after after
+func pageEntries(entries []*ListEntry, after string, limit int) []*ListEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key() < entries[j].Key()
+	})
+	start := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Key() > after
+	})
+	entries = entries[start:]
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// parseContentRangeSize returns the complete length from a Content-Range header value ("bytes 0-0/1234")
+func parseContentRangeSize(contentRange string) (int64, error) {
+	_, total, found := strings.Cut(contentRange, "/")
+	if !found || total == "*" {
+		return 0, fmt.Errorf("%w: Content-Range: '%s'", ErrSizeUnknown, contentRange)
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: Content-Range: '%s'", ErrSizeUnknown, contentRange)
+	}
+	return size, nil
+}
+
 func strPtr(s string) *string {
 	return &s
 }
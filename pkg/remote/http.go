@@ -3,9 +3,11 @@ package remote
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -41,6 +43,10 @@ func (h *HttpFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *
 		rangeHeaderStr = *rangeHeader
 		req.Header.Set("Range", rangeHeaderStr)
 	}
+	// If-Match requires strong comparison, which weak ETags never pass
+	if etag := ifMatch(ctx); etag != "" && !strings.HasPrefix(etag, "W/") {
+		req.Header.Set("If-Match", etag)
+	}
 	req = req.WithContext(ctx)
 	start := time.Now()
 	response, err := http.DefaultClient.Do(req)
@@ -53,6 +59,68 @@ func (h *HttpFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *
 		h.logger.WarnContext(ctx, "http.Get", "range", rangeHeaderStr, "url", h.url, "took_ms", tookMs, "error", "NotFound")
 		return nil, ErrDoesNotExist
 	}
+	if response.StatusCode == http.StatusPreconditionFailed {
+		_ = response.Body.Close()
+		h.logger.WarnContext(ctx, "http.Get", "range", rangeHeaderStr, "url", h.url, "took_ms", tookMs, "error", "PreconditionFailed")
+		return nil, ErrPreconditionFailed
+	}
 	h.logger.DebugContext(ctx, "http.Get", "range", rangeHeaderStr, "url", h.url, "took_ms", tookMs, "error", nil)
 	return response.Body, nil
 }
+
+func (h *HttpFetcher) Size(ctx context.Context) (int64, error) {
+	info, err := h.Stat(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (h *HttpFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return statRequest(req)
+}
+
+// sizeRequest issues req for the first byte of the object and returns the object's total size
+func sizeRequest(req *http.Request) (int64, error) {
+	info, err := statRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// statRequest issues req for the first byte of the object and returns the object's total size,
+// ETag and modification time. A ranged GET is used rather than HEAD, since pre-signed URLs are
+// typically only valid for GET.
+func statRequest(req *http.Request) (*ObjectInfo, error) {
+	req.Header.Set("Range", "bytes=0-0")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	info := &ObjectInfo{ETag: response.Header.Get("ETag")}
+	if lastModified, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = lastModified
+	}
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrDoesNotExist
+	case http.StatusPartialContent:
+		info.Size, err = parseContentRangeSize(response.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	case http.StatusOK:
+		if response.ContentLength >= 0 {
+			info.Size = response.ContentLength
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: got HTTP %d", ErrSizeUnknown, response.StatusCode)
+}
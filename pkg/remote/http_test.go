@@ -0,0 +1,38 @@
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func TestHttpFetcher_IfMatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "object", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer server.Close()
+	f, err := remote.NewHttpFetcher(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := f.Fetch(remote.ContextWithIfMatch(context.Background(), `"v1"`), int64p(2), int64p(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || string(data) != "234" {
+		t.Errorf("expected '234', got '%s' (%v)", data, err)
+	}
+	if _, err := f.Fetch(remote.ContextWithIfMatch(context.Background(), `"v0"`), int64p(2), int64p(4)); !errors.Is(err, remote.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+}
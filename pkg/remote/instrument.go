@@ -0,0 +1,110 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
+)
+
+// instrumentedFetcher records the requests made by a Fetcher, and the bytes read from their responses,
+// and traces each request as a span
+type instrumentedFetcher struct {
+	next    Fetcher
+	backend string
+}
+
+// Instrument returns a Fetcher recording metrics and spans for the requests made by f, labeled with backend.
+// Fetchers returned by Object are already instrumented.
+func Instrument(f Fetcher, backend string) Fetcher {
+	if _, ok := f.(*instrumentedFetcher); ok {
+		return f
+	}
+	return &instrumentedFetcher{next: f, backend: backend}
+}
+
+// Backend returns the name of the backend serving uri, as used to label metrics (s3, file, http, ...)
+func Backend(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "unknown"
+	}
+	switch parsed.Scheme {
+	case "S3", "s3a":
+		return "s3"
+	case "local":
+		return "file"
+	case "https":
+		return "http"
+	}
+	return parsed.Scheme
+}
+
+func (f *instrumentedFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (r io.ReadCloser, err error) {
+	attrs := []attribute.KeyValue{attribute.String("cz.backend", f.backend)}
+	if startOffset != nil {
+		attrs = append(attrs, attribute.Int64("cz.fetch.start", *startOffset))
+	}
+	if endOffset != nil {
+		attrs = append(attrs, attribute.Int64("cz.fetch.end", *endOffset))
+	}
+	ctx, span := tracing.Start(ctx, "remote.Fetch", attrs...)
+	defer func() { tracing.End(span, err) }()
+
+	inFlight := metrics.UpstreamFetchesInFlight.WithLabelValues(f.backend)
+	inFlight.Inc()
+	start := time.Now()
+	r, err = f.next.Fetch(ctx, startOffset, endOffset)
+	metrics.UpstreamFetchDuration.WithLabelValues(f.backend).Observe(time.Since(start).Seconds())
+	inFlight.Dec()
+	switch {
+	case errors.Is(err, ErrDoesNotExist):
+		metrics.UpstreamFetches.WithLabelValues(f.backend, "not_found").Inc()
+		return nil, err
+	case err != nil:
+		metrics.UpstreamFetches.WithLabelValues(f.backend, "error").Inc()
+		return nil, err
+	}
+	metrics.UpstreamFetches.WithLabelValues(f.backend, "ok").Inc()
+	return &countingReader{ReadCloser: r, bytes: metrics.UpstreamFetchBytes.WithLabelValues(f.backend)}, nil
+}
+
+func (f *instrumentedFetcher) Size(ctx context.Context) (int64, error) {
+	return Size(ctx, f.next)
+}
+
+func (f *instrumentedFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	return Stat(ctx, f.next)
+}
+
+func (f *instrumentedFetcher) List(ctx context.Context, after string, limit int) (entries []*ListEntry, err error) {
+	ctx, span := tracing.Start(ctx, "remote.List", attribute.String("cz.backend", f.backend))
+	defer func() { tracing.End(span, err) }()
+	return List(ctx, f.next, after, limit)
+}
+
+func (f *instrumentedFetcher) setLogger(logger *slog.Logger) {
+	if lf, ok := f.next.(CanSetLogger); ok {
+		lf.setLogger(logger)
+	}
+}
+
+// countingReader counts the bytes read from a response
+type countingReader struct {
+	io.ReadCloser
+	bytes prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes.Add(float64(n))
+	return n, err
+}
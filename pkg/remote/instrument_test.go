@@ -0,0 +1,58 @@
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+type missingFetcher struct{}
+
+func (missingFetcher) Fetch(context.Context, *int64, *int64) (io.ReadCloser, error) {
+	return nil, remote.ErrDoesNotExist
+}
+
+func TestInstrument(t *testing.T) {
+	f, err := remote.Object("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fetches := testutil.ToFloat64(metrics.UpstreamFetches.WithLabelValues("file", "ok"))
+	bytesRead := testutil.ToFloat64(metrics.UpstreamFetchBytes.WithLabelValues("file"))
+	r, err := f.Fetch(context.Background(), int64p(0), int64p(99))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = r.Close()
+	if got := testutil.ToFloat64(metrics.UpstreamFetches.WithLabelValues("file", "ok")) - fetches; got != 1 {
+		t.Errorf("expected 1 fetch, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.UpstreamFetchBytes.WithLabelValues("file")) - bytesRead; got != 100 {
+		t.Errorf("expected 100 bytes, got %v", got)
+	}
+	// instrumented fetchers still describe their objects
+	if size, err := remote.Size(context.Background(), f); err != nil || size != 446 {
+		t.Errorf("expected size 446, got %d (%v)", size, err)
+	}
+	if remote.Instrument(f, "file") != f {
+		t.Errorf("expected fetchers to be instrumented once")
+	}
+
+	missing := remote.Instrument(missingFetcher{}, "test")
+	notFound := testutil.ToFloat64(metrics.UpstreamFetches.WithLabelValues("test", "not_found"))
+	if _, err := missing.Fetch(context.Background(), nil, nil); !errors.Is(err, remote.ErrDoesNotExist) {
+		t.Fatalf("expected %v, got %v", remote.ErrDoesNotExist, err)
+	}
+	if got := testutil.ToFloat64(metrics.UpstreamFetches.WithLabelValues("test", "not_found")) - notFound; got != 1 {
+		t.Errorf("expected 1 failed fetch, got %v", got)
+	}
+}
@@ -109,26 +109,54 @@ func (k *KaggleFetcher) fetchDatasetUrl() (string, error) {
 	return response.Header.Get("Location"), nil
 }
 
-func (k *KaggleFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+func (k *KaggleFetcher) getDatasetUrl() (string, error) {
 	// ensure we have a valid url to use
 	const defaultRedirectExpiry = time.Minute * 5 // real URLs typically last for much longer
-	var datasetUrl string
 	k.l.Lock()
+	defer k.l.Unlock()
 	if k.cacheDatasetUrl != "" && !k.cacheExpiresAt.IsZero() {
 		if time.Now().Add(defaultRedirectExpiry).Before(k.cacheExpiresAt) {
-			datasetUrl = k.cacheDatasetUrl
+			return k.cacheDatasetUrl, nil
 		}
 	}
-	if datasetUrl == "" {
-		var err error
-		datasetUrl, err = k.fetchDatasetUrl()
-		if err != nil {
-			return nil, err
-		}
-		k.cacheDatasetUrl = datasetUrl
-		k.cacheExpiresAt = time.Now().Add(defaultRedirectExpiry)
+	datasetUrl, err := k.fetchDatasetUrl()
+	if err != nil {
+		return "", err
+	}
+	k.cacheDatasetUrl = datasetUrl
+	k.cacheExpiresAt = time.Now().Add(defaultRedirectExpiry)
+	return datasetUrl, nil
+}
+
+func (k *KaggleFetcher) Size(ctx context.Context) (int64, error) {
+	datasetUrl, err := k.getDatasetUrl()
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, datasetUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	return sizeRequest(req)
+}
+
+func (k *KaggleFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	datasetUrl, err := k.getDatasetUrl()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, datasetUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return statRequest(req)
+}
+
+func (k *KaggleFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	datasetUrl, err := k.getDatasetUrl()
+	if err != nil {
+		return nil, err
 	}
-	k.l.Unlock()
 
 	// now fetch the redirected location
 	req, err := http.NewRequest(http.MethodGet, datasetUrl, nil)
@@ -261,7 +261,7 @@ func (f *LakeFSFetcher) getURL(cfg *lakeFSConfig) (string, error) {
 	return f.cachedUrl, nil
 }
 
-func (f *LakeFSFetcher) directFetch(ctx context.Context, cfg *lakeFSConfig, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+func (f *LakeFSFetcher) directRequest(ctx context.Context, cfg *lakeFSConfig) (*http.Request, error) {
 	addr, err := parseLakeFSUri(f.uri)
 	if err != nil {
 		return nil, err
@@ -279,9 +279,49 @@ func (f *LakeFSFetcher) directFetch(ctx context.Context, cfg *lakeFSConfig, star
 	q.Add("path", addr.object)
 	q.Add("presign", "false")
 	req.URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+func (f *LakeFSFetcher) directFetch(ctx context.Context, cfg *lakeFSConfig, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	req, err := f.directRequest(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
 	return f.rangeRequest(req, startOffset, endOffset)
 }
 
+// request returns a GET request for the object, either pre-signed or through the lakeFS API
+func (f *LakeFSFetcher) request(ctx context.Context) (*http.Request, error) {
+	cfg, err := loadLakefsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !f.preSignSupported {
+		return f.directRequest(ctx, cfg)
+	}
+	zipUrl, err := f.getURL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, zipUrl, nil)
+}
+
+func (f *LakeFSFetcher) Size(ctx context.Context) (int64, error) {
+	req, err := f.request(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return sizeRequest(req)
+}
+
+func (f *LakeFSFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	req, err := f.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return statRequest(req)
+}
+
 func (f *LakeFSFetcher) rangeRequest(req *http.Request, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
 	rangeHeader := buildRange(startOffset, endOffset)
 	rangeHeaderStr := ""
@@ -305,23 +345,9 @@ func (f *LakeFSFetcher) rangeRequest(req *http.Request, startOffset *int64, endO
 }
 
 func (f *LakeFSFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
-	cfg, err := loadLakefsConfig()
-	if err != nil {
-		return nil, err
-	}
-	if !f.preSignSupported {
-		return f.directFetch(ctx, cfg, startOffset, endOffset)
-	}
-	zipUrl, err := f.getURL(cfg)
+	req, err := f.request(ctx)
 	if err != nil {
 		return nil, err
 	}
-	// make the actual request
-	req, err := http.NewRequest(http.MethodGet, zipUrl, nil)
-	if err != nil {
-		return nil, err
-	}
-	req = req.WithContext(ctx)
 	return f.rangeRequest(req, startOffset, endOffset)
-
 }
@@ -0,0 +1,118 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// FetchLimiter limits the number of requests made to remote storage at the same time,
+// by the fetchers it wraps. A fetch holds its slot until the returned reader is closed (or read to the end).
+type FetchLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewFetchLimiter returns a limiter allowing up to max concurrent fetches. A fetch waits up to wait
+// for a slot to be released, and otherwise fails with ErrTooManyFetches.
+func NewFetchLimiter(max int, wait time.Duration) *FetchLimiter {
+	return &FetchLimiter{
+		slots: make(chan struct{}, max),
+		wait:  wait,
+	}
+}
+
+// Wrap returns a Fetcher whose requests are limited by l. It can be used as a cloudzip.FetcherMiddleware.
+func (l *FetchLimiter) Wrap(f Fetcher) Fetcher {
+	return &limitedFetcher{next: f, limiter: l}
+}
+
+// acquire takes a slot, returning a function releasing it
+func (l *FetchLimiter) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-timer.C:
+		return nil, ErrTooManyFetches
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *FetchLimiter) release() {
+	<-l.slots
+}
+
+type limitedFetcher struct {
+	next    Fetcher
+	limiter *FetchLimiter
+}
+
+func (f *limitedFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	release, err := f.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := f.next.Fetch(ctx, startOffset, endOffset)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: r, release: release}, nil
+}
+
+func (f *limitedFetcher) Size(ctx context.Context) (int64, error) {
+	release, err := f.limiter.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return Size(ctx, f.next)
+}
+
+func (f *limitedFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	release, err := f.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return Stat(ctx, f.next)
+}
+
+func (f *limitedFetcher) List(ctx context.Context, after string, limit int) ([]*ListEntry, error) {
+	release, err := f.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return List(ctx, f.next, after, limit)
+}
+
+// limitedReader releases its fetch's slot once closed, or once read to the end
+type limitedReader struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.once.Do(r.release)
+	}
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
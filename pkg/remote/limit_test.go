@@ -0,0 +1,61 @@
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func TestFetchLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := remote.NewFetchLimiter(2, 10*time.Millisecond)
+	f, err := remote.NewLocalFetcher("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	limited := limiter.Wrap(f)
+	first, err := limited.Fetch(ctx, int64p(0), int64p(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := limited.Fetch(ctx, int64p(5), int64p(9))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := limited.Fetch(ctx, nil, nil); !errors.Is(err, remote.ErrTooManyFetches) {
+		t.Errorf("expected ErrTooManyFetches with all slots taken, got %v", err)
+	}
+	if _, err := remote.Stat(ctx, limited); !errors.Is(err, remote.ErrTooManyFetches) {
+		t.Errorf("expected Stat to be limited too, got %v", err)
+	}
+
+	// a slot is released once its reader is closed, even if closed twice
+	_ = first.Close()
+	_ = first.Close()
+	if size, err := remote.Size(ctx, limited); err != nil || size != 446 {
+		t.Errorf("expected size 446 once a slot was released, got %d (%v)", size, err)
+	}
+	third, err := limited.Fetch(ctx, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := limited.Fetch(canceled, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected waiting for a slot to stop once the context is canceled, got %v", err)
+	}
+
+	// reading to the end releases the slot too
+	if _, err := io.ReadAll(second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := limited.Fetch(ctx, nil, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_ = third.Close()
+}
@@ -2,12 +2,15 @@ package remote
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"syscall"
 )
 
@@ -20,13 +23,40 @@ type ReadSeekerCloser interface {
 type LocalFetcher struct {
 	handle ReadSeekerCloser
 	logger *slog.Logger
+	// path is the file (or directory, which can only be listed) the fetcher was created for, if any
+	path string
+
+	// set if handle supports positional reads, allowing concurrent readers
+	readerAt io.ReaderAt
+	size     int64
 }
 
-func NewLocalFetcherFromData(data ReadSeekerCloser) *LocalFetcher {
-	return &LocalFetcher{
-		handle: data,
+func newLocalFetcher(handle ReadSeekerCloser) (*LocalFetcher, error) {
+	f := &LocalFetcher{
+		handle: handle,
 		logger: DummyLogger(),
 	}
+	if readerAt, ok := handle.(io.ReaderAt); ok {
+		size, err := handle.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		f.readerAt = readerAt
+		f.size = size
+	}
+	return f, nil
+}
+
+func NewLocalFetcherFromData(data ReadSeekerCloser) *LocalFetcher {
+	f, err := newLocalFetcher(data)
+	if err != nil {
+		// can't determine size, fall back to sequential access
+		return &LocalFetcher{
+			handle: data,
+			logger: DummyLogger(),
+		}
+	}
+	return f
 }
 
 func NewLocalFetcher(uri string) (*LocalFetcher, error) {
@@ -34,6 +64,15 @@ func NewLocalFetcher(uri string) (*LocalFetcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &LocalFetcher{logger: DummyLogger(), path: filePath}, nil
+	}
 	handle, err := os.Open(filePath)
 	if os.IsNotExist(err) {
 		return nil, ErrDoesNotExist
@@ -41,24 +80,115 @@ func NewLocalFetcher(uri string) (*LocalFetcher, error) {
 		return nil, err
 	}
 
-	return &LocalFetcher{
-		handle: handle,
-		logger: DummyLogger(),
-	}, nil
+	f, err := newLocalFetcher(handle)
+	if err != nil {
+		return nil, err
+	}
+	f.path = filePath
+	return f, nil
+}
+
+// errIsDir is returned when reading from a fetcher created for a directory
+func (l *LocalFetcher) errIsDir() error {
+	return fmt.Errorf("%w: %s is a directory", ErrDoesNotExist, l.path)
+}
+
+// List returns a page of the files and directories in the directory the fetcher was created for
+func (l *LocalFetcher) List(_ context.Context, after string, limit int) ([]*ListEntry, error) {
+	if l.path == "" {
+		return nil, ErrListUnsupported
+	}
+	dirEntries, err := os.ReadDir(l.path)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	entries := make([]*ListEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name()+"/" <= after {
+			// sorts before after whether it is a directory or not
+			continue
+		}
+		// follow symlinks
+		fi, err := os.Stat(filepath.Join(l.path, dirEntry.Name()))
+		if err != nil {
+			l.logger.Debug("could not stat directory entry", "path", l.path, "name", dirEntry.Name(), "error", err)
+			continue
+		}
+		entry := &ListEntry{Name: dirEntry.Name(), IsPrefix: fi.IsDir()}
+		if !fi.IsDir() {
+			entry.Size = fi.Size()
+			entry.ETag = localETag(fi)
+			entry.LastModified = fi.ModTime()
+		}
+		entries = append(entries, entry)
+	}
+	return pageEntries(entries, after, limit), nil
 }
 
 func (l *LocalFetcher) setLogger(logger *slog.Logger) {
 	l.logger = logger
 }
 
-func (l *LocalFetcher) Fetch(_ context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+func (l *LocalFetcher) Size(_ context.Context) (int64, error) {
+	if l.handle == nil {
+		return 0, l.errIsDir()
+	}
+	if l.readerAt != nil {
+		return l.size, nil
+	}
+	return l.handle.Seek(0, io.SeekEnd)
+}
+
+// localETag derives an ETag for a file on disk from its modification time and size
+func localETag(fi fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// Stat returns the size of the file and, for files on disk, its modification time and an ETag derived from both
+func (l *LocalFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	if l.handle == nil {
+		return nil, l.errIsDir()
+	}
+	if statter, ok := l.handle.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		fi, err := statter.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return &ObjectInfo{
+			Size:         fi.Size(),
+			ETag:         localETag(fi),
+			LastModified: fi.ModTime(),
+		}, nil
+	}
+	size, err := l.Size(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Size: size}, nil
+}
+
+// Fetch returns a reader for the given range. Closing it leaves the underlying file open for further fetches.
+func (l *LocalFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
+	if l.handle == nil {
+		return nil, l.errIsDir()
+	}
+	if etag := ifMatch(ctx); etag != "" {
+		if info, err := l.Stat(ctx); err == nil && info.ETag != "" && info.ETag != etag {
+			return nil, ErrPreconditionFailed
+		}
+	}
+	if l.readerAt != nil {
+		return l.sectionFetch(startOffset, endOffset), nil
+	}
 	if startOffset == nil && endOffset == nil {
 		// no range, read the whole thing
 		_, err := l.handle.Seek(0, io.SeekStart)
 		if err != nil {
 			return nil, err
 		}
-		return l.handle, nil
+		return io.NopCloser(l.handle), nil
 	}
 
 	if startOffset == nil && endOffset != nil {
@@ -72,7 +202,7 @@ func (l *LocalFetcher) Fetch(_ context.Context, startOffset *int64, endOffset *i
 		} else if err != nil {
 			return nil, err
 		}
-		return l.handle, nil
+		return io.NopCloser(l.handle), nil
 	}
 
 	if startOffset != nil && endOffset != nil {
@@ -81,10 +211,7 @@ func (l *LocalFetcher) Fetch(_ context.Context, startOffset *int64, endOffset *i
 		if err != nil {
 			return nil, err
 		}
-		return &localReader{
-			original:    l.handle,
-			limitReader: io.LimitReader(l.handle, *endOffset+1-*startOffset),
-		}, nil
+		return io.NopCloser(io.LimitReader(l.handle, *endOffset+1-*startOffset)), nil
 	}
 
 	//if startOffset != nil && endOffset == nil
@@ -92,21 +219,26 @@ func (l *LocalFetcher) Fetch(_ context.Context, startOffset *int64, endOffset *i
 	if err != nil {
 		return nil, err
 	}
-	return l.handle, nil
+	return io.NopCloser(l.handle), nil
 
 }
 
-type localReader struct {
-	original    io.Closer
-	limitReader io.Reader
-}
-
-func (l *localReader) Read(p []byte) (n int, err error) {
-	return l.limitReader.Read(p)
-}
-
-func (l *localReader) Close() error {
-	return l.original.Close()
+// sectionFetch returns an independent reader for the given range,
+// so multiple readers can be used at the same time without affecting each other's offset
+func (l *LocalFetcher) sectionFetch(startOffset *int64, endOffset *int64) io.ReadCloser {
+	var start, end int64 = 0, l.size
+	if startOffset != nil {
+		start = *startOffset
+	}
+	if startOffset == nil && endOffset != nil {
+		// only end offset, read the last endOffset bytes
+		start = l.size - *endOffset
+	} else if endOffset != nil && *endOffset+1 < l.size {
+		end = *endOffset + 1
+	}
+	start = max(0, min(start, l.size))
+	end = max(start, end)
+	return io.NopCloser(io.NewSectionReader(l.readerAt, start, end-start))
 }
 
 func localParseUri(uri string) (string, error) {
@@ -5,6 +5,9 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ozkatz/cloudzip/pkg/remote"
@@ -103,3 +106,90 @@ func TestLocalDownloader_Fetch(t *testing.T) {
 		}
 	})
 }
+
+func TestLocalFetcher_List(t *testing.T) {
+	r, err := remote.NewLocalFetcher("file://testdata")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := remote.List(context.Background(), r, "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var found *remote.ListEntry
+	for _, entry := range entries {
+		if entry.Name == "lorem.txt" {
+			found = entry
+		}
+	}
+	if found == nil || found.IsPrefix || found.Size != 446 || found.ETag == "" {
+		t.Errorf("expected lorem.txt to be listed with its size, got %+v", found)
+	}
+	if _, err := r.Fetch(context.Background(), nil, nil); !errors.Is(err, remote.ErrDoesNotExist) {
+		t.Errorf("expected reading a directory to fail with ErrDoesNotExist, got %v", err)
+	}
+
+	file, err := remote.NewLocalFetcher("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := remote.List(context.Background(), file, "", 0); err == nil {
+		t.Errorf("expected listing a file to fail")
+	}
+}
+
+func TestLocalFetcher_ListPages(t *testing.T) {
+	dir := t.TempDir()
+	// "a" is listed as "a/", after "a.txt"
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a.txt", "c.txt", "a0.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := remote.NewLocalFetcher("file://" + dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var keys []string
+	after := ""
+	for pages := 0; pages < 10; pages++ {
+		entries, err := remote.List(context.Background(), r, after, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			keys = append(keys, entry.Key())
+		}
+		after = entries[len(entries)-1].Key()
+	}
+	if strings.Join(keys, ",") != "a.txt,a/,a0.txt,b/,c.txt" {
+		t.Errorf("unexpected listing: %v", keys)
+	}
+}
+
+func TestLocalFetcher_IfMatch(t *testing.T) {
+	r, err := remote.NewLocalFetcher("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := remote.Stat(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader, err := r.Fetch(remote.ContextWithIfMatch(context.Background(), info.ETag), int64p(0), int64p(9))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = reader.Close()
+	if _, err := r.Fetch(remote.ContextWithIfMatch(context.Background(), `"stale"`), int64p(0), int64p(9)); !errors.Is(err, remote.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+}
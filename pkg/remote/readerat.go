@@ -0,0 +1,256 @@
+package remote
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	DefaultBlockSize       = 1024 * 1024 // 1MiB
+	DefaultReadaheadBlocks = 4
+	DefaultCacheBlocks     = 64
+)
+
+type ReaderAtOpt func(r *ReaderAt)
+
+// WithBlockSize sets the size of the blocks fetched and cached by the ReaderAt
+func WithBlockSize(size int64) ReaderAtOpt {
+	return func(r *ReaderAt) {
+		if size > 0 {
+			r.blockSize = size
+		}
+	}
+}
+
+// WithReadahead sets the number of blocks fetched in a single request when reads are sequential
+func WithReadahead(blocks int) ReaderAtOpt {
+	return func(r *ReaderAt) {
+		if blocks > 0 {
+			r.readahead = blocks
+		}
+	}
+}
+
+// WithCacheBlocks sets the maximum number of blocks kept in memory
+func WithCacheBlocks(blocks int) ReaderAtOpt {
+	return func(r *ReaderAt) {
+		if blocks > 0 {
+			r.cache.capacity = blocks
+		}
+	}
+}
+
+// ReaderAt adapts a Fetcher to io.ReaderAt, so remote objects can be passed to libraries
+// that accept an io.ReaderAt and a size (e.g. zip.NewReader).
+// Data is fetched in fixed size blocks that are kept in an LRU cache; sequential access
+// fetches several blocks per request. A ReaderAt is safe for concurrent use.
+type ReaderAt struct {
+	ctx       context.Context
+	fetcher   Fetcher
+	size      int64
+	blockSize int64
+	readahead int
+	cache     *blockCache
+
+	l         *sync.Mutex
+	inflight  map[int64]*blockFetch
+	lastBlock int64
+}
+
+var _ io.ReaderAt = &ReaderAt{}
+
+type blockFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewReaderAt returns a ReaderAt for f, using Size to determine the size of the object
+func NewReaderAt(ctx context.Context, f Fetcher, opts ...ReaderAtOpt) (*ReaderAt, error) {
+	size, err := Size(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return NewReaderAtWithSize(ctx, f, size, opts...), nil
+}
+
+// NewReaderAtWithSize returns a ReaderAt for f, for an object of a known size
+func NewReaderAtWithSize(ctx context.Context, f Fetcher, size int64, opts ...ReaderAtOpt) *ReaderAt {
+	r := &ReaderAt{
+		ctx:       ctx,
+		fetcher:   f,
+		size:      size,
+		blockSize: DefaultBlockSize,
+		readahead: DefaultReadaheadBlocks,
+		cache:     newBlockCache(DefaultCacheBlocks),
+		l:         &sync.Mutex{},
+		inflight:  make(map[int64]*blockFetch),
+		lastBlock: -2,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Size returns the size of the underlying object
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: negative offset", ErrInvalidRange)
+	}
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		blockIdx := pos / r.blockSize
+		block, err := r.getBlock(blockIdx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos-blockIdx*r.blockSize:])
+	}
+	return n, nil
+}
+
+func (r *ReaderAt) numBlocks() int64 {
+	return (r.size + r.blockSize - 1) / r.blockSize
+}
+
+// getBlock returns the block at the given index, from cache or by fetching it.
+// Concurrent requests for the same block are served by a single fetch.
+func (r *ReaderAt) getBlock(idx int64) ([]byte, error) {
+	for {
+		if block, ok := r.cache.get(idx); ok {
+			r.l.Lock()
+			r.lastBlock = idx
+			r.l.Unlock()
+			return block, nil
+		}
+		r.l.Lock()
+		if pending, ok := r.inflight[idx]; ok {
+			r.l.Unlock()
+			<-pending.done
+			if pending.err != nil {
+				return nil, pending.err
+			}
+			continue // should now be cached
+		}
+		// sequential access: read ahead
+		count := int64(1)
+		if idx == r.lastBlock+1 {
+			count = int64(r.readahead)
+		}
+		count = min(count, r.numBlocks()-idx)
+		fetches := make([]*blockFetch, 0, count)
+		for i := idx; i < idx+count; i++ {
+			if _, ok := r.inflight[i]; ok || (i > idx && r.cache.contains(i)) {
+				break
+			}
+			bf := &blockFetch{done: make(chan struct{})}
+			r.inflight[i] = bf
+			fetches = append(fetches, bf)
+		}
+		r.lastBlock = idx
+		r.l.Unlock()
+
+		blocks, err := r.fetchBlocks(idx, int64(len(fetches)))
+		r.l.Lock()
+		for i, bf := range fetches {
+			if err == nil {
+				r.cache.put(idx+int64(i), blocks[i])
+			}
+			bf.err = err
+			delete(r.inflight, idx+int64(i))
+			close(bf.done)
+		}
+		r.l.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return blocks[0], nil
+	}
+}
+
+func (r *ReaderAt) fetchBlocks(first, count int64) ([][]byte, error) {
+	start := first * r.blockSize
+	end := min((first+count)*r.blockSize, r.size) - 1
+	reader, err := r.fetcher.Fetch(r.ctx, &start, &end)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	blocks := make([][]byte, count)
+	for i := int64(0); i < count; i++ {
+		blockStart := start + i*r.blockSize
+		block := make([]byte, min(r.blockSize, r.size-blockStart))
+		if _, err := io.ReadFull(reader, block); err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+	return blocks, nil
+}
+
+// blockCache is a fixed capacity LRU of blocks
+type blockCache struct {
+	capacity int
+	l        *sync.Mutex
+	entries  map[int64]*list.Element
+	order    *list.List
+}
+
+type cachedBlock struct {
+	idx  int64
+	data []byte
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{
+		capacity: capacity,
+		l:        &sync.Mutex{},
+		entries:  make(map[int64]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *blockCache) get(idx int64) ([]byte, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	e, ok := c.entries[idx]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedBlock).data, true
+}
+
+func (c *blockCache) contains(idx int64) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+	_, ok := c.entries[idx]
+	return ok
+}
+
+func (c *blockCache) put(idx int64, data []byte) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.entries[idx]; ok {
+		c.order.MoveToFront(e)
+		e.Value.(*cachedBlock).data = data
+		return
+	}
+	c.entries[idx] = c.order.PushFront(&cachedBlock{idx: idx, data: data})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedBlock).idx)
+	}
+}
@@ -0,0 +1,86 @@
+package remote_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func TestReaderAt_ReadAt(t *testing.T) {
+	expected, err := os.ReadFile("testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	f, err := remote.NewLocalFetcher("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := remote.NewReaderAt(context.Background(), f,
+		remote.WithBlockSize(16), remote.WithReadahead(3), remote.WithCacheBlocks(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Size() != int64(len(expected)) {
+		t.Fatalf("expected size %d, got %d", len(expected), r.Size())
+	}
+
+	cases := []struct {
+		off    int64
+		length int
+	}{
+		{0, 10}, {10, 40}, {15, 2}, {100, 200}, {0, len(expected)}, {int64(len(expected)) - 5, 5},
+	}
+	for _, c := range cases {
+		buf := make([]byte, c.length)
+		n, err := r.ReadAt(buf, c.off)
+		if err != nil {
+			t.Errorf("ReadAt(%d, %d): unexpected error: %v", c.off, c.length, err)
+		}
+		if !bytes.Equal(buf[:n], expected[c.off:c.off+int64(c.length)]) {
+			t.Errorf("ReadAt(%d, %d): wrong data: %s", c.off, c.length, buf[:n])
+		}
+	}
+
+	// reading past the end
+	buf := make([]byte, 10)
+	n, err := r.ReadAt(buf, int64(len(expected))-3)
+	if n != 3 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected 3 bytes and io.EOF, got %d, %v", n, err)
+	}
+}
+
+func TestReaderAt_Concurrent(t *testing.T) {
+	expected, err := os.ReadFile("testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	f, err := remote.NewLocalFetcher("file://testdata/lorem.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := remote.NewReaderAt(context.Background(), f, remote.WithBlockSize(7), remote.WithCacheBlocks(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := io.ReadAll(io.NewSectionReader(r, int64(i), r.Size()-int64(i)))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(data, expected[i:]) {
+				t.Errorf("wrong data read from offset %d", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -20,12 +20,22 @@ type S3Getter interface {
 	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 }
 
+// S3Lister is implemented by S3 clients that can list objects, as *s3.Client does
+type S3Lister interface {
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
 type s3ParsedUri struct {
 	Bucket string
 	Path   string
 }
 
 func s3getServiceForBucket(ctx context.Context, bucket string) (S3Getter, error) {
+	return s3clientForBucket(ctx, bucket)
+}
+
+// s3clientForBucket returns a client configured for the region of the given bucket
+func s3clientForBucket(ctx context.Context, bucket string) (*s3.Client, error) {
 	const defaultRegion = "us-east-1"
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(defaultRegion))
 	if err != nil {
@@ -97,6 +107,20 @@ func NewS3ObjectFetcher(uri string) (*S3ObjectFetcher, error) {
 	}, nil
 }
 
+// NewS3ObjectFetcherWithClient returns a fetcher for uri that uses the given, pre-configured client
+func NewS3ObjectFetcherWithClient(client S3Getter, uri string) (*S3ObjectFetcher, error) {
+	parsed, err := s3parseUri(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &S3ObjectFetcher{
+		client: client,
+		bucket: parsed.Bucket,
+		path:   parsed.Path,
+		logger: DummyLogger(),
+	}, nil
+}
+
 func (s *S3ObjectFetcher) setLogger(logger *slog.Logger) {
 	s.logger = logger
 }
@@ -104,16 +128,23 @@ func (s *S3ObjectFetcher) setLogger(logger *slog.Logger) {
 func (s *S3ObjectFetcher) Fetch(ctx context.Context, startOffset *int64, endOffset *int64) (io.ReadCloser, error) {
 	start := time.Now()
 	rng := buildRange(startOffset, endOffset)
-	response, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(s.path),
 		Range:  rng,
-	})
+	}
+	if etag := ifMatch(ctx); etag != "" {
+		input.IfMatch = aws.String(etag)
+	}
+	response, err := s.client.GetObject(ctx, input)
 	tookMs := time.Since(start).Milliseconds()
 	rangeString := aws.ToString(rng)
 	if s3IsNotFoundErr(err) {
 		s.logger.WarnContext(ctx, "s3.GetObject", "range", rangeString, "bucket", s.bucket, "key", s.path, "took_ms", tookMs, "error", "NotFound")
 		return nil, ErrDoesNotExist
+	} else if s3IsPreconditionFailed(err) {
+		s.logger.WarnContext(ctx, "s3.GetObject", "range", rangeString, "bucket", s.bucket, "key", s.path, "took_ms", tookMs, "error", "PreconditionFailed")
+		return nil, ErrPreconditionFailed
 	} else if err != nil {
 		s.logger.ErrorContext(ctx, "s3.GetObject", "range", rangeString, "bucket", s.bucket, "key", s.path, "took_ms", tookMs, "error", err)
 		return nil, err
@@ -121,3 +152,95 @@ func (s *S3ObjectFetcher) Fetch(ctx context.Context, startOffset *int64, endOffs
 	s.logger.DebugContext(ctx, "s3.GetObject", "range", rangeString, "bucket", s.bucket, "key", s.path, "took_ms", tookMs, "error", nil)
 	return response.Body, nil
 }
+
+func (s *S3ObjectFetcher) Size(ctx context.Context) (int64, error) {
+	info, err := s.Stat(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+func (s *S3ObjectFetcher) Stat(ctx context.Context) (*ObjectInfo, error) {
+	response, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.path),
+		Range:  aws.String("bytes=0-0"),
+	})
+	if s3IsNotFoundErr(err) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	info := &ObjectInfo{
+		ETag:         aws.ToString(response.ETag),
+		LastModified: aws.ToTime(response.LastModified),
+	}
+	if response.ContentRange == nil {
+		// empty objects can't be ranged
+		info.Size = aws.ToInt64(response.ContentLength)
+		return info, nil
+	}
+	info.Size, err = parseContentRangeSize(aws.ToString(response.ContentRange))
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// List returns a page of the objects and common prefixes under the fetcher's key, taken as a prefix ending with "/".
+// It requires the fetcher's client to implement S3Lister.
+func (s *S3ObjectFetcher) List(ctx context.Context, after string, limit int) ([]*ListEntry, error) {
+	lister, ok := s.client.(S3Lister)
+	if !ok {
+		return nil, ErrListUnsupported
+	}
+	prefix := s.path
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	start := time.Now()
+	var entries []*ListEntry
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+	if after != "" {
+		// keys under a common prefix sort after it, so resuming after one lists it again (it is skipped below)
+		input.StartAfter = aws.String(prefix + after)
+	}
+	if limit > 0 {
+		input.MaxKeys = aws.Int32(int32(min(limit+1, 1000)))
+	}
+	paginator := s3.NewListObjectsV2Paginator(lister, input)
+	// pages are ordered, so once limit entries are read, later pages only hold entries after them
+	for paginator.HasMorePages() && (limit <= 0 || len(entries) < limit) {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "s3.ListObjectsV2", "bucket", s.bucket, "prefix", prefix, "took_ms", time.Since(start).Milliseconds(), "error", err)
+			return nil, err
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), prefix), "/")
+			entries = append(entries, &ListEntry{Name: name, IsPrefix: true})
+		}
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+			if name == "" {
+				// a "directory marker" object
+				continue
+			}
+			entries = append(entries, &ListEntry{
+				Name:         name,
+				Size:         aws.ToInt64(object.Size),
+				ETag:         aws.ToString(object.ETag),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	s.logger.DebugContext(ctx, "s3.ListObjectsV2", "bucket", s.bucket, "prefix", prefix, "took_ms", time.Since(start).Milliseconds(), "entries", len(entries))
+	// common prefixes and objects are each ordered, but not with respect to each other
+	return pageEntries(entries, after, limit), nil
+}
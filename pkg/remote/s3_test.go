@@ -0,0 +1,50 @@
+package remote_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+)
+
+func TestS3ObjectFetcher_ListPages(t *testing.T) {
+	client := newFakeS3()
+	for _, key := range []string{"dir/a.txt", "dir/a/1.zip", "dir/a/2.zip", "dir/a0.txt", "dir/b/c/d.zip", "dir/c.txt", "other.txt"} {
+		client.objects[key] = []byte(key)
+	}
+	f, err := remote.NewS3ObjectFetcherWithClient(client, "s3://bucket/dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all, err := remote.List(context.Background(), f, "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var keys []string
+	for _, entry := range all {
+		keys = append(keys, entry.Key())
+	}
+	if strings.Join(keys, ",") != "a.txt,a/,a0.txt,b/,c.txt" {
+		t.Fatalf("unexpected listing: %v", keys)
+	}
+
+	var paged []string
+	after := ""
+	for pages := 0; pages < 10; pages++ {
+		entries, err := remote.List(context.Background(), f, after, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			paged = append(paged, entry.Key())
+		}
+		after = entries[len(entries)-1].Key()
+	}
+	if strings.Join(paged, ",") != strings.Join(keys, ",") {
+		t.Errorf("expected pages to list %v, got %v", keys, paged)
+	}
+}
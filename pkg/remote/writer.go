@@ -0,0 +1,232 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	DefaultPartSize          = 64 * 1024 * 1024
+	DefaultUploadConcurrency = 4
+)
+
+var ErrWriterClosed = errors.New("writer already closed")
+
+// Writer creates a new object from a stream of bytes.
+// The object only becomes visible at its destination once Close returns successfully,
+// Abort discards everything written so far.
+type Writer interface {
+	Write(p []byte) (int, error)
+	Close() error
+	Abort() error
+}
+
+type writerConfig struct {
+	partSize    int64
+	concurrency int
+	s3Client    manager.UploadAPIClient
+	ifMatch     string
+}
+
+type WriterOpt func(c *writerConfig)
+
+// WithPartSize sets the size of the parts of multipart uploads
+func WithPartSize(size int64) WriterOpt {
+	return func(c *writerConfig) {
+		c.partSize = size
+	}
+}
+
+// WithUploadConcurrency sets the number of parts uploaded concurrently
+func WithUploadConcurrency(n int) WriterOpt {
+	return func(c *writerConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithS3UploadClient uses the given client for s3:// URIs,
+// instead of one built from the default AWS configuration
+func WithS3UploadClient(client manager.UploadAPIClient) WriterOpt {
+	return func(c *writerConfig) {
+		c.s3Client = client
+	}
+}
+
+// WithIfMatch makes appending (see NewAppender) fail with ErrConcurrentModification unless the object's ETag
+// is etag, i.e. unless it is unchanged since it was read. It has no effect on NewWriter.
+func WithIfMatch(etag string) WriterOpt {
+	return func(c *writerConfig) {
+		c.ifMatch = etag
+	}
+}
+
+// NewWriter returns a Writer creating the object at uri.
+// Supported schemes are s3:// (and S3 compatible stores, through the standard AWS configuration) and file://.
+func NewWriter(ctx context.Context, uri string, opts ...WriterOpt) (Writer, error) {
+	cfg := &writerConfig{
+		partSize:    DefaultPartSize,
+		concurrency: DefaultUploadConcurrency,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, ErrInvalidURI
+	}
+	switch parsed.Scheme {
+	case "s3", "S3", "s3a":
+		return newS3Writer(ctx, uri, cfg)
+	case "local", "file":
+		filePath, err := localParseUri(uri)
+		if err != nil {
+			return nil, err
+		}
+		return NewLocalWriter(filePath)
+	}
+	return nil, fmt.Errorf("%w: unsupported scheme for writing: %s", ErrInvalidURI, parsed.Scheme)
+}
+
+// LocalWriter writes to a temporary file next to its destination, renaming it into place on Close
+type LocalWriter struct {
+	f           *os.File
+	destination string
+	// ifMatch, if set, is the ETag the destination must still have on Close (see NewLocalAppender)
+	ifMatch string
+	done    bool
+}
+
+func NewLocalWriter(destination string) (*LocalWriter, error) {
+	f, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".*")
+	if os.IsNotExist(err) {
+		return nil, ErrDoesNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return &LocalWriter{f: f, destination: destination}, nil
+}
+
+func (l *LocalWriter) Write(p []byte) (int, error) {
+	if l.done {
+		return 0, ErrWriterClosed
+	}
+	return l.f.Write(p)
+}
+
+func (l *LocalWriter) Close() error {
+	if l.done {
+		return ErrWriterClosed
+	}
+	l.done = true
+	if err := l.f.Close(); err != nil {
+		_ = os.Remove(l.f.Name())
+		return err
+	}
+	if l.ifMatch != "" {
+		stat, err := os.Stat(l.destination)
+		if err != nil || localETag(stat) != l.ifMatch {
+			_ = os.Remove(l.f.Name())
+			return fmt.Errorf("%w: %s", ErrConcurrentModification, l.destination)
+		}
+	}
+	if err := os.Rename(l.f.Name(), l.destination); err != nil {
+		_ = os.Remove(l.f.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *LocalWriter) Abort() error {
+	if l.done {
+		return nil
+	}
+	l.done = true
+	_ = l.f.Close()
+	return os.Remove(l.f.Name())
+}
+
+var errUploadAborted = errors.New("upload aborted")
+
+// S3Writer streams data into a multipart upload: parts are uploaded as soon as they are filled,
+// so at most (concurrency + 1) * partSize bytes are buffered in memory.
+type S3Writer struct {
+	pw     *io.PipeWriter
+	result chan error
+	done   bool
+}
+
+func newS3Writer(ctx context.Context, uri string, cfg *writerConfig) (*S3Writer, error) {
+	parsed, err := s3parseUri(uri)
+	if err != nil {
+		return nil, err
+	}
+	client := cfg.s3Client
+	if client == nil {
+		client, err = s3clientForBucket(ctx, parsed.Bucket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewS3Writer(ctx, client, parsed.Bucket, parsed.Path, cfg.partSize, cfg.concurrency), nil
+}
+
+func NewS3Writer(ctx context.Context, client manager.UploadAPIClient, bucket, key string, partSize int64, concurrency int) *S3Writer {
+	pr, pw := io.Pipe()
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+		u.LeavePartsOnError = false
+	})
+	w := &S3Writer{pw: pw, result: make(chan error, 1)}
+	go func() {
+		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		w.result <- err
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		// unblock pending writes if the upload failed
+		_ = pr.CloseWithError(err)
+	}()
+	return w
+}
+
+func (w *S3Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, ErrWriterClosed
+	}
+	return w.pw.Write(p)
+}
+
+// Close completes the upload
+func (w *S3Writer) Close() error {
+	if w.done {
+		return ErrWriterClosed
+	}
+	w.done = true
+	_ = w.pw.Close()
+	return <-w.result
+}
+
+// Abort stops the upload; the uploader removes any parts uploaded so far
+func (w *S3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	_ = w.pw.CloseWithError(errUploadAborted)
+	<-w.result // fails, since the body returned an error
+	return nil
+}
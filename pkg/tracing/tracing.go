@@ -0,0 +1,139 @@
+// Package tracing instruments cloudzip with OpenTelemetry spans - from the servers' handlers, through
+// the member cache and archive parsing, down to every request made to remote storage - and sets up
+// the exporter from the standard OTEL_* environment variables. Until Setup is called, spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/ozkatz/cloudzip"
+	defaultServiceName  = "cz"
+
+	// ExporterEnvVar selects the exporter: "otlp", "console" (spans are written to stderr) or "none"
+	ExporterEnvVar = "OTEL_TRACES_EXPORTER"
+	// ProtocolEnvVar selects the OTLP protocol: "http/protobuf" (the default) or "grpc"
+	ProtocolEnvVar = "OTEL_EXPORTER_OTLP_PROTOCOL"
+)
+
+var ErrUnsupportedExporter = errors.New("unsupported exporter")
+
+// Start starts a span, as a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it as failed if err isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Setup installs a global tracer provider exporting spans as configured by the environment:
+// OTEL_TRACES_EXPORTER, OTEL_EXPORTER_OTLP_PROTOCOL and the exporters' own variables
+// (OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS, ...), along with OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES. Tracing is disabled unless OTEL_TRACES_EXPORTER is set.
+// The returned function flushes pending spans and should be called before exiting.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := newExporter(ctx, os.Getenv(ExporterEnvVar), os.Getenv(ProtocolEnvVar), os.Stderr)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+	res, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", defaultServiceName)),
+		resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// newExporter returns the exporter selected by name, or nil if tracing is disabled
+func newExporter(ctx context.Context, name, protocol string, console io.Writer) (sdktrace.SpanExporter, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return nil, nil
+	case "console", "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(console))
+	case "otlp":
+		switch protocol {
+		case "", "http/protobuf":
+			return otlptracehttp.New(ctx)
+		case "grpc":
+			return otlptracegrpc.New(ctx)
+		}
+		return nil, fmt.Errorf("%w: %s=%s", ErrUnsupportedExporter, ProtocolEnvVar, protocol)
+	}
+	return nil, fmt.Errorf("%w: %s=%s", ErrUnsupportedExporter, ExporterEnvVar, name)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Handler traces the requests served by h, continuing traces propagated by clients
+func Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, name+" "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path)))
+		defer span.End()
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
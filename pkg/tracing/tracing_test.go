@@ -0,0 +1,129 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+// recordSpans installs a tracer provider recording spans in memory for the duration of the test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	exporter := recordSpans(t)
+	h := tracing.Handler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "inner")
+		span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest(http.MethodGet, "/a.zip/b.txt", nil)
+	r.Header.Set("traceparent", parent)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.GetSpans()
+	server := spanNamed(spans, "test GET")
+	inner := spanNamed(spans, "inner")
+	if server == nil || inner == nil {
+		t.Fatalf("expected server and inner spans, got %d spans", len(spans))
+	}
+	if got := server.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the propagated trace to be continued, got trace %s", got)
+	}
+	if inner.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("expected the handler's spans to be children of the server span")
+	}
+	if server.Status.Code != codes.Error {
+		t.Errorf("expected 5xx responses to mark the span as failed, got %v", server.Status.Code)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	exporter := recordSpans(t)
+	_, span := tracing.Start(context.Background(), "failing")
+	tracing.End(span, errors.New("boom"))
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Status.Code != codes.Error || len(spans[0].Events) != 1 {
+		t.Fatalf("expected a single failed span recording the error, got %+v", spans)
+	}
+}
+
+func TestReaderForRecord_Spans(t *testing.T) {
+	exporter := recordSpans(t)
+	f, err := remote.Object("file://../zipfile/testdata/regular.zip")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, root := tracing.Start(context.Background(), "root")
+	fetcher := zipfile.NewStorageAdapter(ctx, f)
+	records, err := zipfile.NewCentralDirectoryParser(fetcher).GetCentralDirectory()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := zipfile.ReaderForRecord(records[0], fetcher)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, r)
+	root.End()
+
+	spans := exporter.GetSpans()
+	ids := map[string]string{}
+	for _, span := range spans {
+		ids[span.SpanContext.SpanID().String()] = span.Name
+	}
+	parse := spanNamed(spans, "CentralDirectoryParser.GetCentralDirectory")
+	read := spanNamed(spans, "ReaderForRecord")
+	if parse == nil || read == nil {
+		t.Fatalf("expected parse and read spans, got %d spans", len(spans))
+	}
+	for _, span := range []*tracetest.SpanStub{parse, read} {
+		if got := ids[span.Parent.SpanID().String()]; got != "root" {
+			t.Errorf("expected %s to be a child of root, got parent '%s'", span.Name, got)
+		}
+	}
+	fetches := map[string]int{}
+	for _, span := range spans {
+		if span.Name == "remote.Fetch" {
+			fetches[ids[span.Parent.SpanID().String()]]++
+		}
+	}
+	if fetches[parse.Name] != 2 || fetches[read.Name] != 1 {
+		t.Errorf("expected fetches to be children of the spans that made them, got %v", fetches)
+	}
+}
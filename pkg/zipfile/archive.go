@@ -0,0 +1,158 @@
+package zipfile
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type archiveOptions struct {
+	caseInsensitive  bool
+	normalizeUnicode bool
+}
+
+type ArchiveOpt func(o *archiveOptions)
+
+// WithCaseInsensitiveLookup makes name lookups (Open, Stat) ignore case
+func WithCaseInsensitiveLookup() ArchiveOpt {
+	return func(o *archiveOptions) {
+		o.caseInsensitive = true
+	}
+}
+
+// WithUnicodeNormalization makes name lookups (Open, Stat) compare names in their NFC form,
+// so that e.g. names written by macOS (NFD) can be looked up using their composed form.
+func WithUnicodeNormalization() ArchiveOpt {
+	return func(o *archiveOptions) {
+		o.normalizeUnicode = true
+	}
+}
+
+// Archive is a parsed central directory of a remote zip file.
+// The central directory is read once, when the Archive is created; lookups are done in memory.
+// An Archive is immutable and is safe for concurrent use, as long as the underlying OffsetFetcher is.
+type Archive struct {
+	fetcher OffsetFetcher
+	records []*CDR
+	byName  map[string]*CDR
+	opts    archiveOptions
+}
+
+// NewArchive reads the central directory using the given fetcher and indexes it by name
+func NewArchive(fetcher OffsetFetcher, opts ...ArchiveOpt) (*Archive, error) {
+	records, err := NewCentralDirectoryParser(fetcher).GetCentralDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return NewArchiveFromRecords(fetcher, records, opts...), nil
+}
+
+// NewArchiveFromRecords indexes an already parsed list of records
+func NewArchiveFromRecords(fetcher OffsetFetcher, records []*CDR, opts ...ArchiveOpt) *Archive {
+	a := &Archive{
+		fetcher: fetcher,
+		records: records,
+		byName:  make(map[string]*CDR, len(records)),
+	}
+	for _, opt := range opts {
+		opt(&a.opts)
+	}
+	for _, record := range records {
+		key := a.key(record.FileName)
+		if _, exists := a.byName[key]; exists {
+			continue // first occurrence wins, same as a sequential scan
+		}
+		a.byName[key] = record
+	}
+	return a
+}
+
+func (a *Archive) key(name string) string {
+	name = strings.Trim(name, "/")
+	if a.opts.normalizeUnicode {
+		name = norm.NFC.String(name)
+	}
+	if a.opts.caseInsensitive {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// WithFetcher returns a copy of the archive that reads members using f, sharing the parsed central directory
+func (a *Archive) WithFetcher(f OffsetFetcher) *Archive {
+	return &Archive{
+		fetcher: f,
+		records: a.records,
+		byName:  a.byName,
+		opts:    a.opts,
+	}
+}
+
+// Records returns all records in the order they appear in the central directory.
+// The returned slice must not be modified.
+func (a *Archive) Records() []*CDR {
+	return a.records
+}
+
+// Len returns the number of records in the archive
+func (a *Archive) Len() int {
+	return len(a.records)
+}
+
+// Fetcher returns the OffsetFetcher used to read member data
+func (a *Archive) Fetcher() OffsetFetcher {
+	return a.fetcher
+}
+
+// Stat returns the record for the given name, or ErrFileNotFound
+func (a *Archive) Stat(name string) (*CDR, error) {
+	record, ok := a.byName[a.key(name)]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return record, nil
+}
+
+// Open returns a reader for the uncompressed content of the given member
+func (a *Archive) Open(name string) (io.Reader, error) {
+	record, err := a.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return ReaderForRecord(record, a.fetcher)
+}
+
+// Glob returns all records whose name matches pattern, using the syntax of path.Match
+func (a *Archive) Glob(pattern string) ([]*CDR, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	matches := make([]*CDR, 0)
+	for _, record := range a.records {
+		if ok, _ := path.Match(pattern, record.FileName); ok {
+			matches = append(matches, record)
+		}
+	}
+	return matches, nil
+}
+
+// WalkFunc is called by Walk for every record.
+// Returning fs.SkipAll stops the walk without error, any other error stops it and is returned by Walk.
+type WalkFunc func(record *CDR) error
+
+// Walk calls fn for every record, in central directory order
+func (a *Archive) Walk(fn WalkFunc) error {
+	for _, record := range a.records {
+		err := fn(record)
+		if errors.Is(err, fs.SkipAll) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
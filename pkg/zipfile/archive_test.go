@@ -0,0 +1,119 @@
+package zipfile_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+func archive(t *testing.T, uri string, opts ...zipfile.ArchiveOpt) *zipfile.Archive {
+	t.Helper()
+	fetcher, err := remote.Object(uri)
+	if err != nil {
+		t.Fatalf("unexpected error opening zip file: %v", err)
+	}
+	a, err := zipfile.NewArchive(zipfile.NewStorageAdapter(context.Background(), fetcher), opts...)
+	if err != nil {
+		t.Fatalf("unexpected error reading central directory: %v", err)
+	}
+	return a
+}
+
+func TestArchive_Stat(t *testing.T) {
+	a := archive(t, "file://testdata/regular.zip")
+	if a.Len() != 7 {
+		t.Errorf("expected 7 records, got %d", a.Len())
+	}
+	f, err := a.Stat("foo/bar.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.UncompressedSizeBytes != 21 {
+		t.Errorf("expected 21 bytes, got %d", f.UncompressedSizeBytes)
+	}
+	d, err := a.Stat("a/b/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.Mode.IsDir() {
+		t.Errorf("expected a/b to be a directory")
+	}
+	if _, err := a.Stat("FOO/bar.txt"); !errors.Is(err, zipfile.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
+
+	ci := archive(t, "file://testdata/regular.zip", zipfile.WithCaseInsensitiveLookup())
+	if _, err := ci.Stat("FOO/Bar.TXT"); err != nil {
+		t.Errorf("expected case insensitive match, got %v", err)
+	}
+}
+
+func TestArchive_Open(t *testing.T) {
+	a := archive(t, "file://testdata/regular.zip")
+	r, err := a.Open("foo/bar.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(data) != "file in a directory!\n" {
+		t.Errorf("got wrong string: %s\n", string(data))
+	}
+}
+
+func TestArchive_Glob(t *testing.T) {
+	a := archive(t, "file://testdata/regular.zip")
+	matches, err := a.Glob("*.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(matches) != 1 || matches[0].FileName != "baz.txt" {
+		t.Errorf("expected only baz.txt to match, got %d matches", len(matches))
+	}
+	if _, err := a.Glob("[-"); err == nil {
+		t.Errorf("expected error for malformed pattern")
+	}
+}
+
+func TestArchive_Walk(t *testing.T) {
+	a := archive(t, "file://testdata/regular.zip")
+	var visited int
+	err := a.Walk(func(record *zipfile.CDR) error {
+		visited++
+		if visited == 3 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if visited != 3 {
+		t.Errorf("expected walk to stop after 3 records, visited %d", visited)
+	}
+}
+
+func TestArchive_ConcurrentStat(t *testing.T) {
+	a := archive(t, "file://testdata/regular.zip")
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, record := range a.Records() {
+				if _, err := a.Stat(record.FileName); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
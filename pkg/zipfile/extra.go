@@ -0,0 +1,212 @@
+package zipfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Extra field header IDs, see APPNOTE.TXT section 4.5 and 4.6
+const (
+	NTFSExtraId              = 0x000a
+	UnixExtraId              = 0x000d
+	ExtendedTimestampExtraId = 0x5455
+	InfoZipUnixExtraId       = 0x7875
+	InfoZipUnicodePathId     = 0x7075
+	AESExtraId               = 0x9901
+	// AlignmentExtraId pads local file headers so that member data is aligned (as written by Android's zipalign)
+	AlignmentExtraId = 0xd935
+)
+
+// CompressionMethodName returns a human readable name for a compression method
+func CompressionMethodName(method uint16) string {
+	switch method {
+	case 0:
+		return "store"
+	case 8:
+		return "deflate"
+	case 9:
+		return "deflate64"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "lzma"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	case 98:
+		return "ppmd"
+	}
+	return fmt.Sprintf("method-%d", method)
+}
+
+// CreatorOSName returns the name of the host system that created a member, from its CreatorVersion
+func CreatorOSName(creatorVersion uint16) string {
+	switch creatorVersion >> 8 {
+	case creatorFAT:
+		return "fat"
+	case 1:
+		return "amiga"
+	case 2:
+		return "openvms"
+	case creatorUnix:
+		return "unix"
+	case 5:
+		return "atari"
+	case 6:
+		return "os/2"
+	case 7:
+		return "macintosh"
+	case 10:
+		return "windows"
+	case creatorNTFS:
+		return "ntfs"
+	case creatorVFAT:
+		return "vfat"
+	case creatorMacOSX:
+		return "osx"
+	}
+	return fmt.Sprintf("os-%d", creatorVersion>>8)
+}
+
+// CreatorSpecVersion returns the version of the zip specification supported by the creator (e.g. "6.3")
+func CreatorSpecVersion(creatorVersion uint16) string {
+	v := creatorVersion & 0xff
+	return fmt.Sprintf("%d.%d", v/10, v%10)
+}
+
+// ExtraField is a single, raw extra field block
+type ExtraField struct {
+	ID   uint16
+	Data []byte
+}
+
+// ParseExtraFields splits raw extra field data into its blocks.
+// Parsing stops at the first truncated block.
+func ParseExtraFields(extra []byte) []ExtraField {
+	fields := make([]ExtraField, 0)
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		fields = append(fields, ExtraField{ID: id, Data: extra[4 : 4+size]})
+		extra = extra[4+size:]
+	}
+	return fields
+}
+
+// ExtraMetadata is metadata derived from well known extra fields
+type ExtraMetadata struct {
+	Modified    *time.Time `json:"modified,omitempty"`
+	Accessed    *time.Time `json:"accessed,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	UID         *uint32    `json:"uid,omitempty"`
+	GID         *uint32    `json:"gid,omitempty"`
+	UnicodePath string     `json:"unicode_path,omitempty"`
+	FieldIDs    []uint16   `json:"field_ids,omitempty"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func uint32Ptr(n uint32) *uint32 {
+	return &n
+}
+
+// ntfsEpochOffset is the number of 100ns intervals between 1601-01-01 and 1970-01-01
+const ntfsEpochOffset = 116444736000000000
+
+func ntfsTime(ticks uint64) time.Time {
+	return time.Unix(0, int64(ticks-ntfsEpochOffset)*100).UTC()
+}
+
+// ParseExtraMetadata extracts timestamps, ownership and unicode paths from extra field data
+func ParseExtraMetadata(extra []byte) *ExtraMetadata {
+	md := &ExtraMetadata{}
+	for _, field := range ParseExtraFields(extra) {
+		md.FieldIDs = append(md.FieldIDs, field.ID)
+		data := field.Data
+		switch field.ID {
+		case ExtendedTimestampExtraId:
+			if len(data) < 1 {
+				continue
+			}
+			flags := data[0]
+			data = data[1:]
+			for i, target := range []**time.Time{&md.Modified, &md.Accessed, &md.Created} {
+				if flags&(1<<i) == 0 {
+					continue
+				}
+				if len(data) < 4 {
+					break
+				}
+				*target = timePtr(time.Unix(int64(int32(binary.LittleEndian.Uint32(data[:4]))), 0).UTC())
+				data = data[4:]
+			}
+		case NTFSExtraId:
+			// 4 reserved bytes, followed by attributes; tag 0x0001 holds mtime, atime, ctime
+			if len(data) < 4 {
+				continue
+			}
+			data = data[4:]
+			for len(data) >= 4 {
+				tag := binary.LittleEndian.Uint16(data[0:2])
+				size := int(binary.LittleEndian.Uint16(data[2:4]))
+				if len(data) < 4+size {
+					break
+				}
+				if tag == 0x0001 && size >= 24 {
+					md.Modified = timePtr(ntfsTime(binary.LittleEndian.Uint64(data[4:12])))
+					md.Accessed = timePtr(ntfsTime(binary.LittleEndian.Uint64(data[12:20])))
+					md.Created = timePtr(ntfsTime(binary.LittleEndian.Uint64(data[20:28])))
+				}
+				data = data[4+size:]
+			}
+		case InfoZipUnixExtraId:
+			// version, uid size, uid, gid size, gid
+			if len(data) < 2 || data[0] != 1 {
+				continue
+			}
+			uidSize := int(data[1])
+			if len(data) < 2+uidSize+1 {
+				continue
+			}
+			uid := littleEndianVarUint(data[2 : 2+uidSize])
+			gidSize := int(data[2+uidSize])
+			if len(data) < 3+uidSize+gidSize {
+				continue
+			}
+			gid := littleEndianVarUint(data[3+uidSize : 3+uidSize+gidSize])
+			md.UID = uint32Ptr(uint32(uid))
+			md.GID = uint32Ptr(uint32(gid))
+		case UnixExtraId:
+			// atime, mtime, uid, gid
+			if len(data) < 12 {
+				continue
+			}
+			md.Accessed = timePtr(time.Unix(int64(binary.LittleEndian.Uint32(data[0:4])), 0).UTC())
+			md.Modified = timePtr(time.Unix(int64(binary.LittleEndian.Uint32(data[4:8])), 0).UTC())
+			md.UID = uint32Ptr(uint32(binary.LittleEndian.Uint16(data[8:10])))
+			md.GID = uint32Ptr(uint32(binary.LittleEndian.Uint16(data[10:12])))
+		case InfoZipUnicodePathId:
+			// version, crc32 of the header file name, utf-8 name
+			if len(data) < 5 || data[0] != 1 {
+				continue
+			}
+			md.UnicodePath = string(data[5:])
+		}
+	}
+	return md
+}
+
+func littleEndianVarUint(b []byte) uint64 {
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n
+}
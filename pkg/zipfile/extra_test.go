@@ -0,0 +1,47 @@
+package zipfile_test
+
+import (
+	"testing"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+func TestParseExtraMetadata(t *testing.T) {
+	p, err := parser("file://testdata/regular.zip")
+	if err != nil {
+		t.Fatalf("unexpected error opening zip file: %v", err)
+	}
+	files, err := p.GetCentralDirectory()
+	if err != nil {
+		t.Fatalf("unexpected error listing zip file: %v", err)
+	}
+	for _, f := range files {
+		md := zipfile.ParseExtraMetadata(f.ExtraFields)
+		if md.Modified == nil {
+			t.Errorf("%s: expected modified time from extended timestamp field", f.FileName)
+		}
+		if md.UID == nil || *md.UID != 501 || md.GID == nil || *md.GID != 20 {
+			t.Errorf("%s: expected uid=501 gid=20, got %v %v", f.FileName, md.UID, md.GID)
+		}
+	}
+}
+
+func TestParseExtraFields_Truncated(t *testing.T) {
+	fields := zipfile.ParseExtraFields([]byte{0x55, 0x54, 0x05, 0x00, 0x01, 0x02})
+	if len(fields) != 0 {
+		t.Errorf("expected truncated field to be ignored, got %d fields", len(fields))
+	}
+	md := zipfile.ParseExtraMetadata([]byte{0x55, 0x54, 0x01, 0x00, 0x01})
+	if md.Modified != nil {
+		t.Errorf("expected no modified time from a truncated timestamp field")
+	}
+}
+
+func TestCompressionMethodName(t *testing.T) {
+	if name := zipfile.CompressionMethodName(8); name != "deflate" {
+		t.Errorf("expected deflate, got %s", name)
+	}
+	if name := zipfile.CompressionMethodName(1234); name != "method-1234" {
+		t.Errorf("expected method-1234, got %s", name)
+	}
+}
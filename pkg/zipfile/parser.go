@@ -2,6 +2,7 @@ package zipfile
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"encoding/binary"
@@ -10,11 +11,21 @@ import (
 	"io/fs"
 	"log/slog"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ozkatz/cloudzip/pkg/metrics"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 )
 
 const (
 	EOCDPrefetchBufferSize = 65536 // 64kb is more than enough
+	cdReadBufferSize       = 1024 * 1024
+	localHeaderSize        = 30
 	Zip64HeaderId          = 0x0001
+	// Zstd is the compression method ID of Zstandard (APPNOTE.TXT 4.4.5), not supported by archive/zip
+	Zstd uint16 = 93
 )
 
 var (
@@ -35,6 +46,7 @@ type EOCD struct {
 	TotalCDRs         uint16
 	CDSizeBytes       uint32
 	CDByteOffset      uint32
+	CommentLength     uint16
 }
 
 type EOCD64 struct {
@@ -92,22 +104,40 @@ type localHeader struct {
 }
 
 type CDR struct {
-	CompressionMethod     uint16
-	Modified              time.Time
-	CRC32Uncompressed     uint32
-	CompressedSizeBytes   uint64
-	UncompressedSizeBytes uint64
-	Mode                  fs.FileMode
-	LocalFileHeaderOffset uint64
-	FileName              string
-	ExtraFields           []byte
-	FileComment           []byte
+	CompressionMethod      uint16
+	Modified               time.Time
+	CRC32Uncompressed      uint32
+	CompressedSizeBytes    uint64
+	UncompressedSizeBytes  uint64
+	Mode                   fs.FileMode
+	LocalFileHeaderOffset  uint64
+	FileName               string
+	ExtraFields            []byte
+	FileComment            []byte
+	CreatorVersion         uint16
+	VersionNeededToExtract uint16
+	Flags                  uint16
+	InternalAttributes     uint16
+	ExternalAttributes     uint32
+	Zip64                  bool
+}
+
+// Encrypted returns true if the member's data is encrypted
+func (c *CDR) Encrypted() bool {
+	return c.Flags&0x1 != 0
+}
+
+// HasDataDescriptor returns true if sizes and CRC are (also) written after the member's data
+func (c *CDR) HasDataDescriptor() bool {
+	return c.Flags&0x8 != 0
 }
 
 type CDLocation struct {
 	SizeBytes uint64
 	Offset    uint64
 	Zip64     bool
+	Records   uint64
+	Comment   []byte
 }
 
 type OffsetFetcher interface {
@@ -130,6 +160,7 @@ func (p *CentralDirectoryParser) getEOCDBuffer() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer func() { _ = closeReader(r) }()
 	return io.ReadAll(r)
 }
 
@@ -148,6 +179,11 @@ func (p *CentralDirectoryParser) getCDLocation() (*CDLocation, error) {
 	if err != nil {
 		return nil, ErrInvalidZip
 	}
+	var comment []byte
+	commentStart := eocdStartOffset + binary.Size(eocd)
+	if commentEnd := commentStart + int(eocd.CommentLength); commentEnd <= len(buf) {
+		comment = buf[commentStart:commentEnd]
+	}
 	// check if zip64
 	if eocd.CurrentDiskNumber == 0xffff ||
 		eocd.CDDiskNumber == 0xffff ||
@@ -155,13 +191,20 @@ func (p *CentralDirectoryParser) getCDLocation() (*CDLocation, error) {
 		eocd.TotalCDRs == 0xffff ||
 		eocd.CDByteOffset == 0xffffffff ||
 		eocd.CDSizeBytes == 0xffffffff {
-		return p.getCD64Location(buf)
+		loc, err := p.getCD64Location(buf)
+		if err != nil {
+			return nil, err
+		}
+		loc.Comment = comment
+		return loc, nil
 	}
 
 	return &CDLocation{
 		SizeBytes: uint64(eocd.CDSizeBytes),
 		Offset:    uint64(eocd.CDByteOffset),
 		Zip64:     false,
+		Records:   uint64(eocd.TotalCDRs),
+		Comment:   comment,
 	}, nil
 }
 
@@ -181,6 +224,7 @@ func (p *CentralDirectoryParser) getCD64Location(buf []byte) (*CDLocation, error
 		SizeBytes: eocd.CDSizeBytes,
 		Offset:    eocd.CDByteOffset,
 		Zip64:     true,
+		Records:   eocd.TotalCDRs,
 	}, nil
 }
 
@@ -232,6 +276,11 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 	cdr.CRC32Uncompressed = metadata.CRC32Uncompressed
 	cdr.CompressionMethod = metadata.CompressionMethod
 	cdr.Modified = msDosTimeToTime(metadata.ModDate, metadata.ModTime)
+	cdr.CreatorVersion = metadata.CreatorVersion
+	cdr.VersionNeededToExtract = metadata.VersionNeededToExtract
+	cdr.Flags = metadata.GeneralPurposeBitFlag
+	cdr.InternalAttributes = metadata.InternalFileAttributes
+	cdr.ExternalAttributes = metadata.ExternalFileAttributes
 
 	var mode fs.FileMode
 	switch metadata.CreatorVersion >> 8 {
@@ -243,7 +292,7 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 
 	fileNameBuffer := make([]byte, metadata.FileNameLength)
 	if metadata.FileNameLength > 0 {
-		_, err = r.Read(fileNameBuffer)
+		_, err = io.ReadFull(r, fileNameBuffer)
 		if err != nil {
 			return nil, err
 		}
@@ -251,7 +300,7 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 
 	extraFieldBuffer := make([]byte, metadata.ExtraFieldLength)
 	if metadata.ExtraFieldLength > 0 {
-		_, err = r.Read(extraFieldBuffer)
+		_, err = io.ReadFull(r, extraFieldBuffer)
 		if err != nil {
 			return nil, err
 		}
@@ -259,7 +308,7 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 
 	fileCommentBuffer := make([]byte, metadata.FileCommentLength)
 	if metadata.FileCommentLength > 0 {
-		_, err = r.Read(fileCommentBuffer)
+		_, err = io.ReadFull(r, fileCommentBuffer)
 		if err != nil {
 			return nil, err
 		}
@@ -270,6 +319,7 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 	cdr.FileComment = fileCommentBuffer
 
 	zip64Fields := parseZip64ExtraFields(cdr.ExtraFields)
+	cdr.Zip64 = zip64Fields != nil
 
 	if metadata.UncompressedSizeBytesRaw == 0xffffffff {
 		// zip64
@@ -309,49 +359,133 @@ func ReadCDR(r io.Reader) (*CDR, error) {
 	return cdr, nil
 }
 
-func (p *CentralDirectoryParser) parseCDR(loc *CDLocation) ([]*CDR, error) {
+// CentralDirectoryIterator parses central directory records incrementally from a streaming body,
+// so callers can start consuming entries before the entire central directory has been downloaded.
+type CentralDirectoryIterator struct {
+	reader  *countingReader
+	body    io.Reader
+	loc     *CDLocation
+	records int
+	start   time.Time
+	done    bool
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Location returns the location and size of the central directory being iterated, as found in the EOCD
+func (it *CentralDirectoryIterator) Location() *CDLocation {
+	return it.loc
+}
+
+// Next returns the next record in the central directory, or io.EOF once all records were read.
+// The central directory is closed once all records were read, or on error.
+func (it *CentralDirectoryIterator) Next() (*CDR, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+	if it.reader.n >= int64(it.loc.SizeBytes) {
+		_ = it.Close()
+		slog.Debug("parse Central Directory",
+			"records", it.records, "size_bytes", it.reader.n, "took_ms", time.Since(it.start).Milliseconds())
+		return nil, io.EOF
+	}
+	cdr, err := ReadCDR(it.reader)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		_ = it.Close()
+		return nil, ErrInvalidZip
+	} else if err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+	it.records++
+	return cdr, nil
+}
+
+// Close releases the reader of the central directory, for iterations stopping before reaching its end
+func (it *CentralDirectoryIterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done = true
+	return closeReader(it.body)
+}
+
+// Iterator returns a CentralDirectoryIterator over the records of the central directory
+func (p *CentralDirectoryParser) Iterator() (*CentralDirectoryIterator, error) {
+	loc, err := p.getCDLocation()
+	if err != nil {
+		return nil, err
+	}
+	return p.iterator(loc)
+}
+
+func (p *CentralDirectoryParser) iterator(loc *CDLocation) (*CentralDirectoryIterator, error) {
 	reader, err := p.reader.Fetch(offset(loc.Offset), offset(loc.Offset+loc.SizeBytes))
 	if err != nil {
 		return nil, ErrInvalidZip
 	}
-	start := time.Now()
-	buf, err := io.ReadAll(reader)
-	slog.Debug("read Central Directory",
-		"size_bytes", len(buf), "took_ms", time.Since(start).Milliseconds())
+	return &CentralDirectoryIterator{
+		reader: &countingReader{r: bufio.NewReaderSize(reader, cdReadBufferSize)},
+		body:   reader,
+		loc:    loc,
+		start:  time.Now(),
+	}, nil
+}
+
+func (p *CentralDirectoryParser) parseCDR(loc *CDLocation) ([]*CDR, error) {
+	it, err := p.iterator(loc)
 	if err != nil {
 		return nil, err
 	}
-
-	parsingStart := time.Now()
-	r := bytes.NewReader(buf)
 	records := make([]*CDR, 0)
-	var pos int64
-	for pos < int64(loc.SizeBytes) {
-		cdr, err := ReadCDR(r)
-		if err != nil {
+	for {
+		cdr, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
 			return nil, err
 		}
 		records = append(records, cdr)
-		pos, err = r.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return nil, err
-		}
 	}
-	slog.Debug("parse Central Directory",
-		"records", len(records), "took_ms", time.Since(parsingStart).Milliseconds())
 	return records, nil
 }
 
-func (p *CentralDirectoryParser) GetCentralDirectory() ([]*CDR, error) {
+func (p *CentralDirectoryParser) GetCentralDirectory() (records []*CDR, err error) {
+	reader, span := startSpan(p.reader, "CentralDirectoryParser.GetCentralDirectory")
+	defer func() { tracing.End(span, err) }()
+	p = &CentralDirectoryParser{reader: reader}
+
+	start := time.Now()
 	loc, err := p.getCDLocation()
 	if err != nil {
 		return nil, err
 	}
-	return p.parseCDR(loc)
+	span.SetAttributes(
+		attribute.Int64("cz.cd.offset", int64(loc.Offset)),
+		attribute.Int64("cz.cd.size", int64(loc.SizeBytes)),
+		attribute.Bool("cz.cd.zip64", loc.Zip64))
+	records, err = p.parseCDR(loc)
+	if err != nil {
+		return nil, err
+	}
+	metrics.CentralDirectoryParseDuration.Observe(time.Since(start).Seconds())
+	metrics.CentralDirectoryRecords.Observe(float64(len(records)))
+	span.SetAttributes(attribute.Int("cz.cd.records", len(records)))
+	return records, nil
 }
 
-func ReaderForRecord(f *CDR, fetcher OffsetFetcher) (io.Reader, error) {
-	// found record!
+// RawReaderForRecord returns a reader for the compressed bytes of f, fetching its local header
+// and data with a single request
+func RawReaderForRecord(f *CDR, fetcher OffsetFetcher) (io.Reader, error) {
 	off := f.LocalFileHeaderOffset
 	approxHeaderSize := uint64(localHeaderSizeHeuristic(f.FileName))
 	approxTotalSize := f.CompressedSizeBytes + approxHeaderSize
@@ -364,40 +498,109 @@ func ReaderForRecord(f *CDR, fetcher OffsetFetcher) (io.Reader, error) {
 	h := &localHeader{}
 	err = binary.Read(dataReader, binary.LittleEndian, h)
 	if err != nil {
+		_ = closeReader(dataReader)
 		return nil, ErrInvalidZip
 	}
+	if f.CompressedSizeBytes == 0 {
+		// no data to read past the local header, however large its extra field is
+		_ = closeReader(dataReader)
+		return bytes.NewReader(nil), nil
+	}
 
 	// read local header
 	bodyStartsAt := h.ExtraFieldLength + h.FileNameLength
-	n, err := dataReader.Read(make([]byte, bodyStartsAt))
-	if err != nil || n != int(bodyStartsAt) {
+	if uint64(localHeaderSize)+uint64(bodyStartsAt) > approxHeaderSize {
+		// the extra field is larger than assumed (e.g. alignment padding), so the data wasn't fully fetched
+		_ = closeReader(dataReader)
+		start := int64(off) + localHeaderSize + int64(bodyStartsAt)
+		end := start + int64(f.CompressedSizeBytes) - 1
+		return fetcher.Fetch(&start, &end)
+	}
+	if _, err := io.ReadFull(dataReader, make([]byte, bodyStartsAt)); err != nil {
+		_ = closeReader(dataReader)
 		return nil, ErrInvalidZip
 	}
-	// limit reader to the size of the compressed bytes
-	dataReader = io.LimitReader(dataReader, int64(f.CompressedSizeBytes))
+	// limit reader to the size of the compressed bytes, closing the fetched body when closed
+	return &readCloser{
+		Reader: io.LimitReader(dataReader, int64(f.CompressedSizeBytes)),
+		close:  func() error { return closeReader(dataReader) },
+	}, nil
+}
+
+func ReaderForRecord(f *CDR, fetcher OffsetFetcher) (r io.Reader, err error) {
+	fetcher, span := startSpan(fetcher, "ReaderForRecord",
+		attribute.String("cz.member.name", f.FileName),
+		attribute.Int64("cz.member.compressed_size", int64(f.CompressedSizeBytes)),
+		attribute.Int("cz.member.compression_method", int(f.CompressionMethod)))
+	defer func() { tracing.End(span, err) }()
+
+	dataReader, err := RawReaderForRecord(f, fetcher)
+	if err != nil {
+		return nil, err
+	}
 
 	// now we should have a stream of the body, let's see if we have need to inflate it:
-	if f.CompressionMethod == zip.Deflate {
-		return flate.NewReader(dataReader), nil
+	switch f.CompressionMethod {
+	case zip.Deflate:
+		inflater := flate.NewReader(dataReader)
+		return &readCloser{Reader: inflater, close: func() error {
+			_ = inflater.Close()
+			return closeReader(dataReader)
+		}}, nil
+	case Zstd:
+		decoder, err := zstd.NewReader(dataReader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			_ = closeReader(dataReader)
+			return nil, err
+		}
+		return &readCloser{Reader: decoder, close: func() error {
+			decoder.Close()
+			return closeReader(dataReader)
+		}}, nil
 	}
 	return dataReader, nil
 }
 
+// DataOffset reads the local file header of f and returns the offset in the archive
+// at which its (possibly compressed) data starts
+func DataOffset(f *CDR, fetcher OffsetFetcher) (int64, error) {
+	off := f.LocalFileHeaderOffset
+	headerReader, err := fetcher.Fetch(offset(off), offset(off+localHeaderSize-1))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = closeReader(headerReader) }()
+	h := &localHeader{}
+	err = binary.Read(headerReader, binary.LittleEndian, h)
+	if err != nil {
+		return 0, ErrInvalidZip
+	}
+	return int64(off) + localHeaderSize + int64(h.FileNameLength) + int64(h.ExtraFieldLength), nil
+}
+
 func (p *CentralDirectoryParser) readerForRecord(f *CDR) (io.Reader, error) {
 	return ReaderForRecord(f, p.reader)
 }
 
+// Read returns a reader for the given file, scanning the central directory until it is found.
+// Use an Archive when reading more than one file from the same zip.
 func (p *CentralDirectoryParser) Read(fileName string) (io.Reader, error) {
-	directory, err := p.GetCentralDirectory()
+	it, err := p.Iterator()
 	if err != nil {
 		return nil, err
 	}
-	for _, f := range directory {
+	defer func() { _ = it.Close() }()
+	for {
+		f, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrFileNotFound
+		} else if err != nil {
+			return nil, err
+		}
 		if f.FileName == fileName {
 			return p.readerForRecord(f)
 		}
 	}
-	return nil, ErrFileNotFound
 }
 
 func localHeaderSizeHeuristic(filename string) int64 {
@@ -405,3 +608,21 @@ func localHeaderSizeHeuristic(filename string) int64 {
 	headerSize := int64(30 + nameLength) // we are at the extra field, not knowing its size
 	return headerSize + 1024             // assume 1k variable length field as worst case
 }
+
+// readCloser reads from Reader, closing the reader it was derived from (as returned by a fetcher) when closed
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// closeReader closes r if it is an io.Closer, as the readers returned by fetchers are
+func closeReader(r io.Reader) error {
+	if closer, ok := r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
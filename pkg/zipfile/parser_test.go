@@ -1,11 +1,14 @@
 package zipfile_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"errors"
 	"hash/crc32"
 	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/ozkatz/cloudzip/pkg/remote"
@@ -106,6 +109,42 @@ func TestCentralDirectoryParser_Read(t *testing.T) {
 	}
 }
 
+func TestCentralDirectoryParser_ReadLargeExtra(t *testing.T) {
+	// an extra field larger than the local header size heuristic, as written for alignment padding
+	extra := make([]byte, 4+8192)
+	extra[0], extra[1] = 0x35, 0xd9
+	extra[2], extra[3] = 0x00, 0x20
+	content := strings.Repeat("data after a large extra field\n", 100)
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: zipfile.CompressionMethodName(method) + ".txt", Method: method, Extra: extra})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p := memParser(buf.Bytes())
+	for _, name := range []string{"store.txt", "deflate.txt"} {
+		r, err := p.Read(name)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", name, err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: got %d bytes, expected %d", name, len(data), len(content))
+		}
+	}
+}
+
 func TestCentralDirectoryParser_GetCentralDirectory64FromStdlib(t *testing.T) {
 	p, err := parser("file://testdata/zip64.zip")
 	if err != nil {
@@ -182,3 +221,85 @@ func testZip(t *testing.T, path string) {
 		}
 	})
 }
+
+func TestCentralDirectoryIterator_Next(t *testing.T) {
+	p, err := parser("file://testdata/regular.zip")
+	if err != nil {
+		t.Fatalf("unexpected error opening zip file: %v", err)
+	}
+	expected, err := p.GetCentralDirectory()
+	if err != nil {
+		t.Fatalf("unexpected error listing zip file: %v", err)
+	}
+	it, err := p.Iterator()
+	if err != nil {
+		t.Fatalf("unexpected error creating iterator: %v", err)
+	}
+	var i int
+	for {
+		f, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error iterating: %v", err)
+		}
+		if i >= len(expected) {
+			t.Fatalf("iterator returned more than %d records", len(expected))
+		}
+		if f.FileName != expected[i].FileName || f.CRC32Uncompressed != expected[i].CRC32Uncompressed {
+			t.Errorf("record %d: expected %s, got %s", i, expected[i].FileName, f.FileName)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("expected %d records, got %d", len(expected), i)
+	}
+	// exhausted iterators keep returning io.EOF
+	if _, err := it.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// TestCentralDirectoryParser_CompareStdlib cross-validates the parser against archive/zip,
+// reading the same remote object through remote.ReaderAt
+func TestCentralDirectoryParser_CompareStdlib(t *testing.T) {
+	zipFiles := []string{
+		"file://testdata/regular.zip",
+		"file://testdata/uncompressed.zip",
+		"file://testdata/zip64.zip",
+	}
+	for _, zipFile := range zipFiles {
+		t.Run(zipFile, func(t *testing.T) {
+			fetcher, err := remote.Object(zipFile)
+			if err != nil {
+				t.Fatalf("unexpected error opening zip file: %v", err)
+			}
+			readerAt, err := remote.NewReaderAt(context.Background(), fetcher)
+			if err != nil {
+				t.Fatalf("unexpected error creating ReaderAt: %v", err)
+			}
+			stdlib, err := zip.NewReader(readerAt, readerAt.Size())
+			if err != nil {
+				t.Fatalf("archive/zip could not read file: %v", err)
+			}
+			p := zipfile.NewCentralDirectoryParser(zipfile.NewStorageAdapter(context.Background(), fetcher))
+			files, err := p.GetCentralDirectory()
+			if err != nil {
+				t.Fatalf("unexpected error listing zip file: %v", err)
+			}
+			if len(files) != len(stdlib.File) {
+				t.Fatalf("expected %d files, got %d", len(stdlib.File), len(files))
+			}
+			for i, expected := range stdlib.File {
+				f := files[i]
+				if strings.TrimSuffix(expected.Name, "/") != f.FileName ||
+					expected.CRC32 != f.CRC32Uncompressed ||
+					expected.UncompressedSize64 != f.UncompressedSizeBytes ||
+					expected.CompressedSize64 != f.CompressedSizeBytes ||
+					expected.Method != f.CompressionMethod {
+					t.Errorf("record %d differs: expected %+v, got %+v", i, expected.FileHeader, f)
+				}
+			}
+		})
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"io"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/tracing"
 )
 
 type StorageAdapter struct {
@@ -22,3 +26,21 @@ func NewStorageAdapter(ctx context.Context, f remote.Fetcher) *StorageAdapter {
 func (z *StorageAdapter) Fetch(start, end *int64) (io.Reader, error) {
 	return z.f.Fetch(z.ctx, start, end)
 }
+
+// FetcherContext returns the context fetches made by fetcher are bound to, if it has one
+func FetcherContext(fetcher OffsetFetcher) context.Context {
+	if adapter, ok := fetcher.(*StorageAdapter); ok {
+		return adapter.ctx
+	}
+	return context.Background()
+}
+
+// startSpan starts a span as a child of the context fetcher reads with, returning a fetcher reading
+// within the span so that its fetches are traced as children of it
+func startSpan(fetcher OffsetFetcher, name string, attrs ...attribute.KeyValue) (OffsetFetcher, trace.Span) {
+	ctx, span := tracing.Start(FetcherContext(fetcher), name, attrs...)
+	if adapter, ok := fetcher.(*StorageAdapter); ok {
+		return NewStorageAdapter(ctx, adapter.f), span
+	}
+	return fetcher, span
+}
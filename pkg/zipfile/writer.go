@@ -0,0 +1,69 @@
+package zipfile
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+const (
+	eocdSignature          = 0x06054b50
+	eocd64Signature        = 0x06064b50
+	eocd64LocatorSignature = 0x07064b50
+	eocd64RecordSize       = 56
+	zip64Version           = 45
+)
+
+// WriteEOCD writes the end of central directory record(s) for a central directory of records entries,
+// cdSize bytes long, starting at cdOffset. A ZIP64 end of central directory record and locator
+// are written first if any of the values doesn't fit the classic record.
+func WriteEOCD(w io.Writer, records, cdSize, cdOffset uint64, comment []byte) error {
+	if len(comment) > math.MaxUint16 {
+		comment = comment[:math.MaxUint16]
+	}
+	zip64 := records >= math.MaxUint16 || cdSize >= math.MaxUint32 || cdOffset >= math.MaxUint32
+	buf := make([]byte, 0, eocd64RecordSize+20+22+len(comment))
+	le := binary.LittleEndian
+	if zip64 {
+		buf = le.AppendUint32(buf, eocd64Signature)
+		buf = le.AppendUint64(buf, eocd64RecordSize-12) // size of the remaining record
+		buf = le.AppendUint16(buf, zip64Version)        // version made by
+		buf = le.AppendUint16(buf, zip64Version)        // version needed to extract
+		buf = le.AppendUint32(buf, 0)                   // number of this disk
+		buf = le.AppendUint32(buf, 0)                   // disk where the central directory starts
+		buf = le.AppendUint64(buf, records)             // records on this disk
+		buf = le.AppendUint64(buf, records)             // total records
+		buf = le.AppendUint64(buf, cdSize)
+		buf = le.AppendUint64(buf, cdOffset)
+
+		buf = le.AppendUint32(buf, eocd64LocatorSignature)
+		buf = le.AppendUint32(buf, 0)               // disk of the zip64 end of central directory
+		buf = le.AppendUint64(buf, cdOffset+cdSize) // offset of the zip64 end of central directory
+		buf = le.AppendUint32(buf, 1)               // total number of disks
+
+		records = min(records, math.MaxUint16)
+		cdSize = min(cdSize, math.MaxUint32)
+		cdOffset = min(cdOffset, math.MaxUint32)
+	}
+	buf = le.AppendUint32(buf, eocdSignature)
+	buf = le.AppendUint16(buf, 0) // number of this disk
+	buf = le.AppendUint16(buf, 0) // disk where the central directory starts
+	buf = le.AppendUint16(buf, uint16(records))
+	buf = le.AppendUint16(buf, uint16(records))
+	buf = le.AppendUint32(buf, uint32(cdSize))
+	buf = le.AppendUint32(buf, uint32(cdOffset))
+	buf = le.AppendUint16(buf, uint16(len(comment)))
+	buf = append(buf, comment...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// CentralDirectoryRecordSize returns the size in bytes of the central directory record starting at r,
+// leaving r positioned at the start of the next record
+func CentralDirectoryRecordSize(r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	if _, err := ReadCDR(counter); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
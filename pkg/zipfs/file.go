@@ -0,0 +1,240 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+const (
+	// maxForwardSkip is the largest gap a forward Seek will be served by reading (and discarding)
+	// from the current stream, rather than opening a new one.
+	maxForwardSkip = 1024 * 1024
+)
+
+// file is a member of the archive. Reads are streamed from the remote archive.
+// Stored (uncompressed) members map Seek and ReadAt directly to range requests,
+// deflated members are re-read from their beginning when seeking backwards.
+type file struct {
+	fsys   *FS
+	name   string
+	info   *fileInfo
+	record *zipfile.CDR
+
+	offsetOnce sync.Once
+	dataOffset int64
+	offsetErr  error
+
+	reader    io.Reader
+	readerPos int64
+	pos       int64
+	closed    bool
+}
+
+var (
+	_ io.ReaderAt = &file{}
+	_ io.Seeker   = &file{}
+)
+
+func (f *file) size() int64 {
+	return int64(f.record.UncompressedSizeBytes)
+}
+
+func (f *file) stored() bool {
+	return f.record.CompressionMethod == zip.Store
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) getDataOffset() (int64, error) {
+	f.offsetOnce.Do(func() {
+		f.dataOffset, f.offsetErr = zipfile.DataOffset(f.record, f.fsys.archive.Fetcher())
+	})
+	return f.dataOffset, f.offsetErr
+}
+
+func closeReader(r io.Reader) {
+	if closer, ok := r.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// open returns a reader for the uncompressed content of the file, starting at the given position
+func (f *file) open(at int64, length int64) (io.Reader, error) {
+	if f.stored() {
+		dataOffset, err := f.getDataOffset()
+		if err != nil {
+			return nil, err
+		}
+		start := dataOffset + at
+		end := start + length - 1
+		return f.fsys.archive.Fetcher().Fetch(&start, &end)
+	}
+	r, err := zipfile.ReaderForRecord(f.record, f.fsys.archive.Fetcher())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, at); err != nil {
+		closeReader(r)
+		return nil, err
+	}
+	return r, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.pos >= f.size() {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.reader != nil && f.readerPos < f.pos && f.pos-f.readerPos <= maxForwardSkip {
+		n, err := io.CopyN(io.Discard, f.reader, f.pos-f.readerPos)
+		f.readerPos += n
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	}
+	if f.reader == nil || f.readerPos != f.pos {
+		if f.reader != nil {
+			closeReader(f.reader)
+		}
+		r, err := f.open(f.pos, f.size()-f.pos)
+		if err != nil {
+			f.reader = nil
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.reader = r
+		f.readerPos = f.pos
+	}
+	if remaining := f.size() - f.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.reader.Read(p)
+	f.pos += int64(n)
+	f.readerPos += int64(n)
+	if errors.Is(err, io.EOF) && f.pos < f.size() {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= f.size() {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), f.size()-off)
+	if want == 0 {
+		return 0, nil
+	}
+	r, err := f.open(off, want)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	defer closeReader(r)
+	n, err := io.ReadFull(r, p[:want])
+	if err != nil {
+		return n, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	// the actual stream is (re)positioned lazily, on the next Read
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.reader != nil {
+		closeReader(f.reader)
+		f.reader = nil
+	}
+	return nil
+}
+
+// dir is a directory in the archive, either explicit or synthesized
+type dir struct {
+	fsys    *FS
+	name    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	offset  int
+}
+
+var _ fs.ReadDirFile = &dir{}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
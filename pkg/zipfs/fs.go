@@ -0,0 +1,178 @@
+// Package zipfs exposes a remote zip archive as a read-only io/fs.FS,
+// so it can be passed to http.FS, template.ParseFS, fs.WalkDir and friends without mounting it.
+package zipfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ozkatz/cloudzip/pkg/mount/commonfs"
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+)
+
+// FS is a read-only file system backed by a remote zip archive.
+// Directories missing from the archive's central directory are synthesized, the same way cz mount does.
+type FS struct {
+	archive *zipfile.Archive
+	tree    commonfs.Tree
+}
+
+var (
+	_ fs.FS         = &FS{}
+	_ fs.ReadDirFS  = &FS{}
+	_ fs.StatFS     = &FS{}
+	_ fs.ReadFileFS = &FS{}
+	_ fs.GlobFS     = &FS{}
+)
+
+// Open reads the central directory of the zip file at uri and returns an FS for it
+func Open(ctx context.Context, uri string, opts ...remote.ObjectOpt) (*FS, error) {
+	obj, err := remote.Object(uri, opts...)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zipfile.NewArchive(zipfile.NewStorageAdapter(ctx, obj))
+	if err != nil {
+		return nil, err
+	}
+	return New(archive)
+}
+
+// New returns an FS for an already parsed archive
+func New(archive *zipfile.Archive) (*FS, error) {
+	infos := make(commonfs.FileInfoList, 0, archive.Len())
+	for _, f := range archive.Records() {
+		if !fs.ValidPath(f.FileName) || f.FileName == "." {
+			continue // absolute paths, "..", etc. can't be addressed through fs.FS
+		}
+		infos = append(infos, commonfs.ImmutableInfo(
+			f.FileName, f.Modified, f.Mode, int64(f.UncompressedSizeBytes), nil))
+	}
+	sort.Sort(infos)
+	var zero time.Time
+	tree := commonfs.NewInMemoryTreeBuilder(func(entry string) *commonfs.FileInfo {
+		return commonfs.ImmutableDir(entry, zero)
+	})
+	if err := tree.Index(infos); err != nil {
+		return nil, err
+	}
+	return &FS{
+		archive: archive,
+		tree:    tree,
+	}, nil
+}
+
+// Archive returns the underlying archive
+func (fsys *FS) Archive() *zipfile.Archive {
+	return fsys.archive
+}
+
+func treePath(name string) string {
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+func (fsys *FS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.tree.Stat(treePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{FileInfo: info, name: path.Base(name)}, nil
+}
+
+// Open implements fs.FS
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{fsys: fsys, name: name, info: info}, nil
+	}
+	record, err := fsys.archive.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &file{fsys: fsys, name: name, info: info, record: record, dataOffset: -1}, nil
+}
+
+// Stat implements fs.StatFS
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return fsys.readDir(name)
+}
+
+func (fsys *FS) readDir(name string) ([]fs.DirEntry, error) {
+	children, err := fsys.tree.Readdir(treePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	sort.Sort(children)
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = fs.FileInfoToDirEntry(child)
+	}
+	return entries, nil
+}
+
+// ReadFile implements fs.ReadFileFS
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	if _, isDir := f.(*dir); isDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	data := make([]byte, f.(*file).size())
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// readDirOnly hides FS.Glob from fs.Glob, so it falls back to matching using ReadDir
+type readDirOnly struct {
+	fs.ReadDirFS
+}
+
+// Glob implements fs.GlobFS
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	return fs.Glob(readDirOnly{fsys}, pattern)
+}
+
+type fileInfo struct {
+	*commonfs.FileInfo
+	name string
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
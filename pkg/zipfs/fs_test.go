@@ -0,0 +1,192 @@
+package zipfs_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ozkatz/cloudzip/pkg/remote"
+	"github.com/ozkatz/cloudzip/pkg/zipfile"
+	"github.com/ozkatz/cloudzip/pkg/zipfs"
+)
+
+type byteReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (b *byteReadSeekCloser) Close() error {
+	return nil
+}
+
+func memFS(t *testing.T, files map[string]string) *zipfs.FS {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".bin") {
+			method = zip.Store
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatalf("could not create zip entry: %v", err)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			t.Fatalf("could not write zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not write zip: %v", err)
+	}
+	fetcher := remote.NewLocalFetcherFromData(&byteReadSeekCloser{Reader: bytes.NewReader(buf.Bytes())})
+	archive, err := zipfile.NewArchive(zipfile.NewStorageAdapter(context.Background(), fetcher))
+	if err != nil {
+		t.Fatalf("could not parse zip: %v", err)
+	}
+	fsys, err := zipfs.New(archive)
+	if err != nil {
+		t.Fatalf("could not create fs: %v", err)
+	}
+	return fsys
+}
+
+func TestFS(t *testing.T) {
+	fsys := memFS(t, map[string]string{
+		"index.html":          "<html>hello</html>",
+		"a/b/c.txt":           strings.Repeat("lorem ipsum dolor sit amet ", 1000),
+		"a/b/stored.bin":      strings.Repeat("0123456789", 500),
+		"a/empty.bin":         "",
+		"explicit/":           "",
+		"explicit/inner.json": `{"hello": "world"}`,
+	})
+	err := fstest.TestFS(fsys,
+		"index.html", "a/b/c.txt", "a/b/stored.bin", "a/empty.bin", "explicit/inner.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFS_RemoteFile(t *testing.T) {
+	fsys, err := zipfs.Open(context.Background(), "file://../zipfile/testdata/regular.zip")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fstest.TestFS(fsys, "a/b/c/d.txt", "baz.txt", "foo/bar.txt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(fsys, "foo/bar.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "file in a directory!\n" {
+		t.Errorf("got wrong string: %s\n", string(data))
+	}
+}
+
+func TestFS_SeekReadAt(t *testing.T) {
+	content := strings.Repeat("abcdefghij", 100)
+	fsys := memFS(t, map[string]string{"deflated.txt": content, "stored.bin": content})
+	for _, name := range []string{"deflated.txt", "stored.bin"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := fsys.Open(name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer func() { _ = f.Close() }()
+			seeker := f.(io.ReadSeeker)
+			if _, err := seeker.Seek(-5, io.SeekEnd); err != nil {
+				t.Fatalf("unexpected error seeking: %v", err)
+			}
+			tail, err := io.ReadAll(seeker)
+			if err != nil {
+				t.Fatalf("unexpected error reading: %v", err)
+			}
+			if string(tail) != "fghij" {
+				t.Errorf("expected tail 'fghij', got '%s'", tail)
+			}
+			buf := make([]byte, 4)
+			if _, err := f.(io.ReaderAt).ReadAt(buf, 12); err != nil {
+				t.Fatalf("unexpected error in ReadAt: %v", err)
+			}
+			if string(buf) != "cdef" {
+				t.Errorf("expected 'cdef', got '%s'", buf)
+			}
+		})
+	}
+}
+
+// trackingFetcher counts the readers it returned that weren't closed yet
+type trackingFetcher struct {
+	next remote.Fetcher
+	open *atomic.Int64
+}
+
+func (f *trackingFetcher) Fetch(ctx context.Context, start, end *int64) (io.ReadCloser, error) {
+	r, err := f.next.Fetch(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	f.open.Add(1)
+	return &trackedReader{ReadCloser: r, open: f.open}, nil
+}
+
+type trackedReader struct {
+	io.ReadCloser
+	open *atomic.Int64
+}
+
+func (r *trackedReader) Close() error {
+	r.open.Add(-1)
+	return r.ReadCloser.Close()
+}
+
+func TestFS_ReadAtPastContentCloses(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	fw, err := w.CreateHeader(&zip.FileHeader{Name: "short.txt", Method: zip.Deflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// claim a larger uncompressed size in the central directory than the member decompresses to
+	data := buf.Bytes()
+	cd := bytes.LastIndex(data, []byte("PK\x01\x02"))
+	binary.LittleEndian.PutUint32(data[cd+24:], 1000)
+
+	open := &atomic.Int64{}
+	fetcher := &trackingFetcher{
+		next: remote.NewLocalFetcherFromData(&byteReadSeekCloser{Reader: bytes.NewReader(data)}),
+		open: open,
+	}
+	archive, err := zipfile.NewArchive(zipfile.NewStorageAdapter(context.Background(), fetcher))
+	if err != nil {
+		t.Fatalf("could not parse zip: %v", err)
+	}
+	fsys, err := zipfs.New(archive)
+	if err != nil {
+		t.Fatalf("could not create fs: %v", err)
+	}
+	f, err := fsys.Open("short.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.(io.ReaderAt).ReadAt(make([]byte, 10), 500); err == nil {
+		t.Fatalf("expected an error reading past the member's content")
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("%d readers left open", n)
+	}
+}